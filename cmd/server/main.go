@@ -1,23 +1,59 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"go-fhir-server/internal/app"
 	"go-fhir-server/internal/config"
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/storage"
+	"go-fhir-server/internal/storage/file"
 	"go-fhir-server/internal/storage/memory"
+	"go-fhir-server/internal/storage/postgres"
 )
 
 func main() {
 	cfg := config.FromEnv()
+	registry := fhir.DefaultRegistry()
 
-	// MVP storage (swap later with Postgres/Firestore/etc.)
-	patientStore := memory.NewPatientStore()
+	// In-memory unless FHIR_DATABASE_URL or FHIR_DATA_DIR asks for durable
+	// storage.
+	var store storage.ResourceStore = memory.NewStore(memory.WithIndexes(registry))
+	switch {
+	case cfg.DatabaseURL != "":
+		pg, err := postgres.Open(cfg.DatabaseURL, postgres.WithIndexes(registry))
+		if err != nil {
+			log.Fatalf("open database: %v", err)
+		}
+		defer pg.Close()
+		store = pg
+		log.Printf("storing data in PostgreSQL")
+	case cfg.DataDir != "":
+		fs, err := file.Open(cfg.DataDir, file.WithIndexes(registry))
+		if err != nil {
+			log.Fatalf("open data dir %s: %v", cfg.DataDir, err)
+		}
+		defer func() {
+			if err := fs.Close(); err != nil {
+				log.Printf("close data dir: %v", err)
+			}
+		}()
+		store = fs
+		log.Printf("storing data in %s", cfg.DataDir)
+	}
 
 	handler := app.New(app.Deps{
-		PatientStore: patientStore,
-		Logger:       log.Default(),
+		Store:    store,
+		Registry: registry,
+		Config:   cfg,
+		Logger:   log.Default(),
 	})
 
 	srv := &http.Server{
@@ -25,6 +61,25 @@ func main() {
 		Handler: handler,
 	}
 
+	// Shut down cleanly on SIGINT/SIGTERM so the store can be closed.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("shutdown: %v", err)
+		}
+	}()
+
 	log.Printf("listening on %s", srv.Addr)
-	log.Fatal(srv.ListenAndServe())
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Print(err)
+		return
+	}
+	// Let in-flight requests finish before the store is closed.
+	<-drained
 }
@@ -2,3 +2,5 @@
 module go-fhir-server
 
 go 1.22
+
+require github.com/lib/pq v1.10.9
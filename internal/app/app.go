@@ -5,16 +5,26 @@ import (
 	"net/http"
 	"time"
 
+	"go-fhir-server/internal/config"
+	"go-fhir-server/internal/fhir"
 	"go-fhir-server/internal/httpapi/middleware"
 	"go-fhir-server/internal/storage"
 )
 
 type Deps struct {
-	PatientStore storage.PatientStore
-	Logger       *log.Logger
+	Store storage.ResourceStore
+	// Registry lists the resource types served under /fhir/{type}.
+	// Defaults to fhir.DefaultRegistry() when nil.
+	Registry *fhir.Registry
+	Config   config.Config
+	Logger   *log.Logger
 }
 
 func New(d Deps) http.Handler {
+	if d.Registry == nil {
+		d.Registry = fhir.DefaultRegistry()
+	}
+
 	mux := http.NewServeMux()
 
 	// Routes
@@ -12,8 +12,8 @@ import (
 
 func TestApp_RoutesSmoke(t *testing.T) {
 	h := app.New(app.Deps{
-		PatientStore: memory.NewPatientStore(),
-		Logger:       log.Default(),
+		Store:  memory.NewStore(),
+		Logger: log.Default(),
 	})
 
 	req := httptest.NewRequest(http.MethodGet, "/ping", nil)
@@ -13,11 +13,20 @@ func registerRoutes(mux *http.ServeMux, d Deps) {
 	// Health
 	mux.Handle("/ping", handlers.Ping())
 
+	var opts []handlers.Option
+	if d.Config.MultipleConditionalDelete {
+		opts = append(opts, handlers.WithConditionalDelete(handlers.ConditionalDeleteMultiple))
+	}
+	if d.Config.MaxPageSize > 0 {
+		opts = append(opts, handlers.WithMaxPageSize(d.Config.MaxPageSize))
+	}
+
 	// FHIR Metadata
-	mux.Handle("/fhir/metadata", handlers.Metadata())
+	mux.Handle("/fhir/metadata", handlers.Metadata(d.Registry, opts...))
 
-	// FHIR Patient
-	patientHandler := handlers.Patient(d.PatientStore)
-	mux.Handle("/fhir/Patient", patientHandler)
-	mux.Handle("/fhir/Patient/", patientHandler) // /fhir/Patient/{id}
+	// FHIR REST API for every registered resource type:
+	// /fhir/{type} and /fhir/{type}/{id}, plus batch/transaction at /fhir
+	resource := handlers.Resource(d.Registry, d.Store, opts...)
+	mux.Handle("/fhir", resource)
+	mux.Handle("/fhir/", resource)
 }
@@ -0,0 +1,75 @@
+package fhir
+
+import "errors"
+
+// Code systems of AllergyIntolerance.clinicalStatus and verificationStatus.
+const (
+	allergyClinical     = "http://terminology.hl7.org/CodeSystem/allergyintolerance-clinical"
+	allergyVerification = "http://terminology.hl7.org/CodeSystem/allergyintolerance-verification"
+)
+
+// AllergyIntolerance describes the AllergyIntolerance resource and its search
+// parameters. The patient must exist on this server.
+func AllergyIntolerance() ResourceType {
+	return ResourceType{
+		Name: "AllergyIntolerance",
+		SearchParams: []SearchParam{
+			{Name: "clinical-status", Type: SearchToken, Paths: []string{"clinicalStatus"}},
+			{Name: "verification-status", Type: SearchToken, Paths: []string{"verificationStatus"}},
+			{Name: "code", Type: SearchToken, Paths: []string{"code", "reaction.substance"}},
+			{Name: "type", Type: SearchToken, Paths: []string{"type"}},
+			{Name: "category", Type: SearchToken, Paths: []string{"category"}},
+			{Name: "criticality", Type: SearchToken, Paths: []string{"criticality"}},
+			{Name: "date", Type: SearchDate, Paths: []string{"recordedDate"}},
+			{Name: "onset", Type: SearchDate, Paths: []string{"reaction.onset"}},
+			{Name: "patient", Type: SearchReference, Paths: []string{"patient"}, Targets: []string{"Patient"}},
+		},
+		Validate:   validateAllergyIntolerance,
+		References: []ReferenceRule{{Path: "patient", Targets: []string{"Patient"}}},
+		Elements: elements(
+			[]string{
+				"identifier", "category", "note", "reaction", "reaction.manifestation", "reaction.note",
+			},
+			[]string{
+				"clinicalStatus", "verificationStatus", "type", "criticality", "code", "patient",
+				"encounter", "onset[x]", "recordedDate", "recorder", "asserter", "lastOccurrence",
+				"reaction.substance", "reaction.description", "reaction.onset", "reaction.severity",
+				"reaction.exposureRoute",
+			},
+		),
+	}
+}
+
+// validateAllergyIntolerance checks the required patient, the code value
+// sets and invariants ait-1 and ait-2.
+func validateAllergyIntolerance(resource map[string]any) error {
+	if err := requireElements(resource, "patient"); err != nil {
+		return err
+	}
+	if err := atMostOneChoice(resource, "onset"); err != nil {
+		return err
+	}
+	if _, err := checkCode(resource, "type", "allergy", "intolerance"); err != nil {
+		return err
+	}
+	if _, err := checkCode(resource, "criticality", "low", "high", "unable-to-assess"); err != nil {
+		return err
+	}
+	if _, err := checkConcept(resource, "clinicalStatus", allergyClinical, "active", "inactive", "resolved"); err != nil {
+		return err
+	}
+	verification, err := checkConcept(resource, "verificationStatus", allergyVerification,
+		"unconfirmed", "confirmed", "refuted", "entered-in-error")
+	if err != nil {
+		return err
+	}
+
+	if verification == "entered-in-error" {
+		if resource["clinicalStatus"] != nil {
+			return errors.New("AllergyIntolerance.clinicalStatus must not be present when verificationStatus is entered-in-error")
+		}
+	} else if resource["clinicalStatus"] == nil {
+		return errors.New("AllergyIntolerance.clinicalStatus is required unless verificationStatus is entered-in-error")
+	}
+	return nil
+}
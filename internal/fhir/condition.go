@@ -0,0 +1,75 @@
+package fhir
+
+import "errors"
+
+// Code systems of Condition.clinicalStatus and Condition.verificationStatus.
+const (
+	conditionClinical     = "http://terminology.hl7.org/CodeSystem/condition-clinical"
+	conditionVerification = "http://terminology.hl7.org/CodeSystem/condition-ver-status"
+)
+
+// Condition describes the Condition resource, used for problem lists, and
+// its search parameters. The subject must be a Patient on this server.
+func Condition() ResourceType {
+	return ResourceType{
+		Name: "Condition",
+		SearchParams: []SearchParam{
+			{Name: "clinical-status", Type: SearchToken, Paths: []string{"clinicalStatus"}},
+			{Name: "verification-status", Type: SearchToken, Paths: []string{"verificationStatus"}},
+			{Name: "code", Type: SearchToken, Paths: []string{"code"}},
+			{Name: "category", Type: SearchToken, Paths: []string{"category"}},
+			{Name: "onset-date", Type: SearchDate, Paths: []string{"onsetDateTime", "onsetPeriod"}},
+			{Name: "recorded-date", Type: SearchDate, Paths: []string{"recordedDate"}},
+			{Name: "subject", Type: SearchReference, Paths: []string{"subject"}, Targets: []string{"Patient", "Group"}},
+			{Name: "patient", Type: SearchReference, Paths: []string{"subject"}, Targets: []string{"Patient"}},
+			{Name: "encounter", Type: SearchReference, Paths: []string{"encounter"}, Targets: []string{"Encounter"}},
+		},
+		Validate:   validateCondition,
+		References: []ReferenceRule{{Path: "subject", Targets: []string{"Patient"}}},
+		Elements: elements(
+			[]string{
+				"identifier", "category", "bodySite", "stage", "evidence", "note", "stage.assessment",
+				"evidence.code", "evidence.detail",
+			},
+			[]string{
+				"clinicalStatus", "verificationStatus", "severity", "code", "subject", "encounter",
+				"onset[x]", "abatement[x]", "recordedDate", "recorder", "asserter", "stage.summary",
+				"stage.type",
+			},
+		),
+	}
+}
+
+// validateCondition checks the required subject, the status value sets and
+// invariants con-4 and con-5.
+func validateCondition(resource map[string]any) error {
+	if err := requireElements(resource, "subject"); err != nil {
+		return err
+	}
+	for _, choice := range []string{"onset", "abatement"} {
+		if err := atMostOneChoice(resource, choice); err != nil {
+			return err
+		}
+	}
+	clinical, err := checkConcept(resource, "clinicalStatus", conditionClinical,
+		"active", "recurrence", "relapse", "inactive", "remission", "resolved")
+	if err != nil {
+		return err
+	}
+	verification, err := checkConcept(resource, "verificationStatus", conditionVerification,
+		"unconfirmed", "provisional", "differential", "confirmed", "refuted", "entered-in-error")
+	if err != nil {
+		return err
+	}
+
+	if verification == "entered-in-error" && resource["clinicalStatus"] != nil {
+		return errors.New("Condition.clinicalStatus must not be present when verificationStatus is entered-in-error")
+	}
+	if len(choiceElements(resource, "abatement")) > 0 {
+		switch clinical {
+		case "active", "recurrence", "relapse":
+			return errors.New("Condition.clinicalStatus must be inactive, remission or resolved when abatement[x] is present")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package fhir
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Required value sets of Timing.repeat.
+var (
+	unitsOfTime  = []string{"s", "min", "h", "d", "wk", "mo", "a"}
+	daysOfWeek   = []string{"mon", "tue", "wed", "thu", "fri", "sat", "sun"}
+	eventTimings = []string{
+		"MORN", "MORN.early", "MORN.late", "NOON", "AFT", "AFT.early", "AFT.late",
+		"EVE", "EVE.early", "EVE.late", "NIGHT", "PHS", "HS", "WAKE",
+		"C", "CM", "CD", "CV", "AC", "ACM", "ACD", "ACV", "PC", "PCM", "PCD", "PCV",
+	}
+)
+
+var timeOfDayRe = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]:([0-5][0-9]|60)(\.[0-9]+)?$`)
+
+// checkDosages validates every Dosage in resource[name], such as
+// MedicationRequest.dosageInstruction: one asNeeded[x], one dose[x] and
+// rate[x] per doseAndRate, and a well-formed timing.
+func checkDosages(resource map[string]any, name string) error {
+	raw, ok := resource[name]
+	if !ok {
+		return nil
+	}
+	dosages, ok := raw.([]any)
+	if !ok {
+		return fmt.Errorf("%s.%s must be an array", resource["resourceType"], name)
+	}
+	for i, d := range dosages {
+		where := fmt.Sprintf("%s.%s[%d]", resource["resourceType"], name, i)
+		dosage, ok := d.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s must be an object", where)
+		}
+		if err := checkChoices(dosage, where, "asNeeded"); err != nil {
+			return err
+		}
+		doseAndRate, _ := dosage["doseAndRate"].([]any)
+		for j, dr := range doseAndRate {
+			m, _ := dr.(map[string]any)
+			if err := checkChoices(m, fmt.Sprintf("%s.doseAndRate[%d]", where, j), "dose", "rate"); err != nil {
+				return err
+			}
+		}
+		if raw, ok := dosage["timing"]; ok {
+			timing, ok := raw.(map[string]any)
+			if !ok {
+				return fmt.Errorf("%s.timing must be an object", where)
+			}
+			if err := checkTiming(timing, where+".timing"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkChoices reports an element of where with several variants of any of
+// the choice elements names.
+func checkChoices(element map[string]any, where string, names ...string) error {
+	for _, name := range names {
+		if found := choiceElements(element, name); len(found) > 1 {
+			return fmt.Errorf("%s has more than one %s[x]: %s", where, name, strings.Join(found, ", "))
+		}
+	}
+	return nil
+}
+
+// checkTiming validates Timing.repeat against its value sets and the
+// invariants tim-1 to tim-10.
+func checkTiming(timing map[string]any, where string) error {
+	raw, ok := timing["repeat"]
+	if !ok {
+		return nil
+	}
+	repeat, ok := raw.(map[string]any)
+	if !ok {
+		return fmt.Errorf("%s.repeat must be an object", where)
+	}
+	where += ".repeat"
+	if err := checkChoices(repeat, where, "bounds"); err != nil {
+		return err
+	}
+
+	for _, name := range []string{"count", "countMax", "frequency", "frequencyMax"} {
+		if v, ok := repeat[name]; ok && !isInteger(v, 1) {
+			return fmt.Errorf("%s.%s must be a positive integer", where, name)
+		}
+	}
+	if v, ok := repeat["offset"]; ok && !isInteger(v, 0) {
+		return fmt.Errorf("%s.offset must be a non-negative integer", where)
+	}
+	for _, name := range []string{"duration", "durationMax", "period", "periodMax"} {
+		if v, ok := repeat[name]; ok {
+			if n, isNum := v.(float64); !isNum || n < 0 {
+				return fmt.Errorf("%s.%s must be a non-negative number", where, name)
+			}
+		}
+	}
+	for _, name := range []string{"durationUnit", "periodUnit"} {
+		if v, ok := repeat[name]; ok {
+			if unit, _ := v.(string); !slices.Contains(unitsOfTime, unit) {
+				return fmt.Errorf("%s.%s must be one of %s", where, name, strings.Join(unitsOfTime, ", "))
+			}
+		}
+	}
+	for _, pair := range [][2]string{
+		{"duration", "durationUnit"}, // tim-1
+		{"period", "periodUnit"},     // tim-2
+		{"periodMax", "period"},      // tim-6
+		{"durationMax", "duration"},  // tim-7
+		{"countMax", "count"},        // tim-8
+	} {
+		if repeat[pair[0]] != nil && repeat[pair[1]] == nil {
+			return fmt.Errorf("%s.%s requires %s", where, pair[0], pair[1])
+		}
+	}
+
+	if err := checkStrings(repeat, where, "dayOfWeek", func(v string) bool { return slices.Contains(daysOfWeek, v) }); err != nil {
+		return err
+	}
+	if err := checkStrings(repeat, where, "timeOfDay", timeOfDayRe.MatchString); err != nil {
+		return err
+	}
+	if err := checkStrings(repeat, where, "when", func(v string) bool { return slices.Contains(eventTimings, v) }); err != nil {
+		return err
+	}
+	when, _ := repeat["when"].([]any)
+	if repeat["timeOfDay"] != nil && len(when) > 0 {
+		return fmt.Errorf("%s must not have both timeOfDay and when", where) // tim-10
+	}
+	if repeat["offset"] != nil {
+		// tim-9: an offset is relative to a when other than a meal itself.
+		if len(when) == 0 {
+			return fmt.Errorf("%s.offset requires when", where)
+		}
+		for _, w := range when {
+			switch w {
+			case "C", "CM", "CD", "CV":
+				return fmt.Errorf("%s.offset can't be used with when %s", where, w)
+			}
+		}
+	}
+	return nil
+}
+
+// checkStrings reports an element[name] that isn't an array of strings all
+// accepted by valid.
+func checkStrings(element map[string]any, where, name string, valid func(string) bool) error {
+	raw, ok := element[name]
+	if !ok {
+		return nil
+	}
+	list, ok := raw.([]any)
+	if !ok {
+		return fmt.Errorf("%s.%s must be an array", where, name)
+	}
+	for _, v := range list {
+		if s, ok := v.(string); !ok || !valid(s) {
+			return fmt.Errorf("%s.%s has an invalid value %v", where, name, v)
+		}
+	}
+	return nil
+}
+
+// isInteger reports whether v is a JSON number holding an integer of at
+// least min.
+func isInteger(v any, min float64) bool {
+	n, ok := v.(float64)
+	return ok && n == math.Trunc(n) && n >= min
+}
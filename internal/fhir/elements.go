@@ -0,0 +1,106 @@
+package fhir
+
+import "strings"
+
+// dataTypeElements gives the cardinality of the elements of the general
+// purpose data types (CodeableConcept, HumanName, Timing, Dosage, ...) by
+// name. Every name here has the same cardinality in each data type that has
+// it, which is what lets them be looked up without the data type.
+var dataTypeElements = map[string]bool{
+	"coding": true, "given": true, "prefix": true, "suffix": true, "line": true,
+	"event": true, "dayOfWeek": true, "timeOfDay": true, "when": true,
+	"additionalInstruction": true, "doseAndRate": true,
+	"extension": true, "modifierExtension": true,
+
+	"id": false, "system": false, "version": false, "code": false, "display": false,
+	"userSelected": false, "text": false, "use": false, "type": false,
+	"value": false, "period": false, "assigner": false, "start": false, "end": false,
+	"family": false, "city": false, "district": false, "state": false,
+	"postalCode": false, "country": false, "rank": false, "reference": false,
+	"identifier": false, "unit": false, "comparator": false, "low": false,
+	"high": false, "numerator": false, "denominator": false, "contentType": false,
+	"language": false, "data": false, "url": false, "size": false, "hash": false,
+	"title": false, "creation": false, "author[x]": false, "time": false,
+	"sequence": false, "timing": false, "asNeeded[x]": false, "site": false,
+	"route": false, "method": false, "dose[x]": false, "rate[x]": false,
+	"maxDosePerPeriod": false, "maxDosePerAdministration": false,
+	"maxDosePerLifetime": false, "patientInstruction": false, "repeat": false,
+	"bounds[x]": false, "count": false, "countMax": false, "duration": false,
+	"durationMax": false, "durationUnit": false, "frequency": false,
+	"frequencyMax": false, "periodMax": false, "periodUnit": false, "offset": false,
+}
+
+// elements builds a ResourceType.Elements map from the type's repeating and
+// single elements, adding the ones every resource has.
+func elements(repeating, single []string) map[string]bool {
+	m := map[string]bool{
+		"id": false, "meta": false, "implicitRules": false, "language": false, "text": false,
+		"contained": true, "extension": true, "modifierExtension": true,
+	}
+	for _, e := range repeating {
+		m[e] = true
+	}
+	for _, e := range single {
+		m[e] = false
+	}
+	return m
+}
+
+// Repeats reports whether the element at path, a dotted element path from
+// the resource such as "name.given", has a maximum cardinality above 1.
+// known is false when the element isn't in t.Elements or, below a data type
+// element, in dataTypeElements.
+func (t ResourceType) Repeats(path string) (repeats, known bool) {
+	steps := strings.Split(path, ".")
+	for i := range steps {
+		prefix := strings.Join(steps[:i+1], ".")
+		repeats, known = lookupElement(t.Elements, prefix)
+		if !known && i > 0 {
+			// Backbone elements have an id and extensions like any element.
+			switch steps[i] {
+			case "id":
+				repeats, known = false, true
+			case "extension", "modifierExtension":
+				repeats, known = true, true
+			}
+		}
+		if !known || i == len(steps)-1 {
+			return repeats, known
+		}
+		if steps[0] == "contained" {
+			return false, false
+		}
+		if !t.isBackbone(prefix) {
+			// The rest of the path is inside a data type.
+			return lookupElement(dataTypeElements, steps[len(steps)-1])
+		}
+	}
+	return false, false
+}
+
+// isBackbone reports whether t.Elements lists children of path.
+func (t ResourceType) isBackbone(path string) bool {
+	for e := range t.Elements {
+		if strings.HasPrefix(e, path+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupElement finds name in m, matching a choice element such as
+// "value[x]" by any of its typed names ("valueQuantity").
+func lookupElement(m map[string]bool, name string) (repeats, ok bool) {
+	if repeats, ok = m[name]; ok {
+		return repeats, true
+	}
+	for e, r := range m {
+		base, isChoice := strings.CutSuffix(e, "[x]")
+		if isChoice && len(name) > len(base) && strings.HasPrefix(name, base) {
+			if c := name[len(base)]; c >= 'A' && c <= 'Z' {
+				return r, true
+			}
+		}
+	}
+	return false, false
+}
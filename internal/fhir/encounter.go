@@ -0,0 +1,114 @@
+package fhir
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// encounterTransitions is the Encounter status state machine: the statuses
+// each status may move to. Statuses not listed as keys are terminal, and any
+// status may be corrected to entered-in-error.
+var encounterTransitions = map[string][]string{
+	"planned":     {"arrived", "triaged", "in-progress", "cancelled"},
+	"arrived":     {"triaged", "in-progress", "cancelled"},
+	"triaged":     {"in-progress", "cancelled"},
+	"in-progress": {"onleave", "finished", "cancelled"},
+	"onleave":     {"in-progress", "finished", "cancelled"},
+	"unknown":     {"planned", "arrived", "triaged", "in-progress", "onleave", "finished", "cancelled"},
+}
+
+// Encounter describes the Encounter resource and its search parameters.
+// Status changes follow encounterTransitions and are recorded in
+// statusHistory.
+func Encounter() ResourceType {
+	return ResourceType{
+		Name: "Encounter",
+		SearchParams: []SearchParam{
+			{Name: "status", Type: SearchToken, Paths: []string{"status"}},
+			{Name: "class", Type: SearchToken, Paths: []string{"class"}},
+			{Name: "type", Type: SearchToken, Paths: []string{"type"}},
+			{Name: "date", Type: SearchDate, Paths: []string{"period"}},
+			{Name: "subject", Type: SearchReference, Paths: []string{"subject"}, Targets: []string{"Patient", "Group"}},
+			{Name: "patient", Type: SearchReference, Paths: []string{"subject"}, Targets: []string{"Patient"}},
+			{Name: "service-provider", Type: SearchReference, Paths: []string{"serviceProvider"}, Targets: []string{"Organization"}},
+			{Name: "episode-of-care", Type: SearchReference, Paths: []string{"episodeOfCare"}, Targets: []string{"EpisodeOfCare"}},
+			{Name: "participant", Type: SearchReference, Paths: []string{"participant.individual"}, Targets: []string{"Practitioner", "PractitionerRole", "RelatedPerson"}},
+		},
+		Validate: validateEncounter,
+		References: []ReferenceRule{
+			{Path: "subject", Targets: []string{"Patient"}},
+			{Path: "episodeOfCare", Targets: []string{"EpisodeOfCare"}},
+		},
+		Transition: transitionEncounter,
+		Elements: elements(
+			[]string{
+				"identifier", "statusHistory", "classHistory", "type", "episodeOfCare", "basedOn",
+				"participant", "appointment", "reasonCode", "reasonReference", "diagnosis", "account",
+				"location", "participant.type", "hospitalization.dietPreference",
+				"hospitalization.specialCourtesy", "hospitalization.specialArrangement",
+			},
+			[]string{
+				"status", "class", "serviceType", "priority", "subject", "period", "length",
+				"hospitalization", "serviceProvider", "partOf", "statusHistory.status",
+				"statusHistory.period", "classHistory.class", "classHistory.period",
+				"participant.period", "participant.individual", "diagnosis.condition", "diagnosis.use",
+				"diagnosis.rank", "hospitalization.preAdmissionIdentifier", "hospitalization.origin",
+				"hospitalization.admitSource", "hospitalization.reAdmission",
+				"hospitalization.destination", "hospitalization.dischargeDisposition",
+				"location.location", "location.status", "location.physicalType", "location.period",
+			},
+		),
+	}
+}
+
+func validateEncounter(resource map[string]any) error {
+	if err := requireElements(resource, "status", "class"); err != nil {
+		return err
+	}
+	_, err := checkCode(resource, "status",
+		"planned", "arrived", "triaged", "in-progress", "onleave", "finished", "cancelled", "entered-in-error", "unknown")
+	return err
+}
+
+func transitionEncounter(current, next map[string]any, force bool) error {
+	from, _ := current["status"].(string)
+	to, _ := next["status"].(string)
+	if from != to && to != "entered-in-error" && !force && !slices.Contains(encounterTransitions[from], to) {
+		return fmt.Errorf("Encounter.status cannot change from %s to %s", from, to)
+	}
+	keepStatusHistory(current, next, time.Now())
+	return nil
+}
+
+// keepStatusHistory carries the statusHistory of current over to next,
+// ignoring any the client sent, and when the status changes appends the
+// status being left with a period ending at now. The period starts where the
+// previous entry ended, or else at the resource's own period.start.
+func keepStatusHistory(current, next map[string]any, now time.Time) {
+	history, _ := current["statusHistory"].([]any)
+	history = slices.Clone(history)
+
+	from, _ := current["status"].(string)
+	if to, _ := next["status"].(string); from != "" && to != from {
+		period := map[string]any{"end": now.UTC().Format(time.RFC3339)}
+		var start any
+		if len(history) > 0 {
+			last, _ := history[len(history)-1].(map[string]any)
+			lastPeriod, _ := last["period"].(map[string]any)
+			start = lastPeriod["end"]
+		} else if p, ok := current["period"].(map[string]any); ok {
+			start = p["start"]
+		}
+		if start != nil {
+			period["start"] = start
+		}
+		history = append(history, map[string]any{"status": from, "period": period})
+	}
+
+	if len(history) > 0 {
+		next["statusHistory"] = history
+	} else {
+		delete(next, "statusHistory")
+	}
+}
@@ -0,0 +1,46 @@
+package fhir
+
+import "time"
+
+// EpisodeOfCare describes the EpisodeOfCare resource and its search
+// parameters. Status changes are recorded in statusHistory, as for Encounter,
+// but any change is allowed.
+func EpisodeOfCare() ResourceType {
+	return ResourceType{
+		Name: "EpisodeOfCare",
+		SearchParams: []SearchParam{
+			{Name: "status", Type: SearchToken, Paths: []string{"status"}},
+			{Name: "type", Type: SearchToken, Paths: []string{"type"}},
+			{Name: "date", Type: SearchDate, Paths: []string{"period"}},
+			{Name: "patient", Type: SearchReference, Paths: []string{"patient"}, Targets: []string{"Patient"}},
+			{Name: "organization", Type: SearchReference, Paths: []string{"managingOrganization"}, Targets: []string{"Organization"}},
+			{Name: "care-manager", Type: SearchReference, Paths: []string{"careManager"}, Targets: []string{"Practitioner", "PractitionerRole"}},
+		},
+		Validate:   validateEpisodeOfCare,
+		References: []ReferenceRule{{Path: "patient", Targets: []string{"Patient"}}},
+		Transition: func(current, next map[string]any, force bool) error {
+			keepStatusHistory(current, next, time.Now())
+			return nil
+		},
+		Elements: elements(
+			[]string{
+				"identifier", "statusHistory", "type", "diagnosis", "referralRequest", "team",
+				"account",
+			},
+			[]string{
+				"status", "patient", "managingOrganization", "period", "careManager",
+				"statusHistory.status", "statusHistory.period", "diagnosis.condition", "diagnosis.role",
+				"diagnosis.rank",
+			},
+		),
+	}
+}
+
+func validateEpisodeOfCare(resource map[string]any) error {
+	if err := requireElements(resource, "status", "patient"); err != nil {
+		return err
+	}
+	_, err := checkCode(resource, "status",
+		"planned", "waitlist", "active", "onhold", "finished", "cancelled", "entered-in-error")
+	return err
+}
@@ -0,0 +1,65 @@
+package fhir
+
+import (
+	"errors"
+	"math"
+)
+
+// Location describes the Location resource and its search parameters,
+// including near for Locations with a position. partOf, when present, must be
+// another Location on this server; partof:below searches the whole hierarchy
+// beneath one.
+func Location() ResourceType {
+	return ResourceType{
+		Name: "Location",
+		SearchParams: []SearchParam{
+			{Name: "identifier", Type: SearchToken, Paths: []string{"identifier"}},
+			{Name: "name", Type: SearchString, Paths: []string{"name", "alias"}},
+			{Name: "type", Type: SearchToken, Paths: []string{"type"}},
+			{Name: "status", Type: SearchToken, Paths: []string{"status"}},
+			{Name: "address", Type: SearchString, Paths: []string{"address"}},
+			{Name: "address-city", Type: SearchString, Paths: []string{"address.city"}},
+			{Name: "address-state", Type: SearchString, Paths: []string{"address.state"}},
+			{Name: "address-postalcode", Type: SearchString, Paths: []string{"address.postalCode"}},
+			{Name: "near", Type: SearchSpecial, Paths: []string{"position"}},
+			{Name: "partof", Type: SearchReference, Paths: []string{"partOf"}, Targets: []string{"Location"}},
+			{Name: "organization", Type: SearchReference, Paths: []string{"managingOrganization"}, Targets: []string{"Organization"}},
+		},
+		Validate: validateLocation,
+		References: []ReferenceRule{
+			{Path: "partOf", Targets: []string{"Location"}},
+			{Path: "managingOrganization", Targets: []string{"Organization"}},
+		},
+		Elements: elements(
+			[]string{
+				"identifier", "alias", "type", "telecom", "hoursOfOperation", "endpoint",
+				"hoursOfOperation.daysOfWeek",
+			},
+			[]string{
+				"status", "operationalStatus", "name", "description", "mode", "address", "physicalType",
+				"position", "managingOrganization", "partOf", "availabilityExceptions",
+				"position.longitude", "position.latitude", "position.altitude",
+				"hoursOfOperation.allDay", "hoursOfOperation.openingTime",
+				"hoursOfOperation.closingTime",
+			},
+		),
+	}
+}
+
+func validateLocation(resource map[string]any) error {
+	if _, err := checkCode(resource, "status", "active", "suspended", "inactive"); err != nil {
+		return err
+	}
+	if err := checkNotPartOfItself(resource); err != nil {
+		return err
+	}
+	if raw, ok := resource["position"]; ok {
+		pos, _ := raw.(map[string]any)
+		lat, okLat := pos["latitude"].(float64)
+		lng, okLng := pos["longitude"].(float64)
+		if !okLat || !okLng || math.Abs(lat) > 90 || math.Abs(lng) > 180 {
+			return errors.New("Location.position needs a latitude between -90 and 90 and a longitude between -180 and 180")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package fhir
+
+// Medication describes the Medication resource, which the medication
+// workflow resources either reference or contain, and its search parameters.
+func Medication() ResourceType {
+	return ResourceType{
+		Name: "Medication",
+		SearchParams: []SearchParam{
+			{Name: "identifier", Type: SearchToken, Paths: []string{"identifier"}},
+			{Name: "code", Type: SearchToken, Paths: []string{"code"}},
+			{Name: "status", Type: SearchToken, Paths: []string{"status"}},
+			{Name: "form", Type: SearchToken, Paths: []string{"form"}},
+			{Name: "ingredient-code", Type: SearchToken, Paths: []string{"ingredient.itemCodeableConcept"}},
+			{Name: "lot-number", Type: SearchToken, Paths: []string{"batch.lotNumber"}},
+			{Name: "expiration-date", Type: SearchDate, Paths: []string{"batch.expirationDate"}},
+			{Name: "manufacturer", Type: SearchReference, Paths: []string{"manufacturer"}, Targets: []string{"Organization"}},
+		},
+		Validate: func(resource map[string]any) error {
+			_, err := checkCode(resource, "status", "active", "inactive", "entered-in-error")
+			return err
+		},
+		References: []ReferenceRule{{Path: "manufacturer", Targets: []string{"Organization"}}},
+		Elements: elements(
+			[]string{
+				"identifier", "ingredient",
+			},
+			[]string{
+				"code", "status", "manufacturer", "form", "amount", "batch", "ingredient.item[x]",
+				"ingredient.isActive", "ingredient.strength", "batch.lotNumber", "batch.expirationDate",
+			},
+		),
+	}
+}
+
+// medicationSearchParams returns the parameters the medication workflow
+// resources share, followed by extra. medication searches referenced
+// Medications and code the medicationCodeableConcept.
+func medicationSearchParams(extra ...SearchParam) []SearchParam {
+	return append([]SearchParam{
+		{Name: "identifier", Type: SearchToken, Paths: []string{"identifier"}},
+		{Name: "status", Type: SearchToken, Paths: []string{"status"}},
+		{Name: "code", Type: SearchToken, Paths: []string{"medicationCodeableConcept"}},
+		{Name: "medication", Type: SearchReference, Paths: []string{"medicationReference"}, Targets: []string{"Medication"}},
+		{Name: "subject", Type: SearchReference, Paths: []string{"subject"}, Targets: []string{"Patient", "Group"}},
+		{Name: "patient", Type: SearchReference, Paths: []string{"subject"}, Targets: []string{"Patient"}},
+	}, extra...)
+}
+
+// medicationReferences returns the reference rules the medication workflow
+// resources share, followed by extra: the subject must be a Patient and
+// medicationReference a Medication, either on this server or contained.
+func medicationReferences(extra ...ReferenceRule) []ReferenceRule {
+	return append([]ReferenceRule{
+		{Path: "subject", Targets: []string{"Patient"}},
+		{Path: "medicationReference", Targets: []string{"Medication"}},
+	}, extra...)
+}
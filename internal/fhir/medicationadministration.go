@@ -0,0 +1,65 @@
+package fhir
+
+import "errors"
+
+// MedicationAdministration describes the MedicationAdministration resource
+// and its search parameters. request, when present, must be a
+// MedicationRequest on this server.
+func MedicationAdministration() ResourceType {
+	return ResourceType{
+		Name: "MedicationAdministration",
+		SearchParams: medicationSearchParams(
+			SearchParam{Name: "effective-time", Type: SearchDate, Paths: []string{"effectiveDateTime", "effectivePeriod"}},
+			SearchParam{Name: "reason-given", Type: SearchToken, Paths: []string{"reasonCode"}},
+			SearchParam{Name: "reason-not-given", Type: SearchToken, Paths: []string{"statusReason"}},
+			SearchParam{Name: "request", Type: SearchReference, Paths: []string{"request"}, Targets: []string{"MedicationRequest"}},
+			SearchParam{Name: "context", Type: SearchReference, Paths: []string{"context"}, Targets: []string{"Encounter", "EpisodeOfCare"}},
+			SearchParam{Name: "performer", Type: SearchReference, Paths: []string{"performer.actor"}, Targets: []string{"Practitioner", "PractitionerRole", "Patient", "RelatedPerson", "Device"}},
+		),
+		Validate: validateMedicationAdministration,
+		References: medicationReferences(
+			ReferenceRule{Path: "request", Targets: []string{"MedicationRequest"}},
+		),
+		Elements: elements(
+			[]string{
+				"identifier", "instantiates", "partOf", "statusReason", "supportingInformation",
+				"performer", "reasonCode", "reasonReference", "device", "note", "eventHistory",
+			},
+			[]string{
+				"status", "category", "medication[x]", "subject", "context", "effective[x]", "request",
+				"dosage", "performer.function", "performer.actor", "dosage.text", "dosage.site",
+				"dosage.route", "dosage.method", "dosage.dose", "dosage.rate[x]",
+			},
+		),
+	}
+}
+
+// validateMedicationAdministration checks the required elements and that the
+// dosage gives a dose or rate (mad-1).
+func validateMedicationAdministration(resource map[string]any) error {
+	if err := requireElements(resource, "status", "subject"); err != nil {
+		return err
+	}
+	for _, choice := range []string{"medication", "effective"} {
+		if err := exactlyOneChoice(resource, choice); err != nil {
+			return err
+		}
+	}
+	if _, err := checkCode(resource, "status",
+		"in-progress", "not-done", "on-hold", "completed", "entered-in-error", "stopped", "unknown"); err != nil {
+		return err
+	}
+	if raw, ok := resource["dosage"]; ok {
+		dosage, ok := raw.(map[string]any)
+		if !ok {
+			return errors.New("MedicationAdministration.dosage must be an object")
+		}
+		if err := checkChoices(dosage, "MedicationAdministration.dosage", "rate"); err != nil {
+			return err
+		}
+		if dosage["dose"] == nil && len(choiceElements(dosage, "rate")) == 0 {
+			return errors.New("MedicationAdministration.dosage needs a dose or rate[x]")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package fhir
+
+// MedicationDispense describes the MedicationDispense resource and its search
+// parameters. authorizingPrescription links a dispense to the
+// MedicationRequests it fills, which must exist on this server.
+func MedicationDispense() ResourceType {
+	return ResourceType{
+		Name: "MedicationDispense",
+		SearchParams: medicationSearchParams(
+			SearchParam{Name: "type", Type: SearchToken, Paths: []string{"type"}},
+			SearchParam{Name: "whenprepared", Type: SearchDate, Paths: []string{"whenPrepared"}},
+			SearchParam{Name: "whenhandedover", Type: SearchDate, Paths: []string{"whenHandedOver"}},
+			SearchParam{Name: "prescription", Type: SearchReference, Paths: []string{"authorizingPrescription"}, Targets: []string{"MedicationRequest"}},
+			SearchParam{Name: "context", Type: SearchReference, Paths: []string{"context"}, Targets: []string{"Encounter", "EpisodeOfCare"}},
+			SearchParam{Name: "destination", Type: SearchReference, Paths: []string{"destination"}, Targets: []string{"Location"}},
+			SearchParam{Name: "performer", Type: SearchReference, Paths: []string{"performer.actor"}, Targets: []string{"Practitioner", "PractitionerRole", "Organization", "Patient", "Device", "RelatedPerson"}},
+			SearchParam{Name: "receiver", Type: SearchReference, Paths: []string{"receiver"}, Targets: []string{"Patient", "Practitioner"}},
+		),
+		Validate: validateMedicationDispense,
+		References: medicationReferences(
+			ReferenceRule{Path: "authorizingPrescription", Targets: []string{"MedicationRequest"}},
+		),
+		Elements: elements(
+			[]string{
+				"identifier", "partOf", "supportingInformation", "performer", "authorizingPrescription",
+				"dosageInstruction", "receiver", "note", "detectedIssue", "eventHistory",
+				"substitution.reason", "substitution.responsibleParty",
+			},
+			[]string{
+				"status", "statusReason[x]", "category", "medication[x]", "subject", "context",
+				"location", "type", "quantity", "daysSupply", "whenPrepared", "whenHandedOver",
+				"destination", "substitution", "performer.function", "performer.actor",
+				"substitution.wasSubstituted", "substitution.type",
+			},
+		),
+	}
+}
+
+func validateMedicationDispense(resource map[string]any) error {
+	if err := requireElements(resource, "status"); err != nil {
+		return err
+	}
+	if err := exactlyOneChoice(resource, "medication"); err != nil {
+		return err
+	}
+	if err := atMostOneChoice(resource, "statusReason"); err != nil {
+		return err
+	}
+	if _, err := checkCode(resource, "status",
+		"preparation", "in-progress", "cancelled", "on-hold", "completed", "entered-in-error", "stopped", "declined", "unknown"); err != nil {
+		return err
+	}
+	return checkDosages(resource, "dosageInstruction")
+}
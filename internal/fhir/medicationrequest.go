@@ -0,0 +1,63 @@
+package fhir
+
+// MedicationRequest describes the MedicationRequest resource, a prescription
+// or medication order, and its search parameters.
+func MedicationRequest() ResourceType {
+	return ResourceType{
+		Name: "MedicationRequest",
+		SearchParams: medicationSearchParams(
+			SearchParam{Name: "intent", Type: SearchToken, Paths: []string{"intent"}},
+			SearchParam{Name: "priority", Type: SearchToken, Paths: []string{"priority"}},
+			SearchParam{Name: "category", Type: SearchToken, Paths: []string{"category"}},
+			SearchParam{Name: "authoredon", Type: SearchDate, Paths: []string{"authoredOn"}},
+			SearchParam{Name: "date", Type: SearchDate, Paths: []string{"dosageInstruction.timing.event"}},
+			SearchParam{Name: "encounter", Type: SearchReference, Paths: []string{"encounter"}, Targets: []string{"Encounter"}},
+			SearchParam{Name: "requester", Type: SearchReference, Paths: []string{"requester"}, Targets: []string{"Practitioner", "PractitionerRole", "Organization", "Patient", "RelatedPerson", "Device"}},
+			SearchParam{Name: "intended-performer", Type: SearchReference, Paths: []string{"performer"}, Targets: []string{"Practitioner", "PractitionerRole", "Organization", "Patient", "RelatedPerson", "Device", "CareTeam", "HealthcareService"}},
+		),
+		Validate:   validateMedicationRequest,
+		References: medicationReferences(),
+		Elements: elements(
+			[]string{
+				"identifier", "category", "supportingInformation", "reasonCode", "reasonReference",
+				"instantiatesCanonical", "instantiatesUri", "basedOn", "insurance", "note",
+				"dosageInstruction", "detectedIssue", "eventHistory",
+			},
+			[]string{
+				"status", "statusReason", "intent", "priority", "doNotPerform", "reported[x]",
+				"medication[x]", "subject", "encounter", "authoredOn", "requester", "performer",
+				"performerType", "recorder", "groupIdentifier", "courseOfTherapyType",
+				"dispenseRequest", "substitution", "priorPrescription", "dispenseRequest.initialFill",
+				"dispenseRequest.initialFill.quantity", "dispenseRequest.initialFill.duration",
+				"dispenseRequest.dispenseInterval", "dispenseRequest.validityPeriod",
+				"dispenseRequest.numberOfRepeatsAllowed", "dispenseRequest.quantity",
+				"dispenseRequest.expectedSupplyDuration", "dispenseRequest.performer",
+				"substitution.allowed[x]", "substitution.reason",
+			},
+		),
+	}
+}
+
+func validateMedicationRequest(resource map[string]any) error {
+	if err := requireElements(resource, "status", "intent", "subject"); err != nil {
+		return err
+	}
+	if err := exactlyOneChoice(resource, "medication"); err != nil {
+		return err
+	}
+	if err := atMostOneChoice(resource, "reported"); err != nil {
+		return err
+	}
+	if _, err := checkCode(resource, "status",
+		"active", "on-hold", "cancelled", "completed", "entered-in-error", "stopped", "draft", "unknown"); err != nil {
+		return err
+	}
+	if _, err := checkCode(resource, "intent",
+		"proposal", "plan", "order", "original-order", "reflex-order", "filler-order", "instance-order", "option"); err != nil {
+		return err
+	}
+	if _, err := checkCode(resource, "priority", "routine", "urgent", "asap", "stat"); err != nil {
+		return err
+	}
+	return checkDosages(resource, "dosageInstruction")
+}
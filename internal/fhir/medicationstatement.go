@@ -0,0 +1,45 @@
+package fhir
+
+// MedicationStatement describes the MedicationStatement resource, a record of
+// a medication being taken, and its search parameters.
+func MedicationStatement() ResourceType {
+	return ResourceType{
+		Name: "MedicationStatement",
+		SearchParams: medicationSearchParams(
+			SearchParam{Name: "category", Type: SearchToken, Paths: []string{"category"}},
+			SearchParam{Name: "effective", Type: SearchDate, Paths: []string{"effectiveDateTime", "effectivePeriod"}},
+			SearchParam{Name: "context", Type: SearchReference, Paths: []string{"context"}, Targets: []string{"Encounter", "EpisodeOfCare"}},
+			SearchParam{Name: "source", Type: SearchReference, Paths: []string{"informationSource"}, Targets: []string{"Patient", "Practitioner", "PractitionerRole", "RelatedPerson", "Organization"}},
+			SearchParam{Name: "part-of", Type: SearchReference, Paths: []string{"partOf"}, Targets: []string{"MedicationAdministration", "MedicationDispense", "MedicationStatement", "Procedure", "Observation"}},
+		),
+		Validate:   validateMedicationStatement,
+		References: medicationReferences(),
+		Elements: elements(
+			[]string{
+				"identifier", "basedOn", "partOf", "statusReason", "derivedFrom", "reasonCode",
+				"reasonReference", "note", "dosage",
+			},
+			[]string{
+				"status", "category", "medication[x]", "subject", "context", "effective[x]",
+				"dateAsserted", "informationSource",
+			},
+		),
+	}
+}
+
+func validateMedicationStatement(resource map[string]any) error {
+	if err := requireElements(resource, "status", "subject"); err != nil {
+		return err
+	}
+	if err := exactlyOneChoice(resource, "medication"); err != nil {
+		return err
+	}
+	if err := atMostOneChoice(resource, "effective"); err != nil {
+		return err
+	}
+	if _, err := checkCode(resource, "status",
+		"active", "completed", "entered-in-error", "intended", "stopped", "on-hold", "unknown", "not-taken"); err != nil {
+		return err
+	}
+	return checkDosages(resource, "dosage")
+}
@@ -5,12 +5,13 @@ import (
 	"time"
 )
 
-func EnsureMeta(resource map[string]any, version int) {
+// EnsureMeta stamps meta.versionId and meta.lastUpdated, creating meta if needed.
+func EnsureMeta(resource map[string]any, version int, lastUpdated time.Time) {
 	meta, _ := resource["meta"].(map[string]any)
 	if meta == nil {
 		meta = map[string]any{}
 		resource["meta"] = meta
 	}
 	meta["versionId"] = strconv.Itoa(version)
-	meta["lastUpdated"] = time.Now().UTC().Format(time.RFC3339)
+	meta["lastUpdated"] = lastUpdated.UTC().Format(time.RFC3339)
 }
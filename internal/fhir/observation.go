@@ -0,0 +1,88 @@
+package fhir
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Observation describes the Observation resource and its search parameters.
+// The subject, when present, must be a Patient on this server.
+func Observation() ResourceType {
+	return ResourceType{
+		Name: "Observation",
+		SearchParams: []SearchParam{
+			{Name: "code", Type: SearchToken, Paths: []string{"code"}},
+			{Name: "category", Type: SearchToken, Paths: []string{"category"}},
+			{Name: "status", Type: SearchToken, Paths: []string{"status"}},
+			{Name: "date", Type: SearchDate, Paths: []string{"effectiveDateTime", "effectivePeriod", "effectiveInstant"}},
+			{Name: "subject", Type: SearchReference, Paths: []string{"subject"}, Targets: []string{"Patient", "Group", "Device", "Location"}},
+			{Name: "patient", Type: SearchReference, Paths: []string{"subject"}, Targets: []string{"Patient"}},
+			{Name: "performer", Type: SearchReference, Paths: []string{"performer"}, Targets: []string{"Practitioner", "PractitionerRole", "Organization", "Patient"}},
+			{Name: "value-quantity", Type: SearchQuantity, Paths: []string{"valueQuantity"}},
+			{Name: "component-code", Type: SearchToken, Paths: []string{"component.code"}},
+			{Name: "component-code-value-quantity", Type: SearchComposite, Paths: []string{"component"}, Components: []SearchParam{
+				{Name: "code", Type: SearchToken, Paths: []string{"code"}},
+				{Name: "value-quantity", Type: SearchQuantity, Paths: []string{"valueQuantity"}},
+			}},
+		},
+		Validate:   validateObservation,
+		References: []ReferenceRule{{Path: "subject", Targets: []string{"Patient"}}},
+		Elements: elements(
+			[]string{
+				"identifier", "basedOn", "partOf", "category", "focus", "performer", "interpretation",
+				"note", "referenceRange", "hasMember", "derivedFrom", "component",
+				"referenceRange.appliesTo", "component.interpretation", "component.referenceRange",
+				"component.referenceRange.appliesTo",
+			},
+			[]string{
+				"status", "code", "subject", "encounter", "effective[x]", "issued", "value[x]",
+				"dataAbsentReason", "bodySite", "method", "specimen", "device", "referenceRange.low",
+				"referenceRange.high", "referenceRange.type", "referenceRange.age",
+				"referenceRange.text", "component.code", "component.value[x]",
+				"component.dataAbsentReason", "component.referenceRange.low",
+				"component.referenceRange.high", "component.referenceRange.type",
+				"component.referenceRange.age", "component.referenceRange.text",
+			},
+		),
+	}
+}
+
+// validateObservation enforces the value[x] rules: exactly one value, and no
+// dataAbsentReason beside it (obs-6). Components have at most one value.
+func validateObservation(resource map[string]any) error {
+	values := choiceElements(resource, "value")
+	switch {
+	case len(values) == 0:
+		return errors.New("Observation.value[x] is required")
+	case len(values) > 1:
+		return fmt.Errorf("Observation has more than one value[x]: %s", strings.Join(values, ", "))
+	}
+	if resource["dataAbsentReason"] != nil {
+		return errors.New("Observation.dataAbsentReason must not be present when value[x] is")
+	}
+
+	if raw, ok := resource["component"]; ok {
+		components, ok := raw.([]any)
+		if !ok {
+			return errors.New("Observation.component must be an array")
+		}
+		for i, c := range components {
+			component, ok := c.(map[string]any)
+			if !ok {
+				return fmt.Errorf("Observation.component[%d] must be an object", i)
+			}
+			if component["code"] == nil {
+				return fmt.Errorf("Observation.component[%d].code is required", i)
+			}
+			values := choiceElements(component, "value")
+			if len(values) > 1 {
+				return fmt.Errorf("Observation.component[%d] has more than one value[x]: %s", i, strings.Join(values, ", "))
+			}
+			if len(values) == 1 && component["dataAbsentReason"] != nil {
+				return fmt.Errorf("Observation.component[%d].dataAbsentReason must not be present when value[x] is", i)
+			}
+		}
+	}
+	return nil
+}
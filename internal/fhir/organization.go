@@ -0,0 +1,37 @@
+package fhir
+
+// Organization describes the Organization resource and its search
+// parameters. partOf, when present, must be another Organization on this
+// server; partof:below searches the whole hierarchy beneath one.
+func Organization() ResourceType {
+	return ResourceType{
+		Name: "Organization",
+		SearchParams: []SearchParam{
+			{Name: "identifier", Type: SearchToken, Paths: []string{"identifier"}},
+			{Name: "name", Type: SearchString, Paths: []string{"name", "alias"}},
+			{Name: "type", Type: SearchToken, Paths: []string{"type"}},
+			{Name: "active", Type: SearchToken, Paths: []string{"active"}},
+			{Name: "address", Type: SearchString, Paths: []string{"address"}},
+			{Name: "address-city", Type: SearchString, Paths: []string{"address.city"}},
+			{Name: "address-state", Type: SearchString, Paths: []string{"address.state"}},
+			{Name: "address-postalcode", Type: SearchString, Paths: []string{"address.postalCode"}},
+			{Name: "partof", Type: SearchReference, Paths: []string{"partOf"}, Targets: []string{"Organization"}},
+		},
+		Validate: func(resource map[string]any) error {
+			if err := checkNPIs(resource); err != nil {
+				return err
+			}
+			return checkNotPartOfItself(resource)
+		},
+		References: []ReferenceRule{{Path: "partOf", Targets: []string{"Organization"}}},
+		Elements: elements(
+			[]string{
+				"identifier", "type", "alias", "telecom", "address", "contact", "endpoint",
+				"contact.telecom",
+			},
+			[]string{
+				"active", "name", "partOf", "contact.purpose", "contact.name", "contact.address",
+			},
+		),
+	}
+}
@@ -12,3 +12,18 @@ func OperationOutcome(message string) map[string]any {
 		},
 	}
 }
+
+// InformationOutcome is a successful OperationOutcome, e.g. for
+// Prefer: return=OperationOutcome.
+func InformationOutcome(message string) map[string]any {
+	return map[string]any{
+		"resourceType": "OperationOutcome",
+		"issue": []map[string]any{
+			{
+				"severity": "information",
+				"code":     "informational",
+				"details":  map[string]any{"text": message},
+			},
+		},
+	}
+}
@@ -0,0 +1,30 @@
+package fhir
+
+// Patient describes the Patient resource and its search parameters.
+func Patient() ResourceType {
+	return ResourceType{
+		Name: "Patient",
+		SearchParams: []SearchParam{
+			{Name: "identifier", Type: SearchToken, Paths: []string{"identifier"}},
+			{Name: "name", Type: SearchString, Paths: []string{"name"}},
+			{Name: "family", Type: SearchString, Paths: []string{"name.family"}},
+			{Name: "given", Type: SearchString, Paths: []string{"name.given"}},
+			{Name: "birthdate", Type: SearchDate, Paths: []string{"birthDate"}},
+			{Name: "gender", Type: SearchToken, Paths: []string{"gender"}},
+			{Name: "general-practitioner", Type: SearchReference, Paths: []string{"generalPractitioner"}, Targets: []string{"Practitioner", "PractitionerRole", "Organization"}},
+			{Name: "organization", Type: SearchReference, Paths: []string{"managingOrganization"}, Targets: []string{"Organization"}},
+		},
+		Elements: elements(
+			[]string{
+				"identifier", "name", "telecom", "address", "photo", "contact", "communication",
+				"generalPractitioner", "link", "contact.relationship", "contact.telecom",
+			},
+			[]string{
+				"active", "gender", "birthDate", "deceased[x]", "maritalStatus", "multipleBirth[x]",
+				"managingOrganization", "contact.name", "contact.address", "contact.gender",
+				"contact.organization", "contact.period", "communication.language",
+				"communication.preferred", "link.other", "link.type",
+			},
+		),
+	}
+}
@@ -0,0 +1,32 @@
+package fhir
+
+// Practitioner describes the Practitioner resource and its search parameters.
+// NPI identifiers must carry a valid check digit.
+func Practitioner() ResourceType {
+	return ResourceType{
+		Name: "Practitioner",
+		SearchParams: []SearchParam{
+			{Name: "identifier", Type: SearchToken, Paths: []string{"identifier"}},
+			{Name: "name", Type: SearchString, Paths: []string{"name"}},
+			{Name: "family", Type: SearchString, Paths: []string{"name.family"}},
+			{Name: "given", Type: SearchString, Paths: []string{"name.given"}},
+			{Name: "address", Type: SearchString, Paths: []string{"address"}},
+			{Name: "address-city", Type: SearchString, Paths: []string{"address.city"}},
+			{Name: "address-state", Type: SearchString, Paths: []string{"address.state"}},
+			{Name: "address-postalcode", Type: SearchString, Paths: []string{"address.postalCode"}},
+			{Name: "telecom", Type: SearchToken, Paths: []string{"telecom"}},
+			{Name: "active", Type: SearchToken, Paths: []string{"active"}},
+		},
+		Validate: checkNPIs,
+		Elements: elements(
+			[]string{
+				"identifier", "name", "telecom", "address", "photo", "qualification", "communication",
+				"qualification.identifier",
+			},
+			[]string{
+				"active", "gender", "birthDate", "qualification.code", "qualification.period",
+				"qualification.issuer",
+			},
+		),
+	}
+}
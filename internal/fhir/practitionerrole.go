@@ -0,0 +1,37 @@
+package fhir
+
+// PractitionerRole describes the PractitionerRole resource, which ties a
+// Practitioner to an Organization and the Locations they work at, and its
+// search parameters. Every reference must resolve on this server.
+func PractitionerRole() ResourceType {
+	return ResourceType{
+		Name: "PractitionerRole",
+		SearchParams: []SearchParam{
+			{Name: "identifier", Type: SearchToken, Paths: []string{"identifier"}},
+			{Name: "role", Type: SearchToken, Paths: []string{"code"}},
+			{Name: "specialty", Type: SearchToken, Paths: []string{"specialty"}},
+			{Name: "active", Type: SearchToken, Paths: []string{"active"}},
+			{Name: "date", Type: SearchDate, Paths: []string{"period"}},
+			{Name: "practitioner", Type: SearchReference, Paths: []string{"practitioner"}, Targets: []string{"Practitioner"}},
+			{Name: "organization", Type: SearchReference, Paths: []string{"organization"}, Targets: []string{"Organization"}},
+			{Name: "location", Type: SearchReference, Paths: []string{"location"}, Targets: []string{"Location"}},
+		},
+		Validate: checkNPIs,
+		References: []ReferenceRule{
+			{Path: "practitioner", Targets: []string{"Practitioner"}},
+			{Path: "organization", Targets: []string{"Organization"}},
+			{Path: "location", Targets: []string{"Location"}},
+		},
+		Elements: elements(
+			[]string{
+				"identifier", "code", "specialty", "location", "healthcareService", "telecom",
+				"availableTime", "notAvailable", "endpoint", "availableTime.daysOfWeek",
+			},
+			[]string{
+				"active", "period", "practitioner", "organization", "availabilityExceptions",
+				"availableTime.allDay", "availableTime.availableStartTime",
+				"availableTime.availableEndTime", "notAvailable.description", "notAvailable.during",
+			},
+		),
+	}
+}
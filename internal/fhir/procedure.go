@@ -0,0 +1,47 @@
+package fhir
+
+// Procedure describes the Procedure resource, used for surgical history, and
+// its search parameters. The subject must be a Patient on this server.
+// Procedure has a single status rather than clinical and verification
+// statuses, so it is searched with status.
+func Procedure() ResourceType {
+	return ResourceType{
+		Name: "Procedure",
+		SearchParams: []SearchParam{
+			{Name: "status", Type: SearchToken, Paths: []string{"status"}},
+			{Name: "code", Type: SearchToken, Paths: []string{"code"}},
+			{Name: "category", Type: SearchToken, Paths: []string{"category"}},
+			{Name: "date", Type: SearchDate, Paths: []string{"performedDateTime", "performedPeriod"}},
+			{Name: "subject", Type: SearchReference, Paths: []string{"subject"}, Targets: []string{"Patient", "Group"}},
+			{Name: "patient", Type: SearchReference, Paths: []string{"subject"}, Targets: []string{"Patient"}},
+			{Name: "encounter", Type: SearchReference, Paths: []string{"encounter"}, Targets: []string{"Encounter"}},
+			{Name: "performer", Type: SearchReference, Paths: []string{"performer.actor"}, Targets: []string{"Practitioner", "PractitionerRole", "Organization", "Patient", "RelatedPerson", "Device"}},
+		},
+		Validate:   validateProcedure,
+		References: []ReferenceRule{{Path: "subject", Targets: []string{"Patient"}}},
+		Elements: elements(
+			[]string{
+				"identifier", "instantiatesCanonical", "instantiatesUri", "basedOn", "partOf",
+				"performer", "reasonCode", "reasonReference", "bodySite", "report", "complication",
+				"complicationDetail", "followUp", "note", "focalDevice", "usedReference", "usedCode",
+			},
+			[]string{
+				"status", "statusReason", "category", "code", "subject", "encounter", "performed[x]",
+				"recorder", "asserter", "location", "outcome", "performer.function", "performer.actor",
+				"performer.onBehalfOf", "focalDevice.action", "focalDevice.manipulated",
+			},
+		),
+	}
+}
+
+// validateProcedure checks the required status and subject.
+func validateProcedure(resource map[string]any) error {
+	if err := requireElements(resource, "status", "subject"); err != nil {
+		return err
+	}
+	if _, err := checkCode(resource, "status",
+		"preparation", "in-progress", "not-done", "on-hold", "stopped", "completed", "entered-in-error", "unknown"); err != nil {
+		return err
+	}
+	return atMostOneChoice(resource, "performed")
+}
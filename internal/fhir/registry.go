@@ -0,0 +1,127 @@
+package fhir
+
+// ResourceType describes a FHIR resource type the server exposes over REST.
+type ResourceType struct {
+	Name string
+	// SearchParams are the type-specific search parameters; common ones such
+	// as _id are handled by the search package for every type.
+	SearchParams []SearchParam
+	// Validate checks a resource's content before it is stored, beyond the
+	// resourceType and id checks every type gets. Nil accepts any content.
+	Validate func(resource map[string]any) error
+	// References are reference elements whose targets must already exist on
+	// this server when a resource is written.
+	References []ReferenceRule
+	// Elements maps the type's elements, by dotted path from the resource
+	// ("participant.type"), to whether they repeat. Backbone elements list
+	// their children; elements inside data types such as HumanName are
+	// looked up by name instead. See Repeats.
+	Elements map[string]bool
+	// Transition, when set, checks a write against the version it replaces,
+	// e.g. to enforce a status state machine, and may add server-maintained
+	// elements to next. force reports that the client asked to override the
+	// rules. It is not called when nothing is stored yet.
+	Transition func(current, next map[string]any, force bool) error
+}
+
+// ReferenceRule requires the references found at Path, a dotted element path
+// as in SearchParam, to point at an existing resource of one of Targets.
+// Resources without the element are not affected.
+type ReferenceRule struct {
+	Path    string
+	Targets []string
+}
+
+// SearchParamType is the FHIR search parameter type, which decides how
+// values are compared.
+type SearchParamType string
+
+const (
+	SearchString    SearchParamType = "string"
+	SearchToken     SearchParamType = "token"
+	SearchDate      SearchParamType = "date"
+	SearchReference SearchParamType = "reference"
+	SearchQuantity  SearchParamType = "quantity"
+	SearchComposite SearchParamType = "composite"
+	// SearchSpecial is used for near, the only special parameter supported:
+	// "latitude|longitude|distance|units" against a Location.position.
+	SearchSpecial SearchParamType = "special"
+)
+
+// SearchParam maps a search parameter code onto the elements it searches.
+type SearchParam struct {
+	Name string
+	Type SearchParamType
+	// Paths are dotted element paths, e.g. "name.family". Arrays are
+	// flattened at every step; a resource matches if any value matches.
+	Paths []string
+	// Targets lists the resource types a reference parameter may point to.
+	Targets []string
+	// Components are the parts of a composite parameter, in the order their
+	// values appear in a search ("code$value"). Their Paths are relative to
+	// each element the composite's own Paths select.
+	Components []SearchParam
+}
+
+// SearchParam looks up a type-specific search parameter by code.
+func (t ResourceType) SearchParam(name string) (SearchParam, bool) {
+	for _, p := range t.SearchParams {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return SearchParam{}, false
+}
+
+// Registry is the set of resource types served under /fhir/{type}.
+// It is built once at startup and treated as read-only afterwards.
+type Registry struct {
+	types map[string]ResourceType
+	names []string
+}
+
+func NewRegistry(types ...ResourceType) *Registry {
+	r := &Registry{types: make(map[string]ResourceType, len(types))}
+	for _, t := range types {
+		if _, dup := r.types[t.Name]; dup {
+			continue
+		}
+		r.types[t.Name] = t
+		r.names = append(r.names, t.Name)
+	}
+	return r
+}
+
+// DefaultRegistry returns the resource types this server supports out of the box.
+func DefaultRegistry() *Registry {
+	return NewRegistry(
+		Patient(),
+		Observation(),
+		Condition(),
+		AllergyIntolerance(),
+		Procedure(),
+		Encounter(),
+		EpisodeOfCare(),
+		Practitioner(),
+		PractitionerRole(),
+		Organization(),
+		Location(),
+		Medication(),
+		MedicationRequest(),
+		MedicationDispense(),
+		MedicationAdministration(),
+		MedicationStatement(),
+	)
+}
+
+func (r *Registry) Lookup(name string) (ResourceType, bool) {
+	t, ok := r.types[name]
+	return t, ok
+}
+
+// Names returns the registered type names in registration order.
+func (r *Registry) Names() []string {
+	out := make([]string, len(r.names))
+	copy(out, r.names)
+	return out
+}
@@ -0,0 +1,143 @@
+package fhir
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// choiceElements returns the keys of element that are typed variants of the
+// choice element name[x], such as valueQuantity for "value".
+func choiceElements(element map[string]any, name string) []string {
+	var out []string
+	for k := range element {
+		if rest, ok := strings.CutPrefix(k, name); ok && rest != "" && rest[0] >= 'A' && rest[0] <= 'Z' {
+			out = append(out, k)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+// requireElements reports the first of names missing from resource.
+func requireElements(resource map[string]any, names ...string) error {
+	for _, name := range names {
+		if resource[name] == nil {
+			return fmt.Errorf("%s.%s is required", resource["resourceType"], name)
+		}
+	}
+	return nil
+}
+
+// atMostOneChoice reports a resource with several variants of name[x].
+func atMostOneChoice(resource map[string]any, name string) error {
+	if found := choiceElements(resource, name); len(found) > 1 {
+		return fmt.Errorf("%s has more than one %s[x]: %s", resource["resourceType"], name, strings.Join(found, ", "))
+	}
+	return nil
+}
+
+// exactlyOneChoice reports a resource with no variant of name[x], or several.
+func exactlyOneChoice(resource map[string]any, name string) error {
+	if len(choiceElements(resource, name)) == 0 {
+		return fmt.Errorf("%s.%s[x] is required", resource["resourceType"], name)
+	}
+	return atMostOneChoice(resource, name)
+}
+
+// checkCode validates a code element such as Procedure.status against its
+// required value set and returns it; an absent element returns "".
+func checkCode(resource map[string]any, name string, allowed ...string) (string, error) {
+	v, ok := resource[name]
+	if !ok {
+		return "", nil
+	}
+	code, ok := v.(string)
+	if !ok || !slices.Contains(allowed, code) {
+		return "", fmt.Errorf("%s.%s must be one of %s", resource["resourceType"], name, strings.Join(allowed, ", "))
+	}
+	return code, nil
+}
+
+// checkConcept validates the codes a CodeableConcept such as
+// Condition.clinicalStatus carries in system and returns the first; codings
+// from other systems are left alone. An absent element returns "".
+func checkConcept(resource map[string]any, name, system string, allowed ...string) (string, error) {
+	v, ok := resource[name]
+	if !ok {
+		return "", nil
+	}
+	concept, ok := v.(map[string]any)
+	if !ok {
+		return "", fmt.Errorf("%s.%s must be a CodeableConcept", resource["resourceType"], name)
+	}
+	codings, _ := concept["coding"].([]any)
+	first := ""
+	for _, c := range codings {
+		coding, _ := c.(map[string]any)
+		if coding["system"] != system {
+			continue
+		}
+		code, _ := coding["code"].(string)
+		if !slices.Contains(allowed, code) {
+			return "", fmt.Errorf("%s.%s must be one of %s from %s", resource["resourceType"], name, strings.Join(allowed, ", "), system)
+		}
+		if first == "" {
+			first = code
+		}
+	}
+	return first, nil
+}
+
+// npiSystem is the identifier system of US National Provider Identifiers.
+const npiSystem = "http://hl7.org/fhir/sid/us-npi"
+
+// checkNPIs rejects identifiers in npiSystem that aren't ten digits with a
+// valid Luhn check digit, computed with the 80840 card-issuer prefix.
+func checkNPIs(resource map[string]any) error {
+	identifiers, _ := resource["identifier"].([]any)
+	for _, raw := range identifiers {
+		id, _ := raw.(map[string]any)
+		if id["system"] != npiSystem {
+			continue
+		}
+		value, _ := id["value"].(string)
+		if !validNPI(value) {
+			return fmt.Errorf("%s.identifier %q is not a valid NPI", resource["resourceType"], value)
+		}
+	}
+	return nil
+}
+
+func validNPI(npi string) bool {
+	if len(npi) != 10 {
+		return false
+	}
+	// 24 is the Luhn sum of the 80840 prefix.
+	sum := 24
+	for i := 0; i < 9; i++ {
+		d := int(npi[i] - '0')
+		if d < 0 || d > 9 {
+			return false
+		}
+		if i%2 == 0 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	check := int(npi[9] - '0')
+	return check >= 0 && check <= 9 && (sum+check)%10 == 0
+}
+
+// checkNotPartOfItself rejects a partOf reference to the resource itself,
+// the one cycle visible without looking at other resources.
+func checkNotPartOfItself(resource map[string]any) error {
+	partOf, _ := resource["partOf"].(map[string]any)
+	id, _ := resource["id"].(string)
+	if ref, _ := partOf["reference"].(string); ref != "" && ref == fmt.Sprintf("%s/%s", resource["resourceType"], id) {
+		return fmt.Errorf("%s.partOf must not refer to itself", resource["resourceType"])
+	}
+	return nil
+}
@@ -0,0 +1,431 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/httpapi/respond"
+	"go-fhir-server/internal/storage"
+)
+
+// errTransactionFailed aborts store.Transaction once an entry has failed; the
+// failing entry's own response is reported to the client.
+var errTransactionFailed = errors.New("transaction entry failed")
+
+// bundleEntry is one request from a batch or transaction Bundle.
+type bundleEntry struct {
+	fullURL  string
+	resource map[string]any
+	method   string
+	url      string
+	header   http.Header
+}
+
+// processBundle serves POST /fhir with a batch or transaction Bundle. Batch
+// entries succeed or fail independently; a transaction is all-or-nothing.
+func processBundle(registry *fhir.Registry, o options, store storage.ResourceStore, w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var bundle map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&bundle); err != nil {
+		respond.JSON(w, http.StatusBadRequest, fhir.OperationOutcome("invalid JSON body"), "application/fhir+json")
+		return
+	}
+	if rt, _ := bundle["resourceType"].(string); rt != "Bundle" {
+		respond.JSON(w, http.StatusBadRequest, fhir.OperationOutcome("resourceType must be 'Bundle'"), "application/fhir+json")
+		return
+	}
+	rawEntries, ok := bundle["entry"].([]any)
+	if !ok && bundle["entry"] != nil {
+		respond.JSON(w, http.StatusBadRequest, fhir.OperationOutcome("Bundle.entry must be an array"), "application/fhir+json")
+		return
+	}
+
+	switch bundle["type"] {
+	case "batch":
+		processBatch(registry, o, store, rawEntries, w, r)
+	case "transaction":
+		processTransaction(registry, o, store, rawEntries, w, r)
+	default:
+		respond.JSON(w, http.StatusBadRequest, fhir.OperationOutcome("Bundle.type must be 'batch' or 'transaction'"), "application/fhir+json")
+	}
+}
+
+// processBatch runs every entry on its own against store and reports each
+// outcome, successful or not, in a batch-response Bundle.
+func processBatch(registry *fhir.Registry, o options, store storage.ResourceStore, rawEntries []any, w http.ResponseWriter, r *http.Request) {
+	responses := make([]map[string]any, len(rawEntries))
+	for i, raw := range rawEntries {
+		rec := newEntryRecorder()
+		if entry, err := parseBundleEntry(raw); err != nil {
+			respond.JSON(rec, http.StatusBadRequest, fhir.OperationOutcome(err.Error()), "application/fhir+json")
+		} else {
+			runEntry(registry, o, store, entry, rec, r)
+		}
+		responses[i] = rec.responseEntry()
+	}
+
+	respond.JSON(w, http.StatusOK, map[string]any{
+		"resourceType": "Bundle",
+		"type":         "batch-response",
+		"entry":        responses,
+	}, "application/fhir+json")
+}
+
+// processTransaction runs all entries inside one store transaction, in the
+// order the FHIR spec prescribes (DELETE, POST, PUT/PATCH, GET), after
+// resolving urn:uuid placeholders to the ids they will be created with.
+// References that must resolve are checked after the last entry, so entries
+// may refer to resources created later in the Bundle. The first failing
+// entry rolls everything back and becomes the response.
+func processTransaction(registry *fhir.Registry, o options, store storage.ResourceStore, rawEntries []any, w http.ResponseWriter, r *http.Request) {
+	entries := make([]bundleEntry, len(rawEntries))
+	for i, raw := range rawEntries {
+		entry, err := parseBundleEntry(raw)
+		if err != nil {
+			respond.JSON(w, http.StatusBadRequest, fhir.OperationOutcome(fmt.Sprintf("entry %d: %v", i, err)), "application/fhir+json")
+			return
+		}
+		entries[i] = entry
+	}
+
+	order := make([]int, len(entries))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return methodRank(entries[order[a]].method) < methodRank(entries[order[b]].method)
+	})
+
+	responses := make([]map[string]any, len(entries))
+	var failed *entryRecorder
+	failedAt := -1
+
+	err := store.Transaction(func(tx storage.Tx) error {
+		if i, rec := resolvePlaceholders(registry, tx, entries); rec != nil {
+			failed, failedAt = rec, i
+			return errTransactionFailed
+		}
+		ttx := &transactionTx{Tx: tx}
+		for _, i := range order {
+			rec := newEntryRecorder()
+			ttx.entry = i
+			runEntry(registry, o, ttx, entries[i], rec, r)
+			if rec.status >= 400 {
+				failed, failedAt = rec, i
+				return errTransactionFailed
+			}
+			responses[i] = rec.responseEntry()
+		}
+		for _, ref := range ttx.pending {
+			rec := newEntryRecorder()
+			if !checkReference(tx, ref.path, ref.target, rec) {
+				failed, failedAt = rec, ref.entry
+				return errTransactionFailed
+			}
+		}
+		return nil
+	})
+
+	if failed != nil {
+		e := entries[failedAt]
+		msg := fmt.Sprintf("transaction failed at entry %d (%s %s): %s", failedAt, e.method, e.url, failed.outcomeText())
+		respond.JSON(w, failed.status, fhir.OperationOutcome(msg), "application/fhir+json")
+		return
+	}
+	if err != nil {
+		respond.JSON(w, http.StatusInternalServerError, fhir.OperationOutcome("storage error"), "application/fhir+json")
+		return
+	}
+
+	respond.JSON(w, http.StatusOK, map[string]any{
+		"resourceType": "Bundle",
+		"type":         "transaction-response",
+		"entry":        responses,
+	}, "application/fhir+json")
+}
+
+// methodRank is the transaction processing order from the FHIR spec.
+func methodRank(method string) int {
+	switch method {
+	case http.MethodDelete:
+		return 0
+	case http.MethodPost:
+		return 1
+	case http.MethodPut, http.MethodPatch:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// resolvePlaceholders assigns ids to POST entries whose fullUrl is a
+// urn:uuid and rewrites every reference to those placeholders. A conditional
+// create that already matches one resource resolves to that resource instead.
+// On failure it returns the entry index and the recorded error response.
+func resolvePlaceholders(registry *fhir.Registry, tx storage.Tx, entries []bundleEntry) (int, *entryRecorder) {
+	resolved := make(map[string]string)
+	for i, e := range entries {
+		if e.method != http.MethodPost || !strings.HasPrefix(e.fullURL, "urn:uuid:") || e.resource == nil {
+			continue
+		}
+		typeName, _ := e.resource["resourceType"].(string)
+		rt, ok := registry.Lookup(typeName)
+		if !ok {
+			// Leave it to the entry itself to report the unsupported type.
+			continue
+		}
+
+		id := newFHIRID()
+		if criteria := e.header.Get("If-None-Exist"); criteria != "" {
+			rec := newEntryRecorder()
+			matches, ok := conditionalMatches(rt, tx, criteria, rec)
+			if !ok {
+				return i, rec
+			}
+			switch len(matches) {
+			case 0:
+			case 1:
+				id, _ = matches[0]["id"].(string)
+			default:
+				respond.JSON(rec, http.StatusPreconditionFailed, fhir.OperationOutcome("If-None-Exist matched multiple resources"), "application/fhir+json")
+				return i, rec
+			}
+		}
+		e.resource["id"] = id
+		resolved[e.fullURL] = rt.Name + "/" + id
+	}
+
+	if len(resolved) > 0 {
+		for _, e := range entries {
+			if e.resource != nil {
+				rewriteReferences(e.resource, resolved)
+			}
+		}
+	}
+	return -1, nil
+}
+
+// rewriteReferences replaces every Reference.reference found in resolved.
+func rewriteReferences(node any, resolved map[string]string) {
+	switch n := node.(type) {
+	case map[string]any:
+		for k, v := range n {
+			if s, ok := v.(string); ok && k == "reference" {
+				if target, ok := resolved[s]; ok {
+					n[k] = target
+				}
+				continue
+			}
+			rewriteReferences(v, resolved)
+		}
+	case []any:
+		for _, v := range n {
+			rewriteReferences(v, resolved)
+		}
+	}
+}
+
+// parseBundleEntry validates Bundle.entry[n] and pulls out its request.
+func parseBundleEntry(raw any) (bundleEntry, error) {
+	entry, ok := raw.(map[string]any)
+	if !ok {
+		return bundleEntry{}, errors.New("entry must be an object")
+	}
+	request, ok := entry["request"].(map[string]any)
+	if !ok {
+		return bundleEntry{}, errors.New("entry.request is required")
+	}
+
+	e := bundleEntry{header: make(http.Header)}
+	e.fullURL, _ = entry["fullUrl"].(string)
+	e.method, _ = request["method"].(string)
+	e.url, _ = request["url"].(string)
+	switch e.method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		return bundleEntry{}, fmt.Errorf("entry.request.method %q is not supported", e.method)
+	}
+
+	e.url = strings.TrimPrefix(strings.TrimPrefix(e.url, "/"), "fhir/")
+	if path, _, _ := strings.Cut(e.url, "?"); strings.Trim(path, "/") == "" {
+		return bundleEntry{}, errors.New("entry.request.url must name a resource type; nested batches are not supported")
+	}
+	if strings.Contains(e.url, "://") {
+		return bundleEntry{}, errors.New("entry.request.url must be relative to the server base")
+	}
+
+	if res, ok := entry["resource"].(map[string]any); ok {
+		e.resource = res
+	} else if entry["resource"] != nil {
+		return bundleEntry{}, errors.New("entry.resource must be an object")
+	}
+
+	for field, name := range map[string]string{
+		"ifMatch":         "If-Match",
+		"ifNoneMatch":     "If-None-Match",
+		"ifNoneExist":     "If-None-Exist",
+		"ifModifiedSince": "If-Modified-Since",
+	} {
+		v, _ := request[field].(string)
+		if v == "" {
+			continue
+		}
+		if field == "ifModifiedSince" {
+			// Bundles carry an instant; the handlers expect an HTTP date.
+			if t, err := time.Parse(time.RFC3339, v); err == nil {
+				v = t.UTC().Format(http.TimeFormat)
+			}
+		}
+		e.header.Set(name, v)
+	}
+	return e, nil
+}
+
+// runEntry replays one entry through the regular REST routing so it gets
+// exactly the same validation and semantics as a standalone request.
+func runEntry(registry *fhir.Registry, o options, store storage.Tx, e bundleEntry, rec *entryRecorder, outer *http.Request) {
+	body, contentType, err := entryBody(e)
+	if err != nil {
+		respond.JSON(rec, http.StatusBadRequest, fhir.OperationOutcome(err.Error()), "application/fhir+json")
+		return
+	}
+
+	req, err := http.NewRequestWithContext(outer.Context(), e.method, "/fhir/"+e.url, bytes.NewReader(body))
+	if err != nil {
+		respond.JSON(rec, http.StatusBadRequest, fhir.OperationOutcome("invalid entry.request.url"), "application/fhir+json")
+		return
+	}
+	for name, values := range e.header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", contentType)
+	if prefer := outer.Header.Values("Prefer"); len(prefer) > 0 {
+		req.Header["Prefer"] = prefer
+	}
+
+	route(registry, o, store, rec, req)
+}
+
+// entryBody returns the request body for an entry. A PATCH may carry its JSON
+// Patch document as a Binary resource, as the spec suggests for Bundles.
+func entryBody(e bundleEntry) ([]byte, string, error) {
+	if e.resource == nil {
+		return nil, "application/fhir+json", nil
+	}
+	if e.method == http.MethodPatch && e.resource["resourceType"] == "Binary" {
+		contentType, _ := e.resource["contentType"].(string)
+		data, _ := e.resource["data"].(string)
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, "", errors.New("Binary.data must be base64 encoded")
+		}
+		return decoded, contentType, nil
+	}
+	body, err := json.Marshal(e.resource)
+	if err != nil {
+		return nil, "", errors.New("entry.resource could not be encoded")
+	}
+	return body, "application/fhir+json", nil
+}
+
+// entryRecorder captures the response of a single entry.
+type entryRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newEntryRecorder() *entryRecorder {
+	return &entryRecorder{header: make(http.Header)}
+}
+
+func (rec *entryRecorder) Header() http.Header { return rec.header }
+
+func (rec *entryRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *entryRecorder) Write(b []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(b)
+}
+
+// replay writes the recorded response to w.
+func (rec *entryRecorder) replay(w http.ResponseWriter) {
+	for name, values := range rec.header {
+		w.Header()[name] = values
+	}
+	if rec.status != 0 {
+		w.WriteHeader(rec.status)
+	}
+	if rec.body.Len() > 0 {
+		w.Write(rec.body.Bytes())
+	}
+}
+
+// responseEntry renders the recorded response as a Bundle.entry with
+// response.status/location/etag/lastModified and the returned resource or
+// OperationOutcome.
+func (rec *entryRecorder) responseEntry() map[string]any {
+	status := rec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	response := map[string]any{
+		"status": fmt.Sprintf("%d %s", status, http.StatusText(status)),
+	}
+	etag := rec.header.Get("ETag")
+	if etag != "" {
+		response["etag"] = etag
+	}
+	if lm, err := http.ParseTime(rec.header.Get("Last-Modified")); err == nil {
+		response["lastModified"] = lm.UTC().Format(time.RFC3339)
+	}
+
+	entry := map[string]any{"response": response}
+	if loc := rec.header.Get("Location"); loc != "" {
+		loc = strings.TrimPrefix(loc, "/fhir/")
+		entry["fullUrl"] = "/fhir/" + loc
+		if v, ok := parseETag(etag); ok {
+			loc += "/_history/" + fmt.Sprint(v)
+		}
+		response["location"] = loc
+	}
+
+	var body map[string]any
+	if rec.body.Len() > 0 && json.Unmarshal(rec.body.Bytes(), &body) == nil {
+		if body["resourceType"] == "OperationOutcome" {
+			response["outcome"] = body
+		} else {
+			entry["resource"] = body
+		}
+	}
+	return entry
+}
+
+// outcomeText returns the first issue's details.text from a recorded
+// OperationOutcome, falling back to the status text.
+func (rec *entryRecorder) outcomeText() string {
+	var outcome struct {
+		Issue []struct {
+			Details struct {
+				Text string `json:"text"`
+			} `json:"details"`
+		} `json:"issue"`
+	}
+	if json.Unmarshal(rec.body.Bytes(), &outcome) == nil && len(outcome.Issue) > 0 && outcome.Issue[0].Details.Text != "" {
+		return outcome.Issue[0].Details.Text
+	}
+	return http.StatusText(rec.status)
+}
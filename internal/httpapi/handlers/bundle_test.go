@@ -0,0 +1,161 @@
+package handlers_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/httpapi/handlers"
+	"go-fhir-server/internal/storage/memory"
+)
+
+func postBundle(t *testing.T, h http.Handler, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/fhir", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/fhir+json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func bundleEntries(t *testing.T, bundle map[string]any) []map[string]any {
+	t.Helper()
+	raw, ok := bundle["entry"].([]any)
+	if !ok {
+		t.Fatalf("expected entry array, got %T", bundle["entry"])
+	}
+	out := make([]map[string]any, len(raw))
+	for i, e := range raw {
+		out[i] = e.(map[string]any)
+	}
+	return out
+}
+
+func TestBundle_BatchEntriesAreIndependent(t *testing.T) {
+	h := handlers.Resource(fhir.DefaultRegistry(), memory.NewStore())
+
+	rec := postBundle(t, h, `{
+		"resourceType": "Bundle",
+		"type": "batch",
+		"entry": [
+			{"resource": {"resourceType": "Patient", "id": "b1"}, "request": {"method": "PUT", "url": "Patient/b1"}},
+			{"request": {"method": "GET", "url": "Patient/missing"}},
+			{"request": {"method": "GET", "url": "Patient/b1"}}
+		]
+	}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	bundle := readJSON(t, rec)
+	if got := requireString(t, bundle, "type"); got != "batch-response" {
+		t.Fatalf("expected batch-response, got %s", got)
+	}
+
+	entries := bundleEntries(t, bundle)
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	first := requireMap(t, entries[0], "response")
+	if first["status"] != "200 OK" || first["etag"] != `W/"1"` {
+		t.Fatalf("unexpected first response %v", first)
+	}
+	second := requireMap(t, entries[1], "response")
+	if second["status"] != "404 Not Found" {
+		t.Fatalf("expected 404 for missing read, got %v", second["status"])
+	}
+	if outcome := requireMap(t, second, "outcome"); outcome["resourceType"] != "OperationOutcome" {
+		t.Fatalf("expected OperationOutcome on failed entry, got %v", outcome)
+	}
+	if res := requireMap(t, entries[2], "resource"); res["id"] != "b1" {
+		t.Fatalf("expected read of b1 to succeed after the failure, got %v", res)
+	}
+}
+
+func TestBundle_TransactionResolvesPlaceholders(t *testing.T) {
+	h := handlers.Resource(fhir.DefaultRegistry(), memory.NewStore())
+
+	rec := postBundle(t, h, `{
+		"resourceType": "Bundle",
+		"type": "transaction",
+		"entry": [
+			{
+				"fullUrl": "urn:uuid:8f6c1c39-0000-4000-8000-000000000001",
+				"resource": {"resourceType": "Patient", "link": [{"type": "seealso", "other": {"reference": "urn:uuid:8f6c1c39-0000-4000-8000-000000000002"}}]},
+				"request": {"method": "POST", "url": "Patient"}
+			},
+			{
+				"fullUrl": "urn:uuid:8f6c1c39-0000-4000-8000-000000000002",
+				"resource": {"resourceType": "Patient", "active": true},
+				"request": {"method": "POST", "url": "Patient"}
+			}
+		]
+	}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	bundle := readJSON(t, rec)
+	if got := requireString(t, bundle, "type"); got != "transaction-response" {
+		t.Fatalf("expected transaction-response, got %s", got)
+	}
+
+	entries := bundleEntries(t, bundle)
+	first := requireMap(t, entries[0], "response")
+	second := requireMap(t, entries[1], "response")
+	if first["status"] != "201 Created" || second["status"] != "201 Created" {
+		t.Fatalf("expected both creates to succeed, got %v / %v", first["status"], second["status"])
+	}
+	target := requireString(t, second, "location")
+	if !strings.HasPrefix(target, "Patient/") || !strings.HasSuffix(target, "/_history/1") {
+		t.Fatalf("unexpected location %q", target)
+	}
+
+	linker := requireMap(t, entries[0], "resource")
+	link := linker["link"].([]any)[0].(map[string]any)
+	ref := link["other"].(map[string]any)["reference"]
+	if want := strings.TrimSuffix(target, "/_history/1"); ref != want {
+		t.Fatalf("expected urn:uuid reference rewritten to %s, got %v", want, ref)
+	}
+}
+
+func TestBundle_TransactionRollsBackOnFailure(t *testing.T) {
+	h := handlers.Resource(fhir.DefaultRegistry(), memory.NewStore())
+
+	rec := postBundle(t, h, `{
+		"resourceType": "Bundle",
+		"type": "transaction",
+		"entry": [
+			{"resource": {"resourceType": "Patient", "id": "t1"}, "request": {"method": "PUT", "url": "Patient/t1"}},
+			{"resource": {"resourceType": "Patient", "id": "t2"}, "request": {"method": "PUT", "url": "Patient/t2", "ifMatch": "W/\"3\""}}
+		]
+	}`)
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected failing entry's 412, got %d body=%s", rec.Code, rec.Body.String())
+	}
+	if got := requireString(t, readJSON(t, rec), "resourceType"); got != "OperationOutcome" {
+		t.Fatalf("expected OperationOutcome, got %s", got)
+	}
+
+	read := httptest.NewRecorder()
+	h.ServeHTTP(read, httptest.NewRequest(http.MethodGet, "/fhir/Patient/t1", nil))
+	if read.Code != http.StatusNotFound {
+		t.Fatalf("expected Patient/t1 to be rolled back, got %d", read.Code)
+	}
+}
+
+func TestBundle_RejectsOtherTypes(t *testing.T) {
+	h := handlers.Resource(fhir.DefaultRegistry(), memory.NewStore())
+
+	rec := postBundle(t, h, `{"resourceType": "Bundle", "type": "collection"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a collection Bundle, got %d", rec.Code)
+	}
+
+	get := httptest.NewRecorder()
+	h.ServeHTTP(get, httptest.NewRequest(http.MethodGet, "/fhir", nil))
+	if get.Code != http.StatusMethodNotAllowed || get.Header().Get("Allow") != http.MethodPost {
+		t.Fatalf("expected 405 with Allow: POST, got %d %v", get.Code, get.Header())
+	}
+}
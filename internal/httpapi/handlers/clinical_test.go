@@ -0,0 +1,96 @@
+package handlers_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/httpapi/handlers"
+	"go-fhir-server/internal/storage/memory"
+)
+
+const (
+	conditionActive    = `"clinicalStatus":{"coding":[{"system":"http://terminology.hl7.org/CodeSystem/condition-clinical","code":"active"}]}`
+	conditionResolved  = `"clinicalStatus":{"coding":[{"system":"http://terminology.hl7.org/CodeSystem/condition-clinical","code":"resolved"}]}`
+	conditionConfirmed = `"verificationStatus":{"coding":[{"system":"http://terminology.hl7.org/CodeSystem/condition-ver-status","code":"confirmed"}]}`
+	conditionError     = `"verificationStatus":{"coding":[{"system":"http://terminology.hl7.org/CodeSystem/condition-ver-status","code":"entered-in-error"}]}`
+	allergyActive      = `"clinicalStatus":{"coding":[{"system":"http://terminology.hl7.org/CodeSystem/allergyintolerance-clinical","code":"active"}]}`
+	allergyConfirmed   = `"verificationStatus":{"coding":[{"system":"http://terminology.hl7.org/CodeSystem/allergyintolerance-verification","code":"confirmed"}]}`
+	allergyError       = `"verificationStatus":{"coding":[{"system":"http://terminology.hl7.org/CodeSystem/allergyintolerance-verification","code":"entered-in-error"}]}`
+)
+
+func TestClinicalResources_Validation(t *testing.T) {
+	h := handlers.Resource(fhir.DefaultRegistry(), memory.NewStore())
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/fhir/Patient/p1", bytes.NewBufferString(`{"resourceType":"Patient"}`)))
+	if rec.Code >= 300 {
+		t.Fatalf("PUT patient status=%d body=%s", rec.Code, rec.Body.String())
+	}
+
+	cases := []struct {
+		name, path, body string
+		want             int
+	}{
+		{"condition", "Condition", `{"resourceType":"Condition",` + conditionActive + `,` + conditionConfirmed + `,"subject":{"reference":"Patient/p1"},"onsetDateTime":"2019-05"}`, http.StatusCreated},
+		{"condition without subject", "Condition", `{"resourceType":"Condition",` + conditionActive + `}`, http.StatusUnprocessableEntity},
+		{"condition for missing patient", "Condition", `{"resourceType":"Condition","subject":{"reference":"Patient/nobody"}}`, http.StatusUnprocessableEntity},
+		{"condition with unknown status", "Condition", `{"resourceType":"Condition","subject":{"reference":"Patient/p1"},"clinicalStatus":{"coding":[{"system":"http://terminology.hl7.org/CodeSystem/condition-clinical","code":"cured"}]}}`, http.StatusUnprocessableEntity},
+		{"condition abated but active", "Condition", `{"resourceType":"Condition",` + conditionActive + `,"subject":{"reference":"Patient/p1"},"abatementDateTime":"2020"}`, http.StatusUnprocessableEntity},
+		{"condition abated and resolved", "Condition", `{"resourceType":"Condition",` + conditionResolved + `,"subject":{"reference":"Patient/p1"},"abatementDateTime":"2020"}`, http.StatusCreated},
+		{"condition in error with status", "Condition", `{"resourceType":"Condition",` + conditionActive + `,` + conditionError + `,"subject":{"reference":"Patient/p1"}}`, http.StatusUnprocessableEntity},
+		{"condition with two onsets", "Condition", `{"resourceType":"Condition","subject":{"reference":"Patient/p1"},"onsetDateTime":"2019","onsetString":"childhood"}`, http.StatusUnprocessableEntity},
+		{"allergy", "AllergyIntolerance", `{"resourceType":"AllergyIntolerance",` + allergyActive + `,` + allergyConfirmed + `,"patient":{"reference":"Patient/p1"},"criticality":"high"}`, http.StatusCreated},
+		{"allergy without patient", "AllergyIntolerance", `{"resourceType":"AllergyIntolerance",` + allergyActive + `}`, http.StatusUnprocessableEntity},
+		{"allergy without clinical status", "AllergyIntolerance", `{"resourceType":"AllergyIntolerance",` + allergyConfirmed + `,"patient":{"reference":"Patient/p1"}}`, http.StatusUnprocessableEntity},
+		{"allergy in error", "AllergyIntolerance", `{"resourceType":"AllergyIntolerance",` + allergyError + `,"patient":{"reference":"Patient/p1"}}`, http.StatusCreated},
+		{"allergy with unknown criticality", "AllergyIntolerance", `{"resourceType":"AllergyIntolerance",` + allergyActive + `,"patient":{"reference":"Patient/p1"},"criticality":"severe"}`, http.StatusUnprocessableEntity},
+		{"procedure", "Procedure", `{"resourceType":"Procedure","status":"completed","subject":{"reference":"Patient/p1"},"performedDateTime":"2015-07-01"}`, http.StatusCreated},
+		{"procedure without status", "Procedure", `{"resourceType":"Procedure","subject":{"reference":"Patient/p1"}}`, http.StatusUnprocessableEntity},
+		{"procedure with unknown status", "Procedure", `{"resourceType":"Procedure","status":"done","subject":{"reference":"Patient/p1"}}`, http.StatusUnprocessableEntity},
+		{"procedure without subject", "Procedure", `{"resourceType":"Procedure","status":"completed"}`, http.StatusUnprocessableEntity},
+	}
+	for _, tc := range cases {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/fhir/"+tc.path, bytes.NewBufferString(tc.body)))
+		if rec.Code != tc.want {
+			t.Fatalf("%s: expected %d, got %d body=%s", tc.name, tc.want, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestSearch_ClinicalResourceParameters(t *testing.T) {
+	h := newSearchFixture(t,
+		fixture{"/fhir/Patient/p1", `{"resourceType":"Patient"}`},
+		fixture{"/fhir/Patient/p2", `{"resourceType":"Patient"}`},
+		fixture{"/fhir/Condition/c1", `{"resourceType":"Condition",` + conditionActive + `,` + conditionConfirmed + `,"code":{"coding":[{"system":"http://snomed.info/sct","code":"44054006"}]},"subject":{"reference":"Patient/p1"},"onsetDateTime":"2019-05-01"}`},
+		fixture{"/fhir/Condition/c2", `{"resourceType":"Condition",` + conditionResolved + `,"code":{"coding":[{"system":"http://snomed.info/sct","code":"195662009"}]},"subject":{"reference":"Patient/p2"},"onsetPeriod":{"start":"2021-01-10","end":"2021-01-20"},"abatementDateTime":"2021-01-20"}`},
+		fixture{"/fhir/AllergyIntolerance/a1", `{"resourceType":"AllergyIntolerance",` + allergyActive + `,` + allergyConfirmed + `,"code":{"coding":[{"system":"http://snomed.info/sct","code":"91936005"}]},"patient":{"reference":"Patient/p1"},"recordedDate":"2020-02-02"}`},
+		fixture{"/fhir/Procedure/pr1", `{"resourceType":"Procedure","status":"completed","code":{"coding":[{"system":"http://snomed.info/sct","code":"80146002"}]},"subject":{"reference":"Patient/p2"},"performedDateTime":"2015-07-01"}`},
+	)
+
+	cases := []struct {
+		query string
+		want  []string
+	}{
+		{"Condition?clinical-status=active", []string{"c1"}},
+		{"Condition?clinical-status=http://terminology.hl7.org/CodeSystem/condition-clinical|resolved", []string{"c2"}},
+		{"Condition?verification-status=confirmed", []string{"c1"}},
+		{"Condition?code=http://snomed.info/sct|195662009", []string{"c2"}},
+		{"Condition?onset-date=ge2020", []string{"c2"}},
+		{"Condition?patient=p1", []string{"c1"}},
+		{"AllergyIntolerance?clinical-status=active&verification-status=confirmed", []string{"a1"}},
+		{"AllergyIntolerance?code=91936005&patient=Patient/p1", []string{"a1"}},
+		{"AllergyIntolerance?date=2020-02", []string{"a1"}},
+		{"AllergyIntolerance?patient=p2", nil},
+		{"Procedure?status=completed&code=80146002", []string{"pr1"}},
+		{"Procedure?date=lt2016&patient=p2", []string{"pr1"}},
+	}
+	for _, tc := range cases {
+		if got := searchIDs(t, h, tc.query); !slices.Equal(got, tc.want) {
+			t.Fatalf("%s: expected %v, got %v", tc.query, tc.want, got)
+		}
+	}
+}
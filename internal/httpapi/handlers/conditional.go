@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/httpapi/respond"
+	"go-fhir-server/internal/search"
+	"go-fhir-server/internal/storage"
+)
+
+// conditionalMatches evaluates search criteria from a conditional interaction
+// (If-None-Exist, conditional update/delete) and returns the current matches.
+// It writes 400/500 and returns false when the criteria can't be evaluated.
+func conditionalMatches(rt fhir.ResourceType, store storage.Tx, criteria string, w http.ResponseWriter) ([]map[string]any, bool) {
+	q, err := search.ParseString(rt, criteria)
+	if err != nil {
+		respond.JSON(w, http.StatusBadRequest, fhir.OperationOutcome(err.Error()), "application/fhir+json")
+		return nil, false
+	}
+	if len(q.Criteria) == 0 {
+		respond.JSON(w, http.StatusBadRequest, fhir.OperationOutcome("conditional criteria must not be empty"), "application/fhir+json")
+		return nil, false
+	}
+
+	matches, err := store.Search(q)
+	if err != nil {
+		respond.JSON(w, http.StatusInternalServerError, fhir.OperationOutcome("storage error"), "application/fhir+json")
+		return nil, false
+	}
+	return matches, true
+}
+
+// atomically runs fn in a store transaction, so that the search a
+// conditional interaction makes still holds when it writes: the store keeps
+// other writers of the type out until the transaction ends (see
+// storage.ResourceStore.Transaction). fn's response is held back until the
+// transaction has settled, and an error response rolls it back. Inside a
+// transaction Bundle store is already transactional and fn runs directly
+// against it.
+func atomically(store storage.Tx, w http.ResponseWriter, fn func(tx storage.Tx, w http.ResponseWriter)) {
+	rs, ok := store.(storage.ResourceStore)
+	if !ok {
+		fn(store, w)
+		return
+	}
+
+	rec := newEntryRecorder()
+	err := rs.Transaction(func(tx storage.Tx) error {
+		fn(tx, rec)
+		if rec.status >= 400 {
+			return errTransactionFailed
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errTransactionFailed) {
+		respond.JSON(w, http.StatusInternalServerError, fhir.OperationOutcome("storage error"), "application/fhir+json")
+		return
+	}
+	rec.replay(w)
+}
+
+// conditionalUpdate serves PUT /fhir/{type}?criteria. Per the FHIR spec, no
+// match creates the resource, one match updates it and several are rejected.
+func conditionalUpdate(rt fhir.ResourceType, store storage.Tx, w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	resource, ok := decodeResource(rt, w, r)
+	if !ok {
+		return
+	}
+
+	// _force applies to the write, not the criteria.
+	criteria := r.URL.Query()
+	criteria.Del("_force")
+
+	bodyID, hasID := resource["id"]
+	if hasID && (!isNonEmptyString(bodyID) || !fhir.IDRe.MatchString(bodyID.(string))) {
+		respond.JSON(w, http.StatusBadRequest, fhir.OperationOutcome("id is not a valid FHIR id"), "application/fhir+json")
+		return
+	}
+
+	atomically(store, w, func(tx storage.Tx, w http.ResponseWriter) {
+		matches, ok := conditionalMatches(rt, tx, criteria.Encode(), w)
+		if !ok {
+			return
+		}
+
+		switch len(matches) {
+		case 0:
+			id := newFHIRID()
+			if hasID {
+				id = bodyID.(string)
+				// A resource that has the client's id but didn't match the
+				// criteria is a conflict, not a failed precondition.
+				_, exists, err := tx.Get(rt.Name, id)
+				if err != nil {
+					respond.JSON(w, http.StatusInternalServerError, fhir.OperationOutcome("storage error"), "application/fhir+json")
+					return
+				}
+				if exists {
+					respond.JSON(w, http.StatusConflict, fhir.OperationOutcome(rt.Name+"/"+id+" already exists but does not match the criteria"), "application/fhir+json")
+					return
+				}
+			}
+			resource["id"] = id
+			writeUpdate(rt, tx, id, resource, 0, http.StatusCreated, w, r)
+		case 1:
+			id, _ := matches[0]["id"].(string)
+			if hasID && bodyID.(string) != id {
+				respond.JSON(w, http.StatusBadRequest, fhir.OperationOutcome("body.id does not match the resource found by the criteria"), "application/fhir+json")
+				return
+			}
+			resource["id"] = id
+
+			// Pin the matched version so a concurrent change fails instead of
+			// being overwritten; an explicit If-Match takes precedence.
+			expected := versionOf(matches[0])
+			if r.Header.Get("If-Match") != "" {
+				if expected, ok = expectedVersion(tx, rt.Name, id, w, r); !ok {
+					return
+				}
+			}
+			writeUpdate(rt, tx, id, resource, expected, http.StatusOK, w, r)
+		default:
+			respond.JSON(w, http.StatusPreconditionFailed, fhir.OperationOutcome("conditional update criteria matched multiple resources"), "application/fhir+json")
+		}
+	})
+}
+
+// conditionalDelete serves DELETE /fhir/{type}?criteria. Several matches are
+// rejected with 412 unless mode allows deleting all of them.
+func conditionalDelete(rt fhir.ResourceType, store storage.Tx, mode ConditionalDeleteMode, w http.ResponseWriter, r *http.Request) {
+	// All matches are deleted in one transaction, so a failure partway
+	// through leaves every one of them in place.
+	atomically(store, w, func(tx storage.Tx, w http.ResponseWriter) {
+		matches, ok := conditionalMatches(rt, tx, r.URL.RawQuery, w)
+		if !ok {
+			return
+		}
+
+		if len(matches) > 1 && mode != ConditionalDeleteMultiple {
+			respond.JSON(w, http.StatusPreconditionFailed, fhir.OperationOutcome("conditional delete criteria matched multiple resources"), "application/fhir+json")
+			return
+		}
+
+		for _, m := range matches {
+			id, _ := m["id"].(string)
+			// Pin the matched version so a resource that changed since matching
+			// is not deleted on stale information.
+			_, err := tx.Delete(rt.Name, id, versionOf(m))
+			var conflict *storage.ConflictError
+			if errors.As(err, &conflict) {
+				writeConflict(w, conflict)
+				return
+			}
+			if err != nil {
+				respond.JSON(w, http.StatusInternalServerError, fhir.OperationOutcome("storage error"), "application/fhir+json")
+				return
+			}
+		}
+
+		// Zero matches is not an error: the desired end state already holds.
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,342 @@
+package handlers_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/httpapi/handlers"
+	"go-fhir-server/internal/search"
+	"go-fhir-server/internal/storage"
+	"go-fhir-server/internal/storage/memory"
+)
+
+func TestConditionalCreate_IfNoneExist(t *testing.T) {
+	h := handlers.Resource(fhir.DefaultRegistry(), memory.NewStore())
+
+	create := func(body, ifNoneExist string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/fhir/Patient", bytes.NewBufferString(body))
+		if ifNoneExist != "" {
+			req.Header.Set("If-None-Exist", ifNoneExist)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	body := `{"resourceType":"Patient","identifier":[{"system":"http://mrn","value":"123"}]}`
+
+	rec := create(body, "identifier=http://mrn|123")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("first create status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	id := requireString(t, readJSON(t, rec), "id")
+
+	// A retried HL7 message must not create a duplicate.
+	rec = create(body, "identifier=http://mrn|123")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("retry status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	if got := requireString(t, readJSON(t, rec), "id"); got != id {
+		t.Fatalf("expected existing patient %s, got %s", id, got)
+	}
+	if loc := rec.Header().Get("Location"); loc != "/fhir/Patient/"+id {
+		t.Fatalf("unexpected Location %q", loc)
+	}
+
+	// Unconditional create makes a second match, after which the criteria are ambiguous.
+	if rec = create(body, ""); rec.Code != http.StatusCreated {
+		t.Fatalf("plain create status=%d", rec.Code)
+	}
+	rec = create(body, "identifier=http://mrn|123")
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for multiple matches, got %d body=%s", rec.Code, rec.Body.String())
+	}
+
+	rec = create(body, "unknown-param=1")
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown criteria, got %d body=%s", rec.Code, rec.Body.String())
+	}
+}
+
+// interceptTx runs a test's hooks around searches and deletes, both on the
+// store itself and inside its transactions.
+type interceptTx struct {
+	storage.Tx
+	afterSearch  func()
+	beforeDelete func(resourceType, id string) error
+}
+
+func (t interceptTx) Search(q search.Query) ([]map[string]any, error) {
+	matches, err := t.Tx.Search(q)
+	if t.afterSearch != nil {
+		t.afterSearch()
+	}
+	return matches, err
+}
+
+func (t interceptTx) Delete(resourceType, id string, expectedVersion int) (bool, error) {
+	if t.beforeDelete != nil {
+		if err := t.beforeDelete(resourceType, id); err != nil {
+			return false, err
+		}
+	}
+	return t.Tx.Delete(resourceType, id, expectedVersion)
+}
+
+type interceptStore struct {
+	interceptTx
+	store storage.ResourceStore
+}
+
+func intercept(store storage.ResourceStore, hooks interceptTx) interceptStore {
+	hooks.Tx = store
+	return interceptStore{hooks, store}
+}
+
+func (s interceptStore) Transaction(fn func(tx storage.Tx) error) error {
+	return s.store.Transaction(func(tx storage.Tx) error {
+		hooks := s.interceptTx
+		hooks.Tx = tx
+		return fn(hooks)
+	})
+}
+
+// pauseAfterSearch makes concurrent requests reliably interleave between
+// searching and writing.
+func pauseAfterSearch() { time.Sleep(5 * time.Millisecond) }
+
+// concurrently sends n copies of a request at once and returns the statuses.
+func concurrently(h http.Handler, n int, newRequest func() *http.Request) []int {
+	codes := make([]int, n)
+	var wg sync.WaitGroup
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, newRequest())
+			codes[i] = rec.Code
+		}()
+	}
+	wg.Wait()
+	return codes
+}
+
+func TestConditionalCreate_ConcurrentRetriesCreateOnce(t *testing.T) {
+	store := memory.NewStore()
+	h := handlers.Resource(fhir.DefaultRegistry(), intercept(store, interceptTx{afterSearch: pauseAfterSearch}))
+
+	codes := concurrently(h, 10, func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/fhir/Patient", bytes.NewBufferString(`{"resourceType":"Patient","identifier":[{"system":"http://mrn","value":"123"}]}`))
+		req.Header.Set("If-None-Exist", "identifier=http://mrn|123")
+		return req
+	})
+	created := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusCreated:
+			created++
+		case http.StatusOK:
+		default:
+			t.Fatalf("unexpected status %d", code)
+		}
+	}
+	if n := countMatches(t, store, fhir.Patient(), "identifier=http://mrn|123"); created != 1 || n != 1 {
+		t.Fatalf("expected exactly one create, got %d creates and %d patients", created, n)
+	}
+}
+
+func countMatches(t *testing.T, store storage.Tx, rt fhir.ResourceType, criteria string) int {
+	t.Helper()
+	q, err := search.ParseString(rt, criteria)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matches, err := store.Search(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(matches)
+}
+
+func TestConditionalUpdate(t *testing.T) {
+	store := memory.NewStore()
+	h := handlers.Resource(fhir.DefaultRegistry(), store)
+
+	put := func(query, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPut, "/fhir/Patient?"+query, bytes.NewBufferString(body))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	body := `{"resourceType":"Patient","identifier":[{"system":"http://mrn","value":"42"}],"active":false}`
+
+	// No match: create.
+	rec := put("identifier=http://mrn|42", body)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	id := requireString(t, readJSON(t, rec), "id")
+	if loc := rec.Header().Get("Location"); loc != "/fhir/Patient/"+id {
+		t.Fatalf("unexpected Location %q", loc)
+	}
+
+	// One match: update in place.
+	rec = put("identifier=http://mrn|42", `{"resourceType":"Patient","identifier":[{"system":"http://mrn","value":"42"}],"active":true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	updated := readJSON(t, rec)
+	if requireString(t, updated, "id") != id || updated["active"] != true {
+		t.Fatalf("expected %s to be updated, got %v", id, updated)
+	}
+	if etag := rec.Header().Get("ETag"); etag != `W/"2"` {
+		t.Fatalf(`expected ETag W/"2", got %q`, etag)
+	}
+
+	// Mismatched body id is rejected.
+	rec = put("identifier=http://mrn|42", `{"resourceType":"Patient","id":"other"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for mismatched id, got %d", rec.Code)
+	}
+
+	// No match, but the body's id is taken by a resource the criteria didn't
+	// match: 409, and that resource is left alone.
+	rec = put("identifier=http://mrn|99", `{"resourceType":"Patient","id":"`+id+`","identifier":[{"system":"http://mrn","value":"99"}]}`)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for an id that exists but doesn't match, got %d body=%s", rec.Code, rec.Body.String())
+	}
+	if got := countMatches(t, store, fhir.Patient(), "identifier=http://mrn|42"); got != 1 {
+		t.Fatalf("expected %s to keep its identifier, found %d matches", id, got)
+	}
+
+	// Stale If-Match on the matched resource is honored.
+	req := httptest.NewRequest(http.MethodPut, "/fhir/Patient?identifier=http://mrn|42", bytes.NewBufferString(body))
+	req.Header.Set("If-Match", `W/"1"`)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for stale If-Match, got %d", rec.Code)
+	}
+
+	// Several matches: 412.
+	if rec = put("identifier=http://mrn|43", `{"resourceType":"Patient","identifier":[{"system":"http://mrn","value":"42"}]}`); rec.Code != http.StatusCreated {
+		t.Fatalf("second create status=%d", rec.Code)
+	}
+	rec = put("identifier=http://mrn|42", body)
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for multiple matches, got %d body=%s", rec.Code, rec.Body.String())
+	}
+
+	// Criteria are required.
+	rec = put("", body)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without criteria, got %d", rec.Code)
+	}
+}
+
+func TestConditionalUpdate_ConcurrentCreatesOnce(t *testing.T) {
+	store := memory.NewStore()
+	h := handlers.Resource(fhir.DefaultRegistry(), intercept(store, interceptTx{afterSearch: pauseAfterSearch}))
+
+	codes := concurrently(h, 10, func() *http.Request {
+		return httptest.NewRequest(http.MethodPut, "/fhir/Patient?identifier=http://mrn|456", bytes.NewBufferString(`{"resourceType":"Patient","identifier":[{"system":"http://mrn","value":"456"}]}`))
+	})
+	created := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusCreated:
+			created++
+		case http.StatusOK:
+		default:
+			t.Fatalf("unexpected status %d", code)
+		}
+	}
+	if n := countMatches(t, store, fhir.Patient(), "identifier=http://mrn|456"); created != 1 || n != 1 {
+		t.Fatalf("expected exactly one create, got %d creates and %d patients", created, n)
+	}
+}
+
+func TestConditionalDelete_FailureDeletesNothing(t *testing.T) {
+	store := memory.NewStore()
+	for _, id := range []string{"p1", "p2", "p3"} {
+		if _, err := store.Put("Patient", id, map[string]any{"resourceType": "Patient", "id": id, "identifier": []any{map[string]any{"system": "http://mrn", "value": "7"}}}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// p2 changes between the search and its delete.
+	conflictOnP2 := func(resourceType, id string) error {
+		if id == "p2" {
+			return &storage.ConflictError{ResourceType: resourceType, ID: id, Expected: 1, Current: 2}
+		}
+		return nil
+	}
+	h := handlers.Resource(fhir.DefaultRegistry(), intercept(store, interceptTx{beforeDelete: conflictOnP2}),
+		handlers.WithConditionalDelete(handlers.ConditionalDeleteMultiple))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/fhir/Patient?identifier=http://mrn|7", nil))
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412, got %d body=%s", rec.Code, rec.Body.String())
+	}
+	if n := countMatches(t, store, fhir.Patient(), "identifier=http://mrn|7"); n != 3 {
+		t.Fatalf("expected every match to survive the failed delete, got %d remaining", n)
+	}
+}
+
+func TestConditionalDelete(t *testing.T) {
+	seed := func(h http.Handler) {
+		t.Helper()
+		for i := 0; i < 2; i++ {
+			req := httptest.NewRequest(http.MethodPost, "/fhir/Patient", bytes.NewBufferString(`{"resourceType":"Patient","identifier":[{"system":"http://mrn","value":"7"}]}`))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("seed status=%d", rec.Code)
+			}
+		}
+	}
+	del := func(h http.Handler, query string) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodDelete, "/fhir/Patient?"+query, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	count := func(h http.Handler) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/fhir/Patient", nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return len(readJSON(t, rec)["entry"].([]any))
+	}
+
+	single := handlers.Resource(fhir.DefaultRegistry(), memory.NewStore())
+	seed(single)
+	if code := del(single, "identifier=http://mrn|7"); code != http.StatusPreconditionFailed {
+		t.Fatalf("single mode: expected 412, got %d", code)
+	}
+	if n := count(single); n != 2 {
+		t.Fatalf("single mode: expected nothing deleted, got %d remaining", n)
+	}
+	if code := del(single, "identifier=http://mrn|nobody"); code != http.StatusNoContent {
+		t.Fatalf("expected 204 for zero matches, got %d", code)
+	}
+
+	multiple := handlers.Resource(fhir.DefaultRegistry(), memory.NewStore(), handlers.WithConditionalDelete(handlers.ConditionalDeleteMultiple))
+	seed(multiple)
+	if code := del(multiple, "identifier=http://mrn|7"); code != http.StatusNoContent {
+		t.Fatalf("multiple mode: expected 204, got %d", code)
+	}
+	if n := count(multiple); n != 0 {
+		t.Fatalf("multiple mode: expected all deleted, got %d remaining", n)
+	}
+}
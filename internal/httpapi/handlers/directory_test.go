@@ -0,0 +1,137 @@
+package handlers_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/httpapi/handlers"
+	"go-fhir-server/internal/storage/memory"
+)
+
+func TestDirectory_Validation(t *testing.T) {
+	h := handlers.Resource(fhir.DefaultRegistry(), memory.NewStore())
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+		return rec
+	}
+	for _, put := range []struct{ path, body string }{
+		{"/fhir/Organization/org1", `{"resourceType":"Organization","name":"General Hospital"}`},
+		{"/fhir/Practitioner/dr1", `{"resourceType":"Practitioner","identifier":[{"system":"http://hl7.org/fhir/sid/us-npi","value":"1234567893"}]}`},
+	} {
+		if rec := do(http.MethodPut, put.path, put.body); rec.Code >= 300 {
+			t.Fatalf("PUT %s status=%d body=%s", put.path, rec.Code, rec.Body.String())
+		}
+	}
+
+	cases := []struct {
+		name, path, body string
+		want             int
+	}{
+		{"bad NPI check digit", "Practitioner", `{"resourceType":"Practitioner","identifier":[{"system":"http://hl7.org/fhir/sid/us-npi","value":"1234567890"}]}`, http.StatusUnprocessableEntity},
+		{"short NPI", "Organization", `{"resourceType":"Organization","identifier":[{"system":"http://hl7.org/fhir/sid/us-npi","value":"12345"}]}`, http.StatusUnprocessableEntity},
+		{"other identifier system", "Practitioner", `{"resourceType":"Practitioner","identifier":[{"system":"http://example.org/staff","value":"12345"}]}`, http.StatusCreated},
+		{"organization part of a missing one", "Organization", `{"resourceType":"Organization","partOf":{"reference":"Organization/nowhere"}}`, http.StatusUnprocessableEntity},
+		{"location", "Location", `{"resourceType":"Location","status":"active","position":{"latitude":42.36,"longitude":-71.06},"managingOrganization":{"reference":"Organization/org1"}}`, http.StatusCreated},
+		{"location with unknown status", "Location", `{"resourceType":"Location","status":"closed"}`, http.StatusUnprocessableEntity},
+		{"location off the globe", "Location", `{"resourceType":"Location","position":{"latitude":142.36,"longitude":-71.06}}`, http.StatusUnprocessableEntity},
+		{"role", "PractitionerRole", `{"resourceType":"PractitionerRole","practitioner":{"reference":"Practitioner/dr1"},"organization":{"reference":"Organization/org1"}}`, http.StatusCreated},
+		{"role for a missing practitioner", "PractitionerRole", `{"resourceType":"PractitionerRole","practitioner":{"reference":"Practitioner/nobody"}}`, http.StatusUnprocessableEntity},
+	}
+	for _, tc := range cases {
+		if rec := do(http.MethodPost, "/fhir/"+tc.path, tc.body); rec.Code != tc.want {
+			t.Fatalf("%s: expected %d, got %d body=%s", tc.name, tc.want, rec.Code, rec.Body.String())
+		}
+	}
+
+	if rec := do(http.MethodPut, "/fhir/Organization/org1", `{"resourceType":"Organization","partOf":{"reference":"Organization/org1"}}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for an Organization part of itself, got %d", rec.Code)
+	}
+}
+
+// Patient references into the directory are resolved by search and
+// _include, never enforced, so records pointing elsewhere keep saving.
+func TestDirectory_PatientReferencesAreNotEnforced(t *testing.T) {
+	h := handlers.Resource(fhir.DefaultRegistry(), memory.NewStore())
+	do := func(method, path, contentType, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code >= 300 {
+			t.Fatalf("%s %s status=%d body=%s", method, path, rec.Code, rec.Body.String())
+		}
+		return rec
+	}
+
+	existing := `{"resourceType":"Patient",` +
+		`"generalPractitioner":[{"reference":"https://directory.example.org/fhir/Practitioner/77"},{"reference":"PractitionerRole/not-loaded-yet"}],` +
+		`"managingOrganization":{"reference":"Organization/not-loaded-yet"}}`
+	do(http.MethodPut, "/fhir/Patient/p1", "application/fhir+json", existing)
+	do(http.MethodPut, "/fhir/Patient/p1", "application/fhir+json", strings.Replace(existing, `"Patient",`, `"Patient","active":true,`, 1))
+	do(http.MethodPatch, "/fhir/Patient/p1", "application/json-patch+json", `[{"op":"add","path":"/gender","value":"female"}]`)
+	do(http.MethodPost, "/fhir/Patient", "application/fhir+json", existing)
+
+	// Once the Organization is loaded, the reference resolves.
+	do(http.MethodPut, "/fhir/Organization/not-loaded-yet", "application/fhir+json", `{"resourceType":"Organization","name":"General Hospital"}`)
+	if ids := searchIDs(t, h, "Patient?_id=p1&_include=Patient:organization"); !slices.Equal(ids, []string{"not-loaded-yet", "p1"}) {
+		t.Fatalf("expected p1 and its organization, got %v", ids)
+	}
+}
+
+func TestSearch_DirectoryParameters(t *testing.T) {
+	const cardiology = `"specialty":[{"coding":[{"system":"http://nucc.org/provider-taxonomy","code":"207RC0000X"}]}]`
+	h := newSearchFixture(t,
+		fixture{"/fhir/Organization/health", `{"resourceType":"Organization","name":"Mass Health","address":[{"city":"Boston","state":"MA"}]}`},
+		fixture{"/fhir/Organization/mgh", `{"resourceType":"Organization","name":"General Hospital","alias":["MGH"],"partOf":{"reference":"Organization/health"}}`},
+		fixture{"/fhir/Organization/cardio", `{"resourceType":"Organization","name":"Heart Center","partOf":{"reference":"Organization/mgh"}}`},
+		fixture{"/fhir/Location/campus", `{"resourceType":"Location","name":"Main Campus","status":"active","position":{"latitude":42.3626,"longitude":-71.0685},"managingOrganization":{"reference":"Organization/mgh"}}`},
+		fixture{"/fhir/Location/floor3", `{"resourceType":"Location","name":"Floor 3","partOf":{"reference":"Location/campus"}}`},
+		fixture{"/fhir/Location/room301", `{"resourceType":"Location","name":"Room 301","partOf":{"reference":"Location/floor3"}}`},
+		fixture{"/fhir/Location/nyc", `{"resourceType":"Location","name":"Manhattan Clinic","status":"active","position":{"latitude":40.7128,"longitude":-74.0060}}`},
+		fixture{"/fhir/Practitioner/dr1", `{"resourceType":"Practitioner","name":[{"family":"Chen","given":["Amy"]}],"identifier":[{"system":"http://hl7.org/fhir/sid/us-npi","value":"1234567893"}],"address":[{"city":"Cambridge","postalCode":"02139"}]}`},
+		fixture{"/fhir/Practitioner/dr2", `{"resourceType":"Practitioner","name":[{"family":"Okafor"}]}`},
+		fixture{"/fhir/PractitionerRole/r1", `{"resourceType":"PractitionerRole","practitioner":{"reference":"Practitioner/dr1"},"organization":{"reference":"Organization/cardio"},"location":[{"reference":"Location/campus"}],"code":[{"coding":[{"system":"http://terminology.hl7.org/CodeSystem/practitioner-role","code":"doctor"}]}],` + cardiology + `}`},
+		fixture{"/fhir/PractitionerRole/r2", `{"resourceType":"PractitionerRole","practitioner":{"reference":"Practitioner/dr2"},"location":[{"reference":"Location/nyc"}],"code":[{"coding":[{"system":"http://terminology.hl7.org/CodeSystem/practitioner-role","code":"nurse"}]}]}`},
+	)
+
+	cases := []struct {
+		query string
+		want  []string
+	}{
+		{"Practitioner?identifier=http://hl7.org/fhir/sid/us-npi|1234567893", []string{"dr1"}},
+		{"Practitioner?family=oka", []string{"dr2"}},
+		{"Practitioner?address-postalcode=02139", []string{"dr1"}},
+		{"Organization?name=mgh", []string{"mgh"}},
+		{"Organization?address-city=boston", []string{"health"}},
+		{"Organization?partof=health", []string{"mgh"}},
+		{"Organization?partof:below=health", []string{"cardio", "mgh"}},
+		{"Location?partof:below=campus", []string{"floor3", "room301"}},
+		{"Location?near=42.3601|-71.0589|5|km", []string{"campus"}},
+		{"Location?near=42.3601|-71.0589|400|km&_sort=-name", []string{"nyc", "campus"}},
+		{"Location?organization=mgh", []string{"campus"}},
+		{"PractitionerRole?role=doctor", []string{"r1"}},
+		{"PractitionerRole?specialty=http://nucc.org/provider-taxonomy|207RC0000X", []string{"r1"}},
+		{"PractitionerRole?location=Location/nyc", []string{"r2"}},
+		{"PractitionerRole?location.near=42.3601|-71.0589|5|km", []string{"r1"}},
+		{"PractitionerRole?practitioner.name=chen&_include=PractitionerRole:practitioner", []string{"dr1", "r1"}},
+	}
+	for _, tc := range cases {
+		if got := searchIDs(t, h, tc.query); !slices.Equal(got, tc.want) {
+			t.Fatalf("%s: expected %v, got %v", tc.query, tc.want, got)
+		}
+	}
+
+	// :below only follows a hierarchy within one resource type.
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fhir/PractitionerRole?organization:below=mgh", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for organization:below, got %d", rec.Code)
+	}
+}
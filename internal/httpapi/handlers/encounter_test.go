@@ -0,0 +1,201 @@
+package handlers_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/httpapi/handlers"
+	"go-fhir-server/internal/storage/memory"
+)
+
+const ambulatory = `"class":{"system":"http://terminology.hl7.org/CodeSystem/v3-ActCode","code":"AMB"}`
+
+func encounter(status string, extra ...string) string {
+	body := `{"resourceType":"Encounter","status":"` + status + `",` + ambulatory + `,"subject":{"reference":"Patient/p1"}`
+	for _, e := range extra {
+		body += "," + e
+	}
+	return body + "}"
+}
+
+func statusHistory(t *testing.T, res map[string]any) []string {
+	t.Helper()
+	var out []string
+	entries, _ := res["statusHistory"].([]any)
+	for _, e := range entries {
+		out = append(out, e.(map[string]any)["status"].(string))
+	}
+	return out
+}
+
+func TestEncounter_StatusTransitions(t *testing.T) {
+	h := handlers.Resource(fhir.DefaultRegistry(), memory.NewStore())
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		if method == http.MethodPatch {
+			req.Header.Set("Content-Type", "application/json-patch+json")
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := do(http.MethodPut, "/fhir/Patient/p1", `{"resourceType":"Patient"}`); rec.Code >= 300 {
+		t.Fatalf("PUT patient status=%d body=%s", rec.Code, rec.Body.String())
+	}
+
+	if rec := do(http.MethodPut, "/fhir/Encounter/e1", encounter("planned", `"period":{"start":"2024-05-01T08:00:00Z"}`)); rec.Code >= 300 {
+		t.Fatalf("create status=%d body=%s", rec.Code, rec.Body.String())
+	}
+
+	// Moving forward records the status being left. A statusHistory sent by
+	// the client is replaced with the server's.
+	rec := do(http.MethodPut, "/fhir/Encounter/e1", encounter("in-progress", `"statusHistory":[{"status":"triaged","period":{"start":"2000"}}]`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("planned -> in-progress status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	res := readJSON(t, rec)
+	if got := statusHistory(t, res); len(got) != 1 || got[0] != "planned" {
+		t.Fatalf("expected history [planned], got %v", got)
+	}
+	first := res["statusHistory"].([]any)[0].(map[string]any)["period"].(map[string]any)
+	if first["start"] != "2024-05-01T08:00:00Z" || first["end"] == nil {
+		t.Fatalf("expected planned to run from period.start until now, got %v", first)
+	}
+
+	// An update that keeps the status adds nothing.
+	rec = do(http.MethodPut, "/fhir/Encounter/e1", encounter("in-progress", `"priority":{"text":"urgent"}`))
+	if got := statusHistory(t, readJSON(t, rec)); len(got) != 1 {
+		t.Fatalf("expected history unchanged, got %v", got)
+	}
+
+	rec = do(http.MethodPatch, "/fhir/Encounter/e1", `[{"op":"replace","path":"/status","value":"finished"}]`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("in-progress -> finished status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	res = readJSON(t, rec)
+	if got := statusHistory(t, res); strings.Join(got, ",") != "planned,in-progress" {
+		t.Fatalf("expected history [planned in-progress], got %v", got)
+	}
+	periods := res["statusHistory"].([]any)
+	if periods[1].(map[string]any)["period"].(map[string]any)["start"] != first["end"] {
+		t.Fatalf("expected in-progress to start when planned ended, got %v", periods)
+	}
+
+	// finished is terminal unless the client forces the change.
+	rec = do(http.MethodPut, "/fhir/Encounter/e1", encounter("in-progress"))
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "from finished to in-progress") {
+		t.Fatalf("expected 422 for finished -> in-progress, got %d body=%s", rec.Code, rec.Body.String())
+	}
+	rec = do(http.MethodPut, "/fhir/Encounter/e1?_force=true", encounter("in-progress"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("forced finished -> in-progress status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	if got := statusHistory(t, readJSON(t, rec)); strings.Join(got, ",") != "planned,in-progress,finished" {
+		t.Fatalf("expected the forced change to be recorded, got %v", got)
+	}
+
+	// Conditional updates and transactions take _force too.
+	rec = do(http.MethodPut, "/fhir/Encounter?_id=e1", encounter("planned"))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for in-progress -> planned, got %d body=%s", rec.Code, rec.Body.String())
+	}
+	rec = do(http.MethodPut, "/fhir/Encounter?_id=e1&_force=true", encounter("planned"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("forced conditional update status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	rec = postBundle(t, h, `{"resourceType":"Bundle","type":"transaction","entry":[
+		{"resource":`+encounter("finished")+`,"request":{"method":"PUT","url":"Encounter/e1"}}
+	]}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for planned -> finished in a transaction, got %d body=%s", rec.Code, rec.Body.String())
+	}
+	rec = postBundle(t, h, `{"resourceType":"Bundle","type":"transaction","entry":[
+		{"resource":`+encounter("finished")+`,"request":{"method":"PUT","url":"Encounter/e1?_force=true"}}
+	]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("forced transaction status=%d body=%s", rec.Code, rec.Body.String())
+	}
+
+	// Anything may be marked entered-in-error, which is itself terminal.
+	if rec := do(http.MethodPut, "/fhir/Encounter/e1", encounter("entered-in-error")); rec.Code != http.StatusOK {
+		t.Fatalf("finished -> entered-in-error status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodPut, "/fhir/Encounter/e1", encounter("planned")); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 leaving entered-in-error, got %d", rec.Code)
+	}
+
+	for name, body := range map[string]string{
+		"no class":        `{"resourceType":"Encounter","status":"planned"}`,
+		"unknown status":  `{"resourceType":"Encounter","status":"done",` + ambulatory + `}`,
+		"missing patient": `{"resourceType":"Encounter","status":"planned",` + ambulatory + `,"subject":{"reference":"Patient/nobody"}}`,
+	} {
+		if rec := do(http.MethodPost, "/fhir/Encounter", body); rec.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("%s: expected 422, got %d body=%s", name, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestEpisodeOfCare_KeepsStatusHistory(t *testing.T) {
+	h := handlers.Resource(fhir.DefaultRegistry(), memory.NewStore())
+	put := func(path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, path, bytes.NewBufferString(body)))
+		if rec.Code >= 300 {
+			t.Fatalf("PUT %s status=%d body=%s", path, rec.Code, rec.Body.String())
+		}
+		return rec
+	}
+	put("/fhir/Patient/p1", `{"resourceType":"Patient"}`)
+	var rec *httptest.ResponseRecorder
+	// Any change is allowed, back and forth.
+	for _, status := range []string{"waitlist", "active", "waitlist"} {
+		rec = put("/fhir/EpisodeOfCare/eoc1", `{"resourceType":"EpisodeOfCare","status":"`+status+`","patient":{"reference":"Patient/p1"}}`)
+	}
+	if got := statusHistory(t, readJSON(t, rec)); strings.Join(got, ",") != "waitlist,active" {
+		t.Fatalf("expected history [waitlist active], got %v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/fhir/EpisodeOfCare", bytes.NewBufferString(`{"resourceType":"EpisodeOfCare","status":"active"}`)))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 without a patient, got %d", rec.Code)
+	}
+}
+
+func TestSearch_EncounterParameters(t *testing.T) {
+	h := newSearchFixture(t,
+		fixture{"/fhir/Organization/org1", `{"resourceType":"Organization","name":"General Hospital"}`},
+		fixture{"/fhir/Patient/p1", `{"resourceType":"Patient"}`},
+		fixture{"/fhir/Patient/p2", `{"resourceType":"Patient"}`},
+		fixture{"/fhir/Encounter/e1", encounter("finished", `"period":{"start":"2024-01-10T09:00:00Z","end":"2024-01-10T10:00:00Z"}`, `"serviceProvider":{"reference":"Organization/org1"}`)},
+		fixture{"/fhir/Encounter/e2", `{"resourceType":"Encounter","status":"in-progress","class":{"system":"http://terminology.hl7.org/CodeSystem/v3-ActCode","code":"IMP"},"subject":{"reference":"Patient/p2"},"period":{"start":"2024-03-01"}}`},
+	)
+
+	cases := []struct {
+		query string
+		want  []string
+	}{
+		{"patient=p1", []string{"e1"}},
+		{"status=in-progress", []string{"e2"}},
+		{"class=http://terminology.hl7.org/CodeSystem/v3-ActCode|AMB", []string{"e1"}},
+		{"class=IMP", []string{"e2"}},
+		{"service-provider=Organization/org1", []string{"e1"}},
+		{"date=2024-01-10", []string{"e1"}},
+		// e2 is still open, so its period reaches past any later date.
+		{"date=gt2024-06", []string{"e2"}},
+		{"date=ge2024-01&status=finished,in-progress", []string{"e1", "e2"}},
+		{"date=ge2024-01&_sort=-date", []string{"e2", "e1"}},
+	}
+	for _, tc := range cases {
+		if got := searchIDs(t, h, "Encounter?"+tc.query); !slices.Equal(got, tc.want) {
+			t.Fatalf("%s: expected %v, got %v", tc.query, tc.want, got)
+		}
+	}
+}
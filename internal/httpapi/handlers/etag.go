@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/httpapi/respond"
+	"go-fhir-server/internal/storage"
+)
+
+// versionOf returns meta.versionId as an int, or 0 when missing.
+func versionOf(resource map[string]any) int {
+	meta, _ := resource["meta"].(map[string]any)
+	s, _ := meta["versionId"].(string)
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// lastUpdatedOf parses meta.lastUpdated, reporting false when missing or malformed.
+func lastUpdatedOf(resource map[string]any) (time.Time, bool) {
+	meta, _ := resource["meta"].(map[string]any)
+	s, _ := meta["lastUpdated"].(string)
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// weakETag formats a versionId the way FHIR expects: W/"<versionId>".
+func weakETag(version int) string {
+	return `W/"` + strconv.Itoa(version) + `"`
+}
+
+// setVersionHeaders writes ETag and Last-Modified from the resource's meta.
+func setVersionHeaders(w http.ResponseWriter, resource map[string]any) {
+	if v := versionOf(resource); v > 0 {
+		w.Header().Set("ETag", weakETag(v))
+	}
+	if t, ok := lastUpdatedOf(resource); ok {
+		w.Header().Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+	}
+}
+
+// parseETag accepts W/"3", "3" or a bare 3 and returns the version number.
+func parseETag(tag string) (int, bool) {
+	tag = strings.TrimSpace(tag)
+	tag = strings.TrimPrefix(tag, "W/")
+	tag = strings.Trim(tag, `"`)
+	n, err := strconv.Atoi(tag)
+	if err != nil || n < 1 {
+		return 0, false
+	}
+	return n, true
+}
+
+// expectedVersion turns If-Match into the expectedVersion argument for the
+// store. Without the header it returns storage.AnyVersion. "If-Match: *" pins
+// whatever version currently exists. It writes 412 and returns false when the
+// precondition can't be satisfied.
+func expectedVersion(store storage.Tx, resourceType, id string, w http.ResponseWriter, r *http.Request) (int, bool) {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "" {
+		return storage.AnyVersion, true
+	}
+
+	if ifMatch == "*" {
+		cur, ok, err := store.Get(resourceType, id)
+		if err != nil {
+			respond.JSON(w, http.StatusInternalServerError, fhir.OperationOutcome("storage error"), "application/fhir+json")
+			return 0, false
+		}
+		if !ok {
+			respond.JSON(w, http.StatusPreconditionFailed, fhir.OperationOutcome("If-Match: * but resource does not exist"), "application/fhir+json")
+			return 0, false
+		}
+		return versionOf(cur), true
+	}
+
+	v, ok := parseETag(ifMatch)
+	if !ok {
+		respond.JSON(w, http.StatusPreconditionFailed, fhir.OperationOutcome("If-Match is not a valid version ETag"), "application/fhir+json")
+		return 0, false
+	}
+	return v, true
+}
+
+// writeConflict reports a *storage.ConflictError as 412 Precondition Failed.
+func writeConflict(w http.ResponseWriter, conflict *storage.ConflictError) {
+	msg := "version conflict: expected "
+	if conflict.Expected == 0 {
+		msg += "no existing resource"
+	} else {
+		msg += "version " + weakETag(conflict.Expected)
+	}
+	if conflict.Current > 0 {
+		msg += " but current version is " + weakETag(conflict.Current)
+	} else {
+		msg += " but the resource does not exist"
+	}
+	respond.JSON(w, http.StatusPreconditionFailed, fhir.OperationOutcome(msg), "application/fhir+json")
+}
+
+// notModified evaluates If-None-Match and If-Modified-Since against the stored
+// resource. Per RFC 9110, If-Modified-Since is ignored when If-None-Match is sent.
+func notModified(r *http.Request, resource map[string]any) bool {
+	if inm := strings.TrimSpace(r.Header.Get("If-None-Match")); inm != "" {
+		current := versionOf(resource)
+		for _, tag := range strings.Split(inm, ",") {
+			if strings.TrimSpace(tag) == "*" {
+				return true
+			}
+			if v, ok := parseETag(tag); ok && v == current {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		since, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		lastUpdated, ok := lastUpdatedOf(resource)
+		return ok && !lastUpdated.Truncate(time.Second).After(since)
+	}
+
+	return false
+}
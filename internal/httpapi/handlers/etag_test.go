@@ -0,0 +1,134 @@
+package handlers_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/httpapi/handlers"
+	"go-fhir-server/internal/storage/memory"
+)
+
+func TestETag_IfMatchOnUpdateAndDelete(t *testing.T) {
+	h := handlers.Resource(fhir.DefaultRegistry(), memory.NewStore())
+
+	do := func(method, path, body string, header map[string]string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/fhir/Patient", `{"resourceType":"Patient"}`, nil)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	if etag := rec.Header().Get("ETag"); etag != `W/"1"` {
+		t.Fatalf(`expected ETag W/"1" on create, got %q`, etag)
+	}
+	if rec.Header().Get("Last-Modified") == "" {
+		t.Fatalf("expected Last-Modified on create")
+	}
+	id := requireString(t, readJSON(t, rec), "id")
+	body := `{"resourceType":"Patient","id":"` + id + `","active":true}`
+
+	rec = do(http.MethodGet, "/fhir/Patient/"+id, "", nil)
+	if etag := rec.Header().Get("ETag"); etag != `W/"1"` {
+		t.Fatalf(`expected ETag W/"1" on read, got %q`, etag)
+	}
+
+	rec = do(http.MethodPut, "/fhir/Patient/"+id, body, map[string]string{"If-Match": `W/"1"`})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	if etag := rec.Header().Get("ETag"); etag != `W/"2"` {
+		t.Fatalf(`expected ETag W/"2" on update, got %q`, etag)
+	}
+
+	// A second writer still holding version 1 loses instead of overwriting.
+	rec = do(http.MethodPut, "/fhir/Patient/"+id, body, map[string]string{"If-Match": `W/"1"`})
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for stale If-Match, got %d body=%s", rec.Code, rec.Body.String())
+	}
+	if rt := requireString(t, readJSON(t, rec), "resourceType"); rt != "OperationOutcome" {
+		t.Fatalf("expected OperationOutcome, got %s", rt)
+	}
+
+	rec = do(http.MethodPut, "/fhir/Patient/"+id, body, map[string]string{"If-Match": "not-a-version"})
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for malformed If-Match, got %d", rec.Code)
+	}
+
+	rec = do(http.MethodPut, "/fhir/Patient/missing", `{"resourceType":"Patient"}`, map[string]string{"If-Match": "*"})
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for If-Match: * on a missing resource, got %d", rec.Code)
+	}
+
+	rec = do(http.MethodDelete, "/fhir/Patient/"+id, "", map[string]string{"If-Match": `W/"1"`})
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for stale delete, got %d body=%s", rec.Code, rec.Body.String())
+	}
+
+	rec = do(http.MethodDelete, "/fhir/Patient/"+id, "", map[string]string{"If-Match": `W/"2"`})
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("delete status=%d body=%s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestETag_ConditionalRead(t *testing.T) {
+	h := handlers.Resource(fhir.DefaultRegistry(), memory.NewStore())
+
+	read := func(path string, header map[string]string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/fhir/Patient", bytes.NewBufferString(`{"resourceType":"Patient"}`))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	id := requireString(t, readJSON(t, rec), "id")
+	lastModified := rec.Header().Get("Last-Modified")
+	path := "/fhir/Patient/" + id
+
+	cases := []struct {
+		name   string
+		header map[string]string
+		want   int
+	}{
+		{"matching etag", map[string]string{"If-None-Match": `W/"1"`}, http.StatusNotModified},
+		{"etag list", map[string]string{"If-None-Match": `W/"7", W/"1"`}, http.StatusNotModified},
+		{"stale etag", map[string]string{"If-None-Match": `W/"0"`}, http.StatusOK},
+		{"not modified since", map[string]string{"If-Modified-Since": lastModified}, http.StatusNotModified},
+		{"modified since", map[string]string{"If-Modified-Since": "Mon, 01 Jan 2001 00:00:00 GMT"}, http.StatusOK},
+		{"etag wins over date", map[string]string{"If-None-Match": `W/"9"`, "If-Modified-Since": lastModified}, http.StatusOK},
+	}
+	for _, tc := range cases {
+		rec := read(path, tc.header)
+		if rec.Code != tc.want {
+			t.Fatalf("%s: expected %d, got %d", tc.name, tc.want, rec.Code)
+		}
+		if rec.Code == http.StatusNotModified {
+			if rec.Body.Len() != 0 {
+				t.Fatalf("%s: expected empty 304 body, got %s", tc.name, rec.Body.String())
+			}
+			if rec.Header().Get("ETag") != `W/"1"` {
+				t.Fatalf("%s: expected ETag on 304", tc.name)
+			}
+		}
+	}
+}
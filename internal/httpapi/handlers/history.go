@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/httpapi/respond"
+	"go-fhir-server/internal/storage"
+)
+
+// history serves instance, type and system level _history as a history Bundle.
+// Empty resourceType/id widen the scope the same way storage.Tx.History does.
+func history(store storage.Tx, resourceType, id string, w http.ResponseWriter, r *http.Request) {
+	versions, err := store.History(resourceType, id)
+	if err != nil {
+		respond.JSON(w, http.StatusInternalServerError, fhir.OperationOutcome("storage error"), "application/fhir+json")
+		return
+	}
+	if id != "" && len(versions) == 0 {
+		respond.JSON(w, http.StatusNotFound, fhir.OperationOutcome("not found"), "application/fhir+json")
+		return
+	}
+
+	entries := make([]map[string]any, 0, len(versions))
+	for _, v := range versions {
+		entries = append(entries, historyEntry(v))
+	}
+
+	bundle := map[string]any{
+		"resourceType": "Bundle",
+		"type":         "history",
+		"total":        len(entries),
+		"entry":        entries,
+	}
+
+	respond.JSON(w, http.StatusOK, bundle, "application/fhir+json")
+}
+
+// vread serves GET /fhir/{type}/{id}/_history/{vid}.
+func vread(rt fhir.ResourceType, store storage.Tx, id, vid string, w http.ResponseWriter, r *http.Request) {
+	versionID, err := strconv.Atoi(vid)
+	if err != nil || versionID < 1 {
+		respond.JSON(w, http.StatusNotFound, fhir.OperationOutcome("version not found"), "application/fhir+json")
+		return
+	}
+
+	v, ok, err := store.VRead(rt.Name, id, versionID)
+	if err != nil {
+		respond.JSON(w, http.StatusInternalServerError, fhir.OperationOutcome("storage error"), "application/fhir+json")
+		return
+	}
+	if !ok {
+		respond.JSON(w, http.StatusNotFound, fhir.OperationOutcome("version not found"), "application/fhir+json")
+		return
+	}
+	if v.Deleted {
+		respond.JSON(w, http.StatusGone, fhir.OperationOutcome("resource was deleted in this version"), "application/fhir+json")
+		return
+	}
+
+	setVersionHeaders(w, v.Resource)
+	respond.JSON(w, http.StatusOK, v.Resource, "application/fhir+json")
+}
+
+// historyEntry renders one version as a Bundle.entry with request/response
+// describing the interaction that produced it.
+func historyEntry(v storage.Version) map[string]any {
+	url := v.ResourceType + "/" + v.ID
+
+	method, status := http.MethodPut, "200 OK"
+	switch {
+	case v.Deleted:
+		method, status = http.MethodDelete, "204 No Content"
+	case v.VersionID == 1:
+		method, status = http.MethodPost, "201 Created"
+	}
+
+	entry := map[string]any{
+		"fullUrl": "/fhir/" + url,
+		"request": map[string]any{
+			"method": method,
+			"url":    url,
+		},
+		"response": map[string]any{
+			"status":       status,
+			"etag":         weakETag(v.VersionID),
+			"lastModified": v.LastUpdated.UTC().Format(time.RFC3339),
+		},
+	}
+	if !v.Deleted {
+		entry["resource"] = v.Resource
+	}
+	return entry
+}
@@ -0,0 +1,88 @@
+package handlers_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/httpapi/handlers"
+	"go-fhir-server/internal/storage/memory"
+)
+
+func TestHistory_VReadAndBundles(t *testing.T) {
+	h := handlers.Resource(fhir.DefaultRegistry(), memory.NewStore())
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/fhir/Patient", `{"resourceType":"Patient","gender":"female"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	id := requireString(t, readJSON(t, rec), "id")
+
+	rec = do(http.MethodPut, "/fhir/Patient/"+id, `{"resourceType":"Patient","id":"`+id+`","gender":"male"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update status=%d body=%s", rec.Code, rec.Body.String())
+	}
+
+	// vread of version 1 returns the original content.
+	rec = do(http.MethodGet, "/fhir/Patient/"+id+"/_history/1", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("vread status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	if g := requireString(t, readJSON(t, rec), "gender"); g != "female" {
+		t.Fatalf("expected version 1 gender female, got %s", g)
+	}
+
+	rec = do(http.MethodGet, "/fhir/Patient/"+id+"/_history/9", "")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown version, got %d", rec.Code)
+	}
+
+	rec = do(http.MethodDelete, "/fhir/Patient/"+id, "")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("delete status=%d body=%s", rec.Code, rec.Body.String())
+	}
+
+	rec = do(http.MethodGet, "/fhir/Patient/"+id+"/_history/3", "")
+	if rec.Code != http.StatusGone {
+		t.Fatalf("expected 410 for deleted version, got %d body=%s", rec.Code, rec.Body.String())
+	}
+
+	for path, want := range map[string]int{
+		"/fhir/Patient/" + id + "/_history": 3,
+		"/fhir/Patient/_history":            3,
+		"/fhir/_history":                    3,
+	} {
+		rec = do(http.MethodGet, path, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s status=%d body=%s", path, rec.Code, rec.Body.String())
+		}
+		bundle := readJSON(t, rec)
+		if bt := requireString(t, bundle, "type"); bt != "history" {
+			t.Fatalf("%s: expected history bundle, got %s", path, bt)
+		}
+		entries, _ := bundle["entry"].([]any)
+		if len(entries) != want {
+			t.Fatalf("%s: expected %d entries, got %d", path, want, len(entries))
+		}
+		newest := entries[0].(map[string]any)
+		request := requireMap(t, newest, "request")
+		if m := requireString(t, request, "method"); m != http.MethodDelete {
+			t.Fatalf("%s: expected newest entry to be the DELETE, got %s", path, m)
+		}
+	}
+
+	rec = do(http.MethodPost, "/fhir/_history", "")
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405 on POST _history, got %d", rec.Code)
+	}
+}
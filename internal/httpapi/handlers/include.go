@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/search"
+	"go-fhir-server/internal/storage"
+)
+
+// resolvedInclude pairs an _include/_revinclude with the parameters it follows.
+type resolvedInclude struct {
+	search.Include
+	params []fhir.SearchParam
+}
+
+func resolveIncludes(registry *fhir.Registry, includes []search.Include) ([]resolvedInclude, error) {
+	out := make([]resolvedInclude, 0, len(includes))
+	for _, inc := range includes {
+		params, err := inc.Params(registry)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, resolvedInclude{inc, params})
+	}
+	return out, nil
+}
+
+// includedResources follows includes from one page of matches. The first
+// round applies every include to the matches; later rounds apply only the
+// :iterate ones to what the previous round added. Each resource is returned
+// at most once and never when it is already a match.
+func includedResources(store storage.Tx, includes []resolvedInclude, matches []map[string]any) ([]map[string]any, error) {
+	if len(includes) == 0 {
+		return nil, nil
+	}
+
+	seen := make(map[string]bool, len(matches))
+	for _, m := range matches {
+		seen[resourceKey(m)] = true
+	}
+
+	var out []map[string]any
+	frontier := matches
+	for round := 0; len(frontier) > 0; round++ {
+		var next []map[string]any
+		add := func(res map[string]any) {
+			if k := resourceKey(res); !seen[k] {
+				seen[k] = true
+				next = append(next, res)
+			}
+		}
+
+		for _, inc := range includes {
+			if round > 0 && !inc.Iterate {
+				continue
+			}
+			if inc.Reverse {
+				if err := revinclude(store, inc, frontier, add); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			for _, res := range frontier {
+				if res["resourceType"] != inc.SourceType {
+					continue
+				}
+				for _, p := range inc.params {
+					for _, ref := range search.References(res, p) {
+						if seen[ref] || !inc.Follows(ref) {
+							continue
+						}
+						rt, id, _ := search.ParseReference(ref)
+						target, ok, err := store.Get(rt, id)
+						if err != nil {
+							return nil, err
+						}
+						if ok {
+							add(target)
+						} else {
+							// Dangling reference; don't look it up again.
+							seen[ref] = true
+						}
+					}
+				}
+			}
+		}
+
+		out = append(out, next...)
+		frontier = next
+	}
+	return out, nil
+}
+
+// revinclude adds every inc.SourceType resource that references one of targets.
+func revinclude(store storage.Tx, inc resolvedInclude, targets []map[string]any, add func(map[string]any)) error {
+	var refs []string
+	for _, t := range targets {
+		if k := resourceKey(t); inc.Follows(k) {
+			refs = append(refs, k)
+		}
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+
+	for _, p := range inc.params {
+		q := search.Query{
+			ResourceType: inc.SourceType,
+			Criteria:     []search.Criterion{{Param: p, Values: refs}},
+		}
+		sources, err := store.Search(q)
+		if err != nil {
+			return err
+		}
+		for _, res := range sources {
+			add(res)
+		}
+	}
+	return nil
+}
+
+// resourceKey is the "Type/id" a resource is referenced by.
+func resourceKey(res map[string]any) string {
+	rt, _ := res["resourceType"].(string)
+	id, _ := res["id"].(string)
+	return rt + "/" + id
+}
@@ -0,0 +1,77 @@
+package handlers_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/httpapi/handlers"
+	"go-fhir-server/internal/storage/memory"
+)
+
+func TestSearch_IncludeAndRevinclude(t *testing.T) {
+	h := handlers.Resource(fhir.DefaultRegistry(), memory.NewStore(memory.WithIndexes(fhir.DefaultRegistry())))
+
+	for _, put := range []struct{ path, body string }{
+		{"/fhir/Organization/org1", `{"resourceType":"Organization","name":"General Hospital"}`},
+		{"/fhir/Practitioner/dr1", `{"resourceType":"Practitioner","name":[{"family":"House"}]}`},
+		{"/fhir/Practitioner/dr2", `{"resourceType":"Practitioner","name":[{"family":"Wilson"}]}`},
+		{"/fhir/Patient/p1", `{"resourceType":"Patient","generalPractitioner":[{"reference":"Practitioner/dr1"}],"managingOrganization":{"reference":"Organization/org1"}}`},
+		{"/fhir/Patient/other", `{"resourceType":"Patient"}`},
+		{"/fhir/Observation/o1", `{"resourceType":"Observation","status":"final","subject":{"reference":"Patient/p1"},"performer":[{"reference":"Practitioner/dr2"}],"valueString":"ok"}`},
+		{"/fhir/Observation/o2", `{"resourceType":"Observation","status":"final","subject":{"reference":"Patient/other"},"valueString":"ok"}`},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, put.path, bytes.NewBufferString(put.body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("PUT %s status=%d body=%s", put.path, rec.Code, rec.Body.String())
+		}
+	}
+
+	search := func(query string) (map[string]string, float64) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fhir/Patient?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status=%d body=%s", query, rec.Code, rec.Body.String())
+		}
+		bundle := readJSON(t, rec)
+		modes := map[string]string{}
+		for _, e := range bundle["entry"].([]any) {
+			entry := e.(map[string]any)
+			res := entry["resource"].(map[string]any)
+			modes[res["resourceType"].(string)+"/"+res["id"].(string)] = entry["search"].(map[string]any)["mode"].(string)
+		}
+		total, _ := bundle["total"].(float64)
+		return modes, total
+	}
+
+	modes, total := search("_id=p1&_include=Patient:general-practitioner&_include=Patient:organization")
+	want := map[string]string{"Patient/p1": "match", "Practitioner/dr1": "include", "Organization/org1": "include"}
+	if len(modes) != len(want) || total != 1 {
+		t.Fatalf("expected %v with total 1, got %v total=%v", want, modes, total)
+	}
+	for k, v := range want {
+		if modes[k] != v {
+			t.Fatalf("expected %s as %s, got %v", k, v, modes)
+		}
+	}
+
+	// Included resources are only followed further with :iterate.
+	modes, _ = search("_id=p1&_revinclude=Observation:subject")
+	if modes["Observation/o1"] != "include" || modes["Observation/o2"] != "" || modes["Practitioner/dr2"] != "" {
+		t.Fatalf("unexpected revinclude result %v", modes)
+	}
+	modes, _ = search("_id=p1&_revinclude=Observation:subject&_include:iterate=Observation:performer")
+	if modes["Practitioner/dr2"] != "include" {
+		t.Fatalf("expected :iterate to follow Observation.performer, got %v", modes)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fhir/Patient?_include=Patient:nothing", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown include parameter, got %d", rec.Code)
+	}
+}
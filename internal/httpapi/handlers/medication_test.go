@@ -0,0 +1,134 @@
+package handlers_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/httpapi/handlers"
+	"go-fhir-server/internal/storage/memory"
+)
+
+const amoxicillin = `"medicationCodeableConcept":{"coding":[{"system":"http://www.nlm.nih.gov/research/umls/rxnorm","code":"308182"}]}`
+
+func medicationRequest(extra ...string) string {
+	body := `{"resourceType":"MedicationRequest","status":"active","intent":"order","subject":{"reference":"Patient/p1"}`
+	for _, e := range extra {
+		body += "," + e
+	}
+	return body + "}"
+}
+
+func dosage(timing string) string {
+	return `"dosageInstruction":[{"timing":{"repeat":` + timing + `},"doseAndRate":[{"doseQuantity":{"value":500,"unit":"mg"}}]}]`
+}
+
+func TestMedication_Validation(t *testing.T) {
+	h := handlers.Resource(fhir.DefaultRegistry(), memory.NewStore())
+	for _, put := range []struct{ path, body string }{
+		{"/fhir/Patient/p1", `{"resourceType":"Patient"}`},
+		{"/fhir/Medication/m1", `{"resourceType":"Medication","code":{"coding":[{"system":"http://www.nlm.nih.gov/research/umls/rxnorm","code":"308182"}]}}`},
+		{"/fhir/MedicationRequest/rx1", medicationRequest(amoxicillin)},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, put.path, bytes.NewBufferString(put.body)))
+		if rec.Code >= 300 {
+			t.Fatalf("PUT %s status=%d body=%s", put.path, rec.Code, rec.Body.String())
+		}
+	}
+
+	const containedMed = `"contained":[{"resourceType":"Medication","id":"med","code":{"text":"compounded cream"}}]`
+	cases := []struct {
+		name, path, body string
+		want             int
+	}{
+		{"coded medication", "MedicationRequest", medicationRequest(amoxicillin, dosage(`{"frequency":3,"period":1,"periodUnit":"d","boundsDuration":{"value":10,"unit":"d"}}`)), http.StatusCreated},
+		{"referenced medication", "MedicationRequest", medicationRequest(`"medicationReference":{"reference":"Medication/m1"}`), http.StatusCreated},
+		{"contained medication", "MedicationRequest", medicationRequest(containedMed, `"medicationReference":{"reference":"#med"}`), http.StatusCreated},
+		{"missing contained medication", "MedicationRequest", medicationRequest(containedMed, `"medicationReference":{"reference":"#other"}`), http.StatusUnprocessableEntity},
+		{"contained patient as medication", "MedicationRequest", medicationRequest(`"contained":[{"resourceType":"Patient","id":"med"}]`, `"medicationReference":{"reference":"#med"}`), http.StatusUnprocessableEntity},
+		{"missing medication", "MedicationRequest", medicationRequest(`"medicationReference":{"reference":"Medication/nope"}`), http.StatusUnprocessableEntity},
+		{"no medication", "MedicationRequest", medicationRequest(), http.StatusUnprocessableEntity},
+		{"two medications", "MedicationRequest", medicationRequest(amoxicillin, `"medicationReference":{"reference":"Medication/m1"}`), http.StatusUnprocessableEntity},
+		{"no intent", "MedicationRequest", `{"resourceType":"MedicationRequest","status":"active","subject":{"reference":"Patient/p1"},` + amoxicillin + `}`, http.StatusUnprocessableEntity},
+		{"unknown intent", "MedicationRequest", `{"resourceType":"MedicationRequest","status":"active","intent":"wish","subject":{"reference":"Patient/p1"},` + amoxicillin + `}`, http.StatusUnprocessableEntity},
+		{"period without unit", "MedicationRequest", medicationRequest(amoxicillin, dosage(`{"frequency":3,"period":1}`)), http.StatusUnprocessableEntity},
+		{"unknown period unit", "MedicationRequest", medicationRequest(amoxicillin, dosage(`{"frequency":3,"period":1,"periodUnit":"day"}`)), http.StatusUnprocessableEntity},
+		{"fractional frequency", "MedicationRequest", medicationRequest(amoxicillin, dosage(`{"frequency":1.5,"period":1,"periodUnit":"d"}`)), http.StatusUnprocessableEntity},
+		{"negative duration", "MedicationRequest", medicationRequest(amoxicillin, dosage(`{"duration":-1,"durationUnit":"h"}`)), http.StatusUnprocessableEntity},
+		{"countMax without count", "MedicationRequest", medicationRequest(amoxicillin, dosage(`{"countMax":4}`)), http.StatusUnprocessableEntity},
+		{"when and timeOfDay", "MedicationRequest", medicationRequest(amoxicillin, dosage(`{"when":["MORN"],"timeOfDay":["08:00:00"]}`)), http.StatusUnprocessableEntity},
+		{"offset with meal", "MedicationRequest", medicationRequest(amoxicillin, dosage(`{"when":["ACM"],"offset":30}`)), http.StatusCreated},
+		{"offset without when", "MedicationRequest", medicationRequest(amoxicillin, dosage(`{"offset":30}`)), http.StatusUnprocessableEntity},
+		{"bad time of day", "MedicationRequest", medicationRequest(amoxicillin, dosage(`{"timeOfDay":["8am"]}`)), http.StatusUnprocessableEntity},
+		{"bad day of week", "MedicationRequest", medicationRequest(amoxicillin, dosage(`{"dayOfWeek":["monday"]}`)), http.StatusUnprocessableEntity},
+		{"dispense", "MedicationDispense", `{"resourceType":"MedicationDispense","status":"completed",` + amoxicillin + `,"subject":{"reference":"Patient/p1"},"authorizingPrescription":[{"reference":"MedicationRequest/rx1"}]}`, http.StatusCreated},
+		{"dispense for missing prescription", "MedicationDispense", `{"resourceType":"MedicationDispense","status":"completed",` + amoxicillin + `,"authorizingPrescription":[{"reference":"MedicationRequest/nope"}]}`, http.StatusUnprocessableEntity},
+		{"administration", "MedicationAdministration", `{"resourceType":"MedicationAdministration","status":"completed",` + amoxicillin + `,"subject":{"reference":"Patient/p1"},"effectiveDateTime":"2024-04-02T08:00:00Z","request":{"reference":"MedicationRequest/rx1"},"dosage":{"dose":{"value":500,"unit":"mg"}}}`, http.StatusCreated},
+		{"administration without effective", "MedicationAdministration", `{"resourceType":"MedicationAdministration","status":"completed",` + amoxicillin + `,"subject":{"reference":"Patient/p1"}}`, http.StatusUnprocessableEntity},
+		{"administration dosage without dose", "MedicationAdministration", `{"resourceType":"MedicationAdministration","status":"completed",` + amoxicillin + `,"subject":{"reference":"Patient/p1"},"effectiveDateTime":"2024-04-02","dosage":{"text":"one tablet"}}`, http.StatusUnprocessableEntity},
+		{"statement", "MedicationStatement", `{"resourceType":"MedicationStatement","status":"active",` + amoxicillin + `,"subject":{"reference":"Patient/p1"},"dosage":[{"timing":{"repeat":{"frequency":2,"period":1,"periodUnit":"d"}}}]}`, http.StatusCreated},
+		{"statement with unknown status", "MedicationStatement", `{"resourceType":"MedicationStatement","status":"taking",` + amoxicillin + `,"subject":{"reference":"Patient/p1"}}`, http.StatusUnprocessableEntity},
+	}
+	for _, tc := range cases {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/fhir/"+tc.path, bytes.NewBufferString(tc.body)))
+		if rec.Code != tc.want {
+			t.Fatalf("%s: expected %d, got %d body=%s", tc.name, tc.want, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestSearch_MedicationParameters(t *testing.T) {
+	h := newSearchFixture(t,
+		fixture{"/fhir/Patient/p1", `{"resourceType":"Patient"}`},
+		fixture{"/fhir/Patient/p2", `{"resourceType":"Patient"}`},
+		fixture{"/fhir/Medication/m1", `{"resourceType":"Medication","code":{"coding":[{"system":"http://www.nlm.nih.gov/research/umls/rxnorm","code":"197361"}]}}`},
+		fixture{"/fhir/MedicationRequest/rx1", medicationRequest(amoxicillin, `"authoredOn":"2024-04-01"`)},
+		fixture{"/fhir/MedicationRequest/rx2", `{"resourceType":"MedicationRequest","status":"draft","intent":"proposal","subject":{"reference":"Patient/p2"},"medicationReference":{"reference":"Medication/m1"},"authoredOn":"2024-06-15"}`},
+		fixture{"/fhir/MedicationDispense/d1", `{"resourceType":"MedicationDispense","status":"completed",` + amoxicillin + `,"subject":{"reference":"Patient/p1"},"authorizingPrescription":[{"reference":"MedicationRequest/rx1"}],"whenHandedOver":"2024-04-01T15:00:00Z"}`},
+		fixture{"/fhir/MedicationAdministration/a1", `{"resourceType":"MedicationAdministration","status":"completed",` + amoxicillin + `,"subject":{"reference":"Patient/p1"},"effectiveDateTime":"2024-04-02T08:00:00Z","request":{"reference":"MedicationRequest/rx1"}}`},
+		fixture{"/fhir/MedicationStatement/s1", `{"resourceType":"MedicationStatement","status":"active","medicationReference":{"reference":"Medication/m1"},"subject":{"reference":"Patient/p2"}}`},
+	)
+
+	cases := []struct {
+		query string
+		want  []string
+	}{
+		{"MedicationRequest?status=active", []string{"rx1"}},
+		{"MedicationRequest?intent=order,proposal", []string{"rx1", "rx2"}},
+		{"MedicationRequest?intent=order,proposal&_sort=-authoredon", []string{"rx2", "rx1"}},
+		{"MedicationRequest?authoredon=ge2024-05", []string{"rx2"}},
+		{"MedicationRequest?code=http://www.nlm.nih.gov/research/umls/rxnorm|308182", []string{"rx1"}},
+		{"MedicationRequest?medication=Medication/m1", []string{"rx2"}},
+		{"MedicationRequest?medication.code=197361", []string{"rx2"}},
+		{"MedicationRequest?patient=p1", []string{"rx1"}},
+		{"MedicationRequest?_has:MedicationDispense:prescription:status=completed", []string{"rx1"}},
+		{"MedicationRequest?_id=rx1&_revinclude=MedicationDispense:prescription", []string{"d1", "rx1"}},
+		{"MedicationDispense?prescription=MedicationRequest/rx1", []string{"d1"}},
+		{"MedicationDispense?whenhandedover=2024-04-01&patient=p1", []string{"d1"}},
+		{"MedicationAdministration?request=rx1&effective-time=2024-04-02", []string{"a1"}},
+		{"MedicationStatement?medication=m1&patient=p2", []string{"s1"}},
+	}
+	for _, tc := range cases {
+		if got := searchIDs(t, h, tc.query); !slices.Equal(got, tc.want) {
+			t.Fatalf("%s: expected %v, got %v", tc.query, tc.want, got)
+		}
+	}
+}
+
+func TestMedication_TransactionLinksDispenseToRequest(t *testing.T) {
+	h := handlers.Resource(fhir.DefaultRegistry(), memory.NewStore())
+	rec := postBundle(t, h, `{"resourceType":"Bundle","type":"transaction","entry":[
+		{"fullUrl":"urn:uuid:0f6c1f0e-4d0a-4cbb-9a43-2c1a3b1f4b11","resource":{"resourceType":"Patient"},"request":{"method":"POST","url":"Patient"}},
+		{"fullUrl":"urn:uuid:6b1f3f0a-9c7e-4e61-8f0a-3f1b2c4d5e66","resource":`+strings.Replace(medicationRequest(amoxicillin), "Patient/p1", "urn:uuid:0f6c1f0e-4d0a-4cbb-9a43-2c1a3b1f4b11", 1)+`,"request":{"method":"POST","url":"MedicationRequest"}},
+		{"resource":{"resourceType":"MedicationDispense","status":"completed",`+amoxicillin+`,"authorizingPrescription":[{"reference":"urn:uuid:6b1f3f0a-9c7e-4e61-8f0a-3f1b2c4d5e66"}]},"request":{"method":"POST","url":"MedicationDispense"}}
+	]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rec.Code, rec.Body.String())
+	}
+}
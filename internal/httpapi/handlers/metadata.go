@@ -2,13 +2,21 @@ package handlers
 
 import (
 	"net/http"
+	"slices"
 	"time"
 
+	"go-fhir-server/internal/fhir"
 	"go-fhir-server/internal/httpapi/respond"
 )
 
-// Metadata returns a minimal CapabilityStatement at GET /fhir/metadata.
-func Metadata() http.Handler {
+// Metadata returns a minimal CapabilityStatement at GET /fhir/metadata,
+// advertising every resource type in the registry.
+func Metadata(registry *fhir.Registry, opts ...Option) http.Handler {
+	conditionalDelete := "single"
+	if newOptions(opts).conditionalDelete == ConditionalDeleteMultiple {
+		conditionalDelete = "multiple"
+	}
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			w.Header().Set("Allow", http.MethodGet)
@@ -16,6 +24,45 @@ func Metadata() http.Handler {
 			return
 		}
 
+		resources := make([]any, 0, len(registry.Names()))
+		for _, name := range registry.Names() {
+			rt, _ := registry.Lookup(name)
+			searchParams := []any{
+				map[string]any{"name": "_id", "type": string(fhir.SearchToken)},
+				map[string]any{"name": "_lastUpdated", "type": string(fhir.SearchDate)},
+			}
+			searchInclude := []any{}
+			for _, p := range rt.SearchParams {
+				searchParams = append(searchParams, map[string]any{"name": p.Name, "type": string(p.Type)})
+				if p.Type == fhir.SearchReference {
+					searchInclude = append(searchInclude, name+":"+p.Name)
+				}
+			}
+
+			resources = append(resources, map[string]any{
+				"type":              name,
+				"versioning":        "versioned-update",
+				"conditionalRead":   "full-support",
+				"conditionalCreate": true,
+				"conditionalUpdate": true,
+				"conditionalDelete": conditionalDelete,
+				"interaction": []any{
+					map[string]any{"code": "create"},
+					map[string]any{"code": "read"},
+					map[string]any{"code": "vread"},
+					map[string]any{"code": "update"},
+					map[string]any{"code": "patch"},
+					map[string]any{"code": "delete"},
+					map[string]any{"code": "search-type"},
+					map[string]any{"code": "history-instance"},
+					map[string]any{"code": "history-type"},
+				},
+				"searchParam":      searchParams,
+				"searchInclude":    searchInclude,
+				"searchRevInclude": revIncludes(registry, name),
+			})
+		}
+
 		// Minimal, honest CapabilityStatement for this MVP.
 		cs := map[string]any{
 			"resourceType": "CapabilityStatement",
@@ -26,18 +73,12 @@ func Metadata() http.Handler {
 			"format":       []string{"json"},
 			"rest": []any{
 				map[string]any{
-					"mode": "server",
-					"resource": []any{
-						map[string]any{
-							"type": "Patient",
-							"interaction": []any{
-								map[string]any{"code": "create"},
-								map[string]any{"code": "read"},
-								map[string]any{"code": "update"},
-								map[string]any{"code": "delete"},
-								map[string]any{"code": "search-type"},
-							},
-						},
+					"mode":     "server",
+					"resource": resources,
+					"interaction": []any{
+						map[string]any{"code": "batch"},
+						map[string]any{"code": "transaction"},
+						map[string]any{"code": "history-system"},
 					},
 				},
 			},
@@ -46,3 +87,18 @@ func Metadata() http.Handler {
 		respond.JSON(w, http.StatusOK, cs, "application/fhir+json")
 	})
 }
+
+// revIncludes lists the "Source:param" reference parameters across the
+// registry that can point at target.
+func revIncludes(registry *fhir.Registry, target string) []any {
+	out := []any{}
+	for _, name := range registry.Names() {
+		rt, _ := registry.Lookup(name)
+		for _, p := range rt.SearchParams {
+			if p.Type == fhir.SearchReference && slices.Contains(p.Targets, target) {
+				out = append(out, name+":"+p.Name)
+			}
+		}
+	}
+	return out
+}
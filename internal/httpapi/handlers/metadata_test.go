@@ -5,11 +5,12 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"go-fhir-server/internal/fhir"
 	"go-fhir-server/internal/httpapi/handlers"
 )
 
 func TestMetadata_ReturnsCapabilityStatement(t *testing.T) {
-	h := handlers.Metadata()
+	h := handlers.Metadata(fhir.DefaultRegistry())
 
 	req := httptest.NewRequest(http.MethodGet, "/fhir/metadata", nil)
 	rec := httptest.NewRecorder()
@@ -28,4 +29,15 @@ func TestMetadata_ReturnsCapabilityStatement(t *testing.T) {
 	if ct := rec.Header().Get("Content-Type"); ct == "" {
 		t.Fatalf("expected Content-Type header")
 	}
+
+	types := map[string]bool{}
+	rest := readJSON(t, rec)["rest"].([]any)[0].(map[string]any)
+	for _, r := range rest["resource"].([]any) {
+		types[r.(map[string]any)["type"].(string)] = true
+	}
+	for _, want := range []string{"Patient", "Observation", "Condition", "AllergyIntolerance", "Procedure", "PractitionerRole", "Location", "Medication", "MedicationRequest", "MedicationDispense", "MedicationAdministration", "MedicationStatement"} {
+		if !types[want] {
+			t.Fatalf("expected %s in the CapabilityStatement, got %v", want, types)
+		}
+	}
 }
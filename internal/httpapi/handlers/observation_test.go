@@ -0,0 +1,157 @@
+package handlers_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/httpapi/handlers"
+	"go-fhir-server/internal/storage/memory"
+)
+
+const vitalSigns = `"category":[{"coding":[{"system":"http://terminology.hl7.org/CodeSystem/observation-category","code":"vital-signs"}]}]`
+
+func bloodPressure(subject, systolic, diastolic string) string {
+	component := func(code, value string) string {
+		return `{"code":{"coding":[{"system":"http://loinc.org","code":"` + code + `"}]},` +
+			`"valueQuantity":{"value":` + value + `,"unit":"mmHg","system":"http://unitsofmeasure.org","code":"mm[Hg]"}}`
+	}
+	return `{"resourceType":"Observation","status":"final",` + vitalSigns + `,` +
+		`"code":{"coding":[{"system":"http://loinc.org","code":"85354-9"}]},` +
+		`"subject":{"reference":"` + subject + `"},"effectiveDateTime":"2024-03-01T09:30:00Z",` +
+		`"valueString":"` + systolic + `/` + diastolic + ` mmHg",` +
+		`"component":[` + component("8480-6", systolic) + `,` + component("8462-4", diastolic) + `]}`
+}
+
+func TestObservation_Validation(t *testing.T) {
+	h := handlers.Resource(fhir.DefaultRegistry(), memory.NewStore())
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+		return rec
+	}
+	if rec := do(http.MethodPut, "/fhir/Patient/p1", `{"resourceType":"Patient"}`); rec.Code >= 300 {
+		t.Fatalf("PUT patient status=%d body=%s", rec.Code, rec.Body.String())
+	}
+
+	cases := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"quantity", `{"resourceType":"Observation","status":"final","subject":{"reference":"Patient/p1"},"valueQuantity":{"value":72,"unit":"/min"}}`, http.StatusCreated},
+		{"value and components", bloodPressure("Patient/p1", "120", "80"), http.StatusCreated},
+		{"no value", `{"resourceType":"Observation","status":"final","subject":{"reference":"Patient/p1"}}`, http.StatusUnprocessableEntity},
+		{"data absent without value", `{"resourceType":"Observation","status":"final","dataAbsentReason":{"text":"refused"}}`, http.StatusUnprocessableEntity},
+		{"components without value", `{"resourceType":"Observation","status":"final","component":[{"code":{"text":"systolic"},"valueString":"120"}]}`, http.StatusUnprocessableEntity},
+		{"members without value", `{"resourceType":"Observation","status":"final","hasMember":[{"reference":"Observation/o1"}]}`, http.StatusUnprocessableEntity},
+		{"two values", `{"resourceType":"Observation","status":"final","valueString":"high","valueBoolean":true}`, http.StatusUnprocessableEntity},
+		{"value and data absent", `{"resourceType":"Observation","status":"final","valueString":"x","dataAbsentReason":{"text":"y"}}`, http.StatusUnprocessableEntity},
+		{"component without code", `{"resourceType":"Observation","status":"final","valueString":"x","component":[{"valueString":"x"}]}`, http.StatusUnprocessableEntity},
+		{"missing patient", `{"resourceType":"Observation","status":"final","subject":{"reference":"Patient/nobody"},"valueString":"x"}`, http.StatusUnprocessableEntity},
+		{"group subject", `{"resourceType":"Observation","status":"final","subject":{"reference":"Group/g1"},"valueString":"x"}`, http.StatusUnprocessableEntity},
+		{"display-only subject", `{"resourceType":"Observation","status":"final","subject":{"display":"John Doe"},"valueString":"x"}`, http.StatusCreated},
+		{"identifier-only subject", `{"resourceType":"Observation","status":"final","subject":{"identifier":{"system":"http://mrn","value":"123"}},"valueString":"x"}`, http.StatusCreated},
+		{"external subject", `{"resourceType":"Observation","status":"final","subject":{"reference":"http://elsewhere/fhir/Patient/p1"},"valueString":"x"}`, http.StatusUnprocessableEntity},
+	}
+	for _, tc := range cases {
+		rec := do(http.MethodPost, "/fhir/Observation", tc.body)
+		if rec.Code != tc.want {
+			t.Fatalf("%s: expected %d, got %d body=%s", tc.name, tc.want, rec.Code, rec.Body.String())
+		}
+	}
+
+	// Updates and patches are held to the same rules.
+	if rec := do(http.MethodPut, "/fhir/Observation/o1", `{"resourceType":"Observation","status":"final","subject":{"reference":"Patient/p1"},"valueString":"x"}`); rec.Code >= 300 {
+		t.Fatalf("PUT status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodPut, "/fhir/Observation/o1", `{"resourceType":"Observation","status":"final","subject":{"reference":"Patient/p2"},"valueString":"x"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for an update to a missing patient, got %d", rec.Code)
+	}
+	req := httptest.NewRequest(http.MethodPatch, "/fhir/Observation/o1", strings.NewReader(`[{"op":"remove","path":"/valueString"}]`))
+	req.Header.Set("Content-Type", "application/json-patch+json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for a patch removing the value, got %d body=%s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestObservation_TransactionChecksReferencesAtTheEnd(t *testing.T) {
+	h := handlers.Resource(fhir.DefaultRegistry(), memory.NewStore())
+
+	// The Observation comes first and names its Patient by id, not by a
+	// placeholder, so nothing but deferred checking lets it through.
+	rec := postBundle(t, h, `{
+		"resourceType": "Bundle",
+		"type": "transaction",
+		"entry": [
+			{"resource": {"resourceType": "Observation", "status": "final", "subject": {"reference": "Patient/late"}, "valueString": "x"}, "request": {"method": "PUT", "url": "Observation/o1"}},
+			{"resource": {"resourceType": "Patient", "id": "late"}, "request": {"method": "PUT", "url": "Patient/late"}}
+		]
+	}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rec.Code, rec.Body.String())
+	}
+
+	rec = postBundle(t, h, `{
+		"resourceType": "Bundle",
+		"type": "transaction",
+		"entry": [
+			{"resource": {"resourceType": "Patient", "id": "p2"}, "request": {"method": "PUT", "url": "Patient/p2"}},
+			{"resource": {"resourceType": "Observation", "status": "final", "subject": {"reference": "Patient/nobody"}, "valueString": "x"}, "request": {"method": "POST", "url": "Observation"}}
+		]
+	}`)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "entry 1") {
+		t.Fatalf("expected 422 naming entry 1, got %d body=%s", rec.Code, rec.Body.String())
+	}
+	read := httptest.NewRecorder()
+	h.ServeHTTP(read, httptest.NewRequest(http.MethodGet, "/fhir/Patient/p2", nil))
+	if read.Code != http.StatusNotFound {
+		t.Fatalf("expected Patient/p2 to be rolled back, got %d", read.Code)
+	}
+}
+
+func TestSearch_ObservationParameters(t *testing.T) {
+	weight := func(value, code string) string {
+		return `{"resourceType":"Observation","status":"final",` + vitalSigns + `,` +
+			`"code":{"coding":[{"system":"http://loinc.org","code":"29463-7"}]},"subject":{"reference":"Patient/p1"},` +
+			`"effectiveDateTime":"2024-02-01","valueQuantity":{"value":` + value + `,"system":"http://unitsofmeasure.org","code":"` + code + `"}}`
+	}
+	h := newSearchFixture(t,
+		fixture{"/fhir/Patient/p1", `{"resourceType":"Patient"}`},
+		fixture{"/fhir/Patient/p2", `{"resourceType":"Patient"}`},
+		fixture{"/fhir/Observation/bp1", bloodPressure("Patient/p1", "150", "95")},
+		fixture{"/fhir/Observation/bp2", bloodPressure("Patient/p2", "118", "76")},
+		fixture{"/fhir/Observation/w1", weight("72.5", "kg")},
+		fixture{"/fhir/Observation/w2", weight("81000", "g")},
+	)
+
+	cases := []struct {
+		query string
+		want  []string
+	}{
+		{"category=vital-signs&_count=10", []string{"bp1", "bp2", "w1", "w2"}},
+		{"code=http://loinc.org|29463-7", []string{"w1", "w2"}},
+		{"patient=p2", []string{"bp2"}},
+		{"subject=Patient/p1&code=85354-9", []string{"bp1"}},
+		{"date=2024-03", []string{"bp1", "bp2"}},
+		{"date=lt2024-03-01", []string{"w1", "w2"}},
+		{"value-quantity=gt75|http://unitsofmeasure.org|kg", []string{"w2"}},
+		{"value-quantity=le72500|http://unitsofmeasure.org|g", []string{"w1"}},
+		{"value-quantity=gt75000||g", []string{"w2"}},
+		{"component-code-value-quantity=http://loinc.org|8480-6$ge140", []string{"bp1"}},
+		{"component-code-value-quantity=8462-4$lt80", []string{"bp2"}},
+		{"component-code-value-quantity=8462-4$gt120", nil},
+		{"code=29463-7&_sort=-value-quantity", []string{"w2", "w1"}},
+	}
+	for _, tc := range cases {
+		if got := searchIDs(t, h, "Observation?"+tc.query); !slices.Equal(got, tc.want) {
+			t.Fatalf("%s: expected %v, got %v", tc.query, tc.want, got)
+		}
+	}
+}
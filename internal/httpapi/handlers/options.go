@@ -0,0 +1,58 @@
+package handlers
+
+// ConditionalDeleteMode controls DELETE /fhir/{type}?criteria when more than
+// one resource matches.
+type ConditionalDeleteMode int
+
+const (
+	// ConditionalDeleteSingle rejects ambiguous criteria with 412 (the default).
+	ConditionalDeleteSingle ConditionalDeleteMode = iota
+	// ConditionalDeleteMultiple deletes every matching resource.
+	ConditionalDeleteMultiple
+)
+
+// Option tunes the behavior of the Resource handler.
+type Option func(*options)
+
+// Page sizes used for search results when not configured otherwise.
+const (
+	DefaultPageSize    = 20
+	DefaultMaxPageSize = 100
+)
+
+type options struct {
+	conditionalDelete ConditionalDeleteMode
+	maxPageSize       int
+}
+
+func newOptions(opts []Option) options {
+	o := options{conditionalDelete: ConditionalDeleteSingle, maxPageSize: DefaultMaxPageSize}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithConditionalDelete selects single or multiple conditional delete.
+func WithConditionalDelete(mode ConditionalDeleteMode) Option {
+	return func(o *options) { o.conditionalDelete = mode }
+}
+
+// WithMaxPageSize caps the _count a search may ask for. Larger requests are
+// served with n results per page. Values below 1 keep the default.
+func WithMaxPageSize(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.maxPageSize = n
+		}
+	}
+}
+
+// pageSize resolves the requested _count (-1 when absent) against the
+// server's default and maximum.
+func (o options) pageSize(requested int) int {
+	if requested < 0 {
+		requested = DefaultPageSize
+	}
+	return min(requested, o.maxPageSize)
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/httpapi/respond"
+	"go-fhir-server/internal/patch"
+	"go-fhir-server/internal/storage"
+)
+
+// patchResource serves PATCH /fhir/{type}/{id} with either a JSON Patch
+// document (application/json-patch+json) or a FHIRPath Patch Parameters
+// resource (application/fhir+json). The patched result goes through the same
+// validation and versioned write as a PUT.
+func patchResource(rt fhir.ResourceType, store storage.Tx, id string, w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respond.JSON(w, http.StatusBadRequest, fhir.OperationOutcome("unable to read request body"), "application/fhir+json")
+		return
+	}
+
+	expected, ok := expectedVersion(store, rt.Name, id, w, r)
+	if !ok {
+		return
+	}
+
+	current, ok, err := store.Get(rt.Name, id)
+	if err != nil {
+		respond.JSON(w, http.StatusInternalServerError, fhir.OperationOutcome("storage error"), "application/fhir+json")
+		return
+	}
+	if !ok {
+		respond.JSON(w, http.StatusNotFound, fhir.OperationOutcome("not found"), "application/fhir+json")
+		return
+	}
+	// Without If-Match, pin the version the patch was applied to so a
+	// concurrent write can't be silently overwritten.
+	if expected == storage.AnyVersion {
+		expected = versionOf(current)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var patched map[string]any
+	switch mediaType {
+	case "application/json-patch+json":
+		patched, err = patch.ApplyJSONPatch(current, body)
+	case "application/fhir+json", "application/json", "":
+		var params map[string]any
+		if err := json.Unmarshal(body, &params); err != nil {
+			respond.JSON(w, http.StatusBadRequest, fhir.OperationOutcome("invalid JSON body"), "application/fhir+json")
+			return
+		}
+		patched, err = patch.ApplyFHIRPathPatch(rt, current, params)
+	default:
+		respond.JSON(w, http.StatusUnsupportedMediaType, fhir.OperationOutcome("PATCH supports application/json-patch+json or a FHIRPath Patch Parameters resource"), "application/fhir+json")
+		return
+	}
+	if err != nil {
+		respond.JSON(w, http.StatusUnprocessableEntity, fhir.OperationOutcome("unable to apply patch: "+err.Error()), "application/fhir+json")
+		return
+	}
+
+	// Re-validate: a patch must not change what or which resource this is.
+	if got, _ := patched["resourceType"].(string); got != rt.Name {
+		respond.JSON(w, http.StatusUnprocessableEntity, fhir.OperationOutcome("patch must not change resourceType"), "application/fhir+json")
+		return
+	}
+	if got, _ := patched["id"].(string); got != id {
+		respond.JSON(w, http.StatusUnprocessableEntity, fhir.OperationOutcome("patch must not change id"), "application/fhir+json")
+		return
+	}
+
+	writeUpdate(rt, store, id, patched, expected, http.StatusOK, w, r)
+}
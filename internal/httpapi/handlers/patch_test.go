@@ -0,0 +1,92 @@
+package handlers_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/httpapi/handlers"
+	"go-fhir-server/internal/storage/memory"
+)
+
+func TestPatch_JSONPatchAndFHIRPathPatch(t *testing.T) {
+	h := handlers.Resource(fhir.DefaultRegistry(), memory.NewStore())
+
+	do := func(method, path, contentType, body string, header map[string]string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/fhir/Patient", "application/fhir+json", `{"resourceType":"Patient","active":true,"gender":"female"}`, nil)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	id := requireString(t, readJSON(t, rec), "id")
+	path := "/fhir/Patient/" + id
+
+	rec = do(http.MethodPatch, path, "application/json-patch+json", `[{"op":"replace","path":"/active","value":false}]`, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("json patch status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	patched := readJSON(t, rec)
+	if patched["active"] != false || patched["gender"] != "female" {
+		t.Fatalf("expected only active to change, got %v", patched)
+	}
+	if v := requireString(t, requireMap(t, patched, "meta"), "versionId"); v != "2" {
+		t.Fatalf("expected version 2, got %s", v)
+	}
+
+	fhirPathPatch := `{"resourceType":"Parameters","parameter":[{"name":"operation","part":[
+		{"name":"type","valueCode":"replace"},
+		{"name":"path","valueString":"Patient.active"},
+		{"name":"value","valueBoolean":true}]}]}`
+	rec = do(http.MethodPatch, path, "application/fhir+json", fhirPathPatch, map[string]string{"If-Match": `W/"2"`})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("fhirpath patch status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	if etag := rec.Header().Get("ETag"); etag != `W/"3"` {
+		t.Fatalf(`expected ETag W/"3", got %q`, etag)
+	}
+
+	// The previous version is still in history.
+	rec = do(http.MethodGet, path+"/_history/2", "", "", nil)
+	if rec.Code != http.StatusOK || readJSON(t, rec)["active"] != false {
+		t.Fatalf("expected version 2 to keep active=false, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = do(http.MethodPatch, path, "application/fhir+json", fhirPathPatch, map[string]string{"If-Match": `W/"2"`})
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for stale If-Match, got %d", rec.Code)
+	}
+
+	rec = do(http.MethodPatch, path, "application/json-patch+json", `[{"op":"replace","path":"/resourceType","value":"Observation"}]`, nil)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 when patch changes resourceType, got %d", rec.Code)
+	}
+
+	rec = do(http.MethodPatch, path, "application/json-patch+json", `[{"op":"test","path":"/active","value":false}]`, nil)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for failed test op, got %d", rec.Code)
+	}
+
+	rec = do(http.MethodPatch, path, "text/plain", `active=false`, nil)
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected 415, got %d", rec.Code)
+	}
+
+	rec = do(http.MethodPatch, "/fhir/Patient/missing", "application/json-patch+json", `[]`, nil)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 patching a missing resource, got %d", rec.Code)
+	}
+}
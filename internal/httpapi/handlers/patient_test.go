@@ -7,6 +7,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"go-fhir-server/internal/fhir"
 	"go-fhir-server/internal/httpapi/handlers"
 	"go-fhir-server/internal/storage/memory"
 )
@@ -53,8 +54,8 @@ func requireMap(t *testing.T, m map[string]any, key string) map[string]any {
 }
 
 func TestPatient_CreateReadUpdateDelete(t *testing.T) {
-	store := memory.NewPatientStore()
-	h := handlers.Patient(store)
+	store := memory.NewStore()
+	h := handlers.Resource(fhir.DefaultRegistry(), store)
 
 	// ---- CREATE (POST /fhir/Patient)
 	{
@@ -141,8 +142,8 @@ func TestPatient_CreateReadUpdateDelete(t *testing.T) {
 }
 
 func TestPatient_InvalidResourceType(t *testing.T) {
-	store := memory.NewPatientStore()
-	h := handlers.Patient(store)
+	store := memory.NewStore()
+	h := handlers.Resource(fhir.DefaultRegistry(), store)
 
 	body := `{"resourceType":"Observation"}`
 	req := httptest.NewRequest(http.MethodPost, "/fhir/Patient", bytes.NewBufferString(body))
@@ -163,8 +164,8 @@ func TestPatient_InvalidResourceType(t *testing.T) {
 }
 
 func TestPatient_BadIDInPath(t *testing.T) {
-	store := memory.NewPatientStore()
-	h := handlers.Patient(store)
+	store := memory.NewStore()
+	h := handlers.Resource(fhir.DefaultRegistry(), store)
 
 	// underscore is URL-safe but invalid per your FHIR id regex
 	req := httptest.NewRequest(http.MethodGet, "/fhir/Patient/bad_id", nil)
@@ -176,3 +177,28 @@ func TestPatient_BadIDInPath(t *testing.T) {
 		t.Fatalf("expected 400, got %d body=%s", rec.Code, rec.Body.String())
 	}
 }
+
+func TestPatient_CreateWithExistingIDConflicts(t *testing.T) {
+	store := memory.NewStore()
+	h := handlers.Resource(fhir.DefaultRegistry(), store)
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/fhir/Patient", bytes.NewBufferString(body)))
+		return rec
+	}
+
+	if rec := post(`{"resourceType":"Patient","id":"p1","gender":"female"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d body=%s", rec.Code, rec.Body.String())
+	}
+	if rec := post(`{"resourceType":"Patient","id":"p1","gender":"male"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a second create with the same id, got %d body=%s", rec.Code, rec.Body.String())
+	}
+
+	stored, ok, err := store.Get("Patient", "p1")
+	if err != nil || !ok {
+		t.Fatalf("get: ok=%v err=%v", ok, err)
+	}
+	if stored["gender"] != "female" || requireMap(t, stored, "meta")["versionId"] != "1" {
+		t.Fatalf("expected the first version to be untouched, got %v", stored)
+	}
+}
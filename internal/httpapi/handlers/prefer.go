@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/httpapi/respond"
+)
+
+// preferredReturn extracts the return=... preference from the Prefer header,
+// e.g. "return=minimal" or "respond-async, return=OperationOutcome".
+func preferredReturn(r *http.Request) string {
+	for _, header := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(header, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(pref), "=")
+			if strings.EqualFold(strings.TrimSpace(name), "return") {
+				return strings.Trim(strings.TrimSpace(value), `"`)
+			}
+		}
+	}
+	return ""
+}
+
+// writeResult writes the outcome of a create/update according to
+// Prefer: return=minimal|representation|OperationOutcome. Location, ETag and
+// Last-Modified must already be set; message describes the outcome.
+func writeResult(w http.ResponseWriter, r *http.Request, status int, resource map[string]any, message string) {
+	switch pref := preferredReturn(r); pref {
+	case "minimal":
+		w.Header().Set("Preference-Applied", "return="+pref)
+		w.WriteHeader(status)
+	case "OperationOutcome":
+		w.Header().Set("Preference-Applied", "return="+pref)
+		respond.JSON(w, status, fhir.InformationOutcome(message), "application/fhir+json")
+	case "representation":
+		w.Header().Set("Preference-Applied", "return="+pref)
+		respond.JSON(w, status, resource, "application/fhir+json")
+	default:
+		respond.JSON(w, status, resource, "application/fhir+json")
+	}
+}
@@ -0,0 +1,70 @@
+package handlers_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/httpapi/handlers"
+	"go-fhir-server/internal/storage/memory"
+)
+
+func TestPrefer_ReturnOnWrites(t *testing.T) {
+	h := handlers.Resource(fhir.DefaultRegistry(), memory.NewStore())
+
+	do := func(method, path, body, prefer string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		if prefer != "" {
+			req.Header.Set("Prefer", prefer)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/fhir/Patient", `{"resourceType":"Patient","id":"bulk-1"}`, "return=minimal")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	if rec.Body.Len() != 0 {
+		t.Fatalf("expected empty body for return=minimal, got %s", rec.Body.String())
+	}
+	if rec.Header().Get("Location") != "/fhir/Patient/bulk-1" || rec.Header().Get("ETag") != `W/"1"` {
+		t.Fatalf("expected Location and ETag on minimal response, got %v", rec.Header())
+	}
+	if got := rec.Header().Get("Preference-Applied"); got != "return=minimal" {
+		t.Fatalf("expected Preference-Applied return=minimal, got %q", got)
+	}
+
+	rec = do(http.MethodPut, "/fhir/Patient/bulk-1", `{"resourceType":"Patient","id":"bulk-1","active":true}`, "handling=strict, return=OperationOutcome")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	outcome := readJSON(t, rec)
+	if rt := requireString(t, outcome, "resourceType"); rt != "OperationOutcome" {
+		t.Fatalf("expected OperationOutcome body, got %s", rt)
+	}
+	if got := rec.Header().Get("Preference-Applied"); got != "return=OperationOutcome" {
+		t.Fatalf("expected Preference-Applied return=OperationOutcome, got %q", got)
+	}
+
+	rec = do(http.MethodPut, "/fhir/Patient/bulk-1", `{"resourceType":"Patient","id":"bulk-1","active":false}`, "return=representation")
+	if rec.Code != http.StatusOK || readJSON(t, rec)["active"] != false {
+		t.Fatalf("expected full representation, got %d %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Preference-Applied"); got != "return=representation" {
+		t.Fatalf("expected Preference-Applied return=representation, got %q", got)
+	}
+
+	// Without Prefer, the resource is echoed and no preference is reported.
+	rec = do(http.MethodPut, "/fhir/Patient/bulk-1", `{"resourceType":"Patient","id":"bulk-1"}`, "")
+	if rec.Header().Get("Preference-Applied") != "" {
+		t.Fatalf("expected no Preference-Applied without Prefer")
+	}
+	if requireString(t, readJSON(t, rec), "resourceType") != "Patient" {
+		t.Fatalf("expected resource body by default")
+	}
+}
@@ -0,0 +1,437 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/httpapi/respond"
+	"go-fhir-server/internal/search"
+	"go-fhir-server/internal/storage"
+)
+
+// Resource serves the FHIR REST API for every type in the registry, plus
+// batch/transaction Bundles posted to the base /fhir endpoint.
+func Resource(registry *fhir.Registry, store storage.ResourceStore, opts ...Option) http.Handler {
+	o := newOptions(opts)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Trim(strings.TrimPrefix(r.URL.Path, "/fhir"), "/") == "" {
+			if r.Method != http.MethodPost {
+				w.Header().Set("Allow", http.MethodPost)
+				respond.JSON(w, http.StatusMethodNotAllowed, fhir.OperationOutcome("method not allowed"), "application/fhir+json")
+				return
+			}
+			processBundle(registry, o, store, w, r)
+			return
+		}
+		route(registry, o, store, w, r)
+	})
+}
+
+// route dispatches a single REST interaction against store, which is either
+// the ResourceStore itself or an open transaction.
+func route(registry *fhir.Registry, o options, store storage.Tx, w http.ResponseWriter, r *http.Request) {
+	// Route split:
+	// /fhir/_history                      => system history (GET)
+	// /fhir/{type}                        => collection (POST/GET)
+	// /fhir/{type}?criteria               => conditional update/delete (PUT/DELETE)
+	// /fhir/{type}/_history               => type history (GET)
+	// /fhir/{type}/{id}                   => instance (GET/PUT/PATCH/DELETE)
+	// /fhir/{type}/{id}/_history[/{vid}]  => instance history / vread (GET)
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/fhir"), "/"), "/")
+	if len(segments) == 0 || segments[0] == "" || len(segments) > 4 {
+		respond.JSON(w, http.StatusNotFound, fhir.OperationOutcome("not found"), "application/fhir+json")
+		return
+	}
+
+	if segments[0] == "_history" && len(segments) == 1 {
+		if !allowGet(w, r) {
+			return
+		}
+		history(store, "", "", w, r)
+		return
+	}
+
+	rt, ok := registry.Lookup(segments[0])
+	if !ok {
+		respond.JSON(w, http.StatusNotFound, fhir.OperationOutcome("unsupported resource type: "+segments[0]), "application/fhir+json")
+		return
+	}
+
+	if len(segments) == 1 {
+		if strings.HasSuffix(r.URL.Path, "/") {
+			respond.JSON(w, http.StatusNotFound, fhir.OperationOutcome("not found"), "application/fhir+json")
+			return
+		}
+		switch r.Method {
+		case http.MethodPost:
+			createResource(rt, store, w, r)
+		case http.MethodGet:
+			searchResources(registry, rt, o, store, w, r)
+		case http.MethodPut:
+			conditionalUpdate(rt, store, w, r)
+		case http.MethodDelete:
+			conditionalDelete(rt, store, o.conditionalDelete, w, r)
+		default:
+			w.Header().Set("Allow", strings.Join([]string{http.MethodPost, http.MethodGet, http.MethodPut, http.MethodDelete}, ", "))
+			respond.JSON(w, http.StatusMethodNotAllowed, fhir.OperationOutcome("method not allowed"), "application/fhir+json")
+		}
+		return
+	}
+
+	if segments[1] == "_history" && len(segments) == 2 {
+		if !allowGet(w, r) {
+			return
+		}
+		history(store, rt.Name, "", w, r)
+		return
+	}
+
+	id := segments[1]
+	if id == "" {
+		respond.JSON(w, http.StatusNotFound, fhir.OperationOutcome("not found"), "application/fhir+json")
+		return
+	}
+	if !fhir.IDRe.MatchString(id) {
+		respond.JSON(w, http.StatusBadRequest, fhir.OperationOutcome("invalid id"), "application/fhir+json")
+		return
+	}
+
+	if len(segments) > 2 {
+		if segments[2] != "_history" {
+			respond.JSON(w, http.StatusNotFound, fhir.OperationOutcome("not found"), "application/fhir+json")
+			return
+		}
+		if !allowGet(w, r) {
+			return
+		}
+		if len(segments) == 3 {
+			history(store, rt.Name, id, w, r)
+		} else {
+			vread(rt, store, id, segments[3], w, r)
+		}
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		readResource(rt, store, id, w, r)
+	case http.MethodPut:
+		updateResource(rt, store, id, w, r)
+	case http.MethodPatch:
+		patchResource(rt, store, id, w, r)
+	case http.MethodDelete:
+		deleteResource(rt, store, id, w, r)
+	default:
+		w.Header().Set("Allow", strings.Join([]string{http.MethodGet, http.MethodPut, http.MethodPatch, http.MethodDelete}, ", "))
+		respond.JSON(w, http.StatusMethodNotAllowed, fhir.OperationOutcome("method not allowed"), "application/fhir+json")
+	}
+}
+
+// allowGet rejects anything but GET with 405 and reports whether to continue.
+func allowGet(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodGet {
+		return true
+	}
+	w.Header().Set("Allow", http.MethodGet)
+	respond.JSON(w, http.StatusMethodNotAllowed, fhir.OperationOutcome("method not allowed"), "application/fhir+json")
+	return false
+}
+
+func createResource(rt fhir.ResourceType, store storage.Tx, w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	resource, ok := decodeResource(rt, w, r)
+	if !ok {
+		return
+	}
+
+	criteria := r.Header.Get("If-None-Exist")
+	if criteria == "" {
+		insertResource(rt, store, resource, w, r)
+		return
+	}
+
+	// Conditional create: only create when nothing matches If-None-Exist. The
+	// search and the create share a transaction, which keeps other writers of
+	// the type out, so two retries of the same message can't both see no match.
+	atomically(store, w, func(tx storage.Tx, w http.ResponseWriter) {
+		matches, ok := conditionalMatches(rt, tx, criteria, w)
+		if !ok {
+			return
+		}
+		switch len(matches) {
+		case 0:
+			insertResource(rt, tx, resource, w, r)
+		case 1:
+			existing := matches[0]
+			existingID, _ := existing["id"].(string)
+			w.Header().Set("Location", "/fhir/"+rt.Name+"/"+existingID)
+			setVersionHeaders(w, existing)
+			writeResult(w, r, http.StatusOK, existing, rt.Name+"/"+existingID+" already exists; nothing created")
+		default:
+			respond.JSON(w, http.StatusPreconditionFailed, fhir.OperationOutcome("If-None-Exist matched multiple resources"), "application/fhir+json")
+		}
+	})
+}
+
+// insertResource assigns an id unless the client supplied one, validates
+// resource and stores it as a new resource.
+func insertResource(rt fhir.ResourceType, store storage.Tx, resource map[string]any, w http.ResponseWriter, r *http.Request) {
+	// assign id + meta
+	if _, exists := resource["id"]; !exists {
+		resource["id"] = newFHIRID()
+	} else if !isNonEmptyString(resource["id"]) {
+		respond.JSON(w, http.StatusBadRequest, fhir.OperationOutcome("id must be a non-empty string when provided"), "application/fhir+json")
+		return
+	}
+
+	id := resource["id"].(string)
+	if !fhir.IDRe.MatchString(id) {
+		respond.JSON(w, http.StatusBadRequest, fhir.OperationOutcome("id is not a valid FHIR id"), "application/fhir+json")
+		return
+	}
+
+	if !validateResource(rt, store, resource, w) {
+		return
+	}
+
+	// Version 0: a create never replaces a resource that already has the id.
+	stored, err := store.Put(rt.Name, id, resource, 0)
+	var conflict *storage.ConflictError
+	if errors.As(err, &conflict) {
+		respond.JSON(w, http.StatusConflict, fhir.OperationOutcome(rt.Name+"/"+id+" already exists; use PUT to update it"), "application/fhir+json")
+		return
+	}
+	if err != nil {
+		respond.JSON(w, http.StatusInternalServerError, fhir.OperationOutcome("failed to store "+rt.Name), "application/fhir+json")
+		return
+	}
+
+	w.Header().Set("Location", "/fhir/"+rt.Name+"/"+id)
+	setVersionHeaders(w, stored)
+	writeResult(w, r, http.StatusCreated, stored, "created "+rt.Name+"/"+id)
+}
+
+func readResource(rt fhir.ResourceType, store storage.Tx, id string, w http.ResponseWriter, r *http.Request) {
+	res, ok, err := store.Get(rt.Name, id)
+	if err != nil {
+		respond.JSON(w, http.StatusInternalServerError, fhir.OperationOutcome("storage error"), "application/fhir+json")
+		return
+	}
+	if !ok {
+		respond.JSON(w, http.StatusNotFound, fhir.OperationOutcome("not found"), "application/fhir+json")
+		return
+	}
+	setVersionHeaders(w, res)
+	if notModified(r, res) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	respond.JSON(w, http.StatusOK, res, "application/fhir+json")
+}
+
+func updateResource(rt fhir.ResourceType, store storage.Tx, id string, w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	resource, ok := decodeResource(rt, w, r)
+	if !ok {
+		return
+	}
+
+	// force body id to match path id (or set it)
+	if v, exists := resource["id"]; exists {
+		if !isNonEmptyString(v) || v.(string) != id {
+			respond.JSON(w, http.StatusBadRequest, fhir.OperationOutcome("body.id must match URL id"), "application/fhir+json")
+			return
+		}
+	} else {
+		resource["id"] = id
+	}
+
+	expected, ok := expectedVersion(store, rt.Name, id, w, r)
+	if !ok {
+		return
+	}
+
+	writeUpdate(rt, store, id, resource, expected, http.StatusOK, w, r)
+}
+
+// writeUpdate validates resource, stores it as the next version of id and
+// writes the response. The store assigns the next versionId atomically with
+// the write.
+func writeUpdate(rt fhir.ResourceType, store storage.Tx, id string, resource map[string]any, expected, status int, w http.ResponseWriter, r *http.Request) {
+	if !validateResource(rt, store, resource, w) {
+		return
+	}
+	expected, ok := applyTransition(rt, store, id, resource, expected, w, r)
+	if !ok {
+		return
+	}
+
+	stored, err := store.Put(rt.Name, id, resource, expected)
+	var conflict *storage.ConflictError
+	if errors.As(err, &conflict) {
+		writeConflict(w, conflict)
+		return
+	}
+	if err != nil {
+		respond.JSON(w, http.StatusInternalServerError, fhir.OperationOutcome("failed to store "+rt.Name), "application/fhir+json")
+		return
+	}
+
+	message := "updated " + rt.Name + "/" + id
+	if status == http.StatusCreated {
+		w.Header().Set("Location", "/fhir/"+rt.Name+"/"+id)
+		message = "created " + rt.Name + "/" + id
+	}
+	setVersionHeaders(w, stored)
+	writeResult(w, r, status, stored, message)
+}
+
+func deleteResource(rt fhir.ResourceType, store storage.Tx, id string, w http.ResponseWriter, r *http.Request) {
+	expected, ok := expectedVersion(store, rt.Name, id, w, r)
+	if !ok {
+		return
+	}
+
+	ok, err := store.Delete(rt.Name, id, expected)
+	var conflict *storage.ConflictError
+	if errors.As(err, &conflict) {
+		writeConflict(w, conflict)
+		return
+	}
+	if err != nil {
+		respond.JSON(w, http.StatusInternalServerError, fhir.OperationOutcome("storage error"), "application/fhir+json")
+		return
+	}
+	if !ok {
+		respond.JSON(w, http.StatusNotFound, fhir.OperationOutcome("not found"), "application/fhir+json")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// searchResources serves GET /fhir/{type}?params. Unknown parameters are
+// rejected rather than ignored, so a typo never silently widens the result.
+// Results are paged with _count and opaque _cursor tokens in the links;
+// _include and _revinclude add related resources with search.mode "include".
+func searchResources(registry *fhir.Registry, rt fhir.ResourceType, o options, store storage.Tx, w http.ResponseWriter, r *http.Request) {
+	q, err := search.ParseChained(rt, r.URL.Query(), searchResolver{registry, store})
+	if errors.Is(err, search.ErrResolve) {
+		respond.JSON(w, http.StatusInternalServerError, fhir.OperationOutcome("storage error"), "application/fhir+json")
+		return
+	}
+	if err != nil {
+		respond.JSON(w, http.StatusBadRequest, fhir.OperationOutcome(err.Error()), "application/fhir+json")
+		return
+	}
+	includes, err := resolveIncludes(registry, q.Includes)
+	if err != nil {
+		respond.JSON(w, http.StatusBadRequest, fhir.OperationOutcome(err.Error()), "application/fhir+json")
+		return
+	}
+
+	matches, err := store.Search(q)
+	if err != nil {
+		respond.JSON(w, http.StatusInternalServerError, fhir.OperationOutcome("storage error"), "application/fhir+json")
+		return
+	}
+
+	page, err := q.Page(matches, o.pageSize(q.Count))
+	if err != nil {
+		respond.JSON(w, http.StatusBadRequest, fhir.OperationOutcome(err.Error()), "application/fhir+json")
+		return
+	}
+
+	included, err := includedResources(store, includes, page.Resources)
+	if err != nil {
+		respond.JSON(w, http.StatusInternalServerError, fhir.OperationOutcome("storage error"), "application/fhir+json")
+		return
+	}
+
+	entries := make([]map[string]any, 0, len(page.Resources)+len(included))
+	for _, res := range page.Resources {
+		entries = append(entries, searchEntry(res, "match"))
+	}
+	for _, res := range included {
+		entries = append(entries, searchEntry(res, "include"))
+	}
+
+	links := []any{map[string]any{"relation": "self", "url": r.URL.RequestURI()}}
+	if page.Next != "" {
+		links = append(links, map[string]any{"relation": "next", "url": pageURL(r, page.Next)})
+	}
+	if page.Prev != "" {
+		links = append(links, map[string]any{"relation": "previous", "url": pageURL(r, page.Prev)})
+	}
+
+	bundle := map[string]any{
+		"resourceType": "Bundle",
+		"type":         "searchset",
+		"total":        len(matches),
+		"link":         links,
+		"entry":        entries,
+	}
+
+	respond.JSON(w, http.StatusOK, bundle, "application/fhir+json")
+}
+
+// searchResolver lets chained and _has parameters search other resource
+// types in the same store (or transaction) as the main search.
+type searchResolver struct {
+	*fhir.Registry
+	storage.Tx
+}
+
+func searchEntry(res map[string]any, mode string) map[string]any {
+	return map[string]any{
+		"fullUrl":  "/fhir/" + resourceKey(res),
+		"resource": res,
+		"search":   map[string]any{"mode": mode},
+	}
+}
+
+// pageURL is the request URL with its _cursor replaced by token.
+func pageURL(r *http.Request, token string) string {
+	values := r.URL.Query()
+	values.Set("_cursor", token)
+	return r.URL.Path + "?" + values.Encode()
+}
+
+func decodeResource(rt fhir.ResourceType, w http.ResponseWriter, r *http.Request) (map[string]any, bool) {
+	dec := json.NewDecoder(r.Body)
+
+	var payload map[string]any
+	if err := dec.Decode(&payload); err != nil {
+		respond.JSON(w, http.StatusBadRequest, fhir.OperationOutcome("invalid JSON body"), "application/fhir+json")
+		return nil, false
+	}
+
+	got, ok := payload["resourceType"]
+	if !ok || !isNonEmptyString(got) || got.(string) != rt.Name {
+		respond.JSON(w, http.StatusBadRequest, fhir.OperationOutcome("resourceType must be '"+rt.Name+"'"), "application/fhir+json")
+		return nil, false
+	}
+
+	return payload, true
+}
+
+func isNonEmptyString(v any) bool {
+	s, ok := v.(string)
+	return ok && strings.TrimSpace(s) != ""
+}
+
+func newFHIRID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "id-" + time.Now().UTC().Format("20060102150405")
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,78 @@
+package handlers_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/httpapi/handlers"
+	"go-fhir-server/internal/storage/memory"
+)
+
+func TestResource_ServesEveryRegisteredType(t *testing.T) {
+	registry := fhir.NewRegistry(
+		fhir.Patient(),
+		fhir.ResourceType{Name: "Observation"},
+	)
+	h := handlers.Resource(registry, memory.NewStore())
+
+	body := `{"resourceType":"Observation","status":"final"}`
+	req := httptest.NewRequest(http.MethodPost, "/fhir/Observation", bytes.NewBufferString(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	created := readJSON(t, rec)
+	id := requireString(t, created, "id")
+	if loc := rec.Header().Get("Location"); loc != "/fhir/Observation/"+id {
+		t.Fatalf("unexpected Location %q", loc)
+	}
+
+	// Search is scoped to the requested type.
+	req = httptest.NewRequest(http.MethodGet, "/fhir/Patient", nil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("search status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	if total, _ := readJSON(t, rec)["total"].(float64); total != 0 {
+		t.Fatalf("expected 0 patients, got %v", total)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/fhir/Observation/"+id, nil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("read status=%d body=%s", rec.Code, rec.Body.String())
+	}
+
+	// A Patient body posted to the Observation endpoint is rejected.
+	req = httptest.NewRequest(http.MethodPost, "/fhir/Observation", bytes.NewBufferString(`{"resourceType":"Patient"}`))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d body=%s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestResource_UnregisteredTypeIsNotFound(t *testing.T) {
+	h := handlers.Resource(fhir.DefaultRegistry(), memory.NewStore())
+
+	for _, path := range []string{"/fhir/Basic", "/fhir/Basic/123", "/fhir/Patient/123/extra"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected 404, got %d body=%s", path, rec.Code, rec.Body.String())
+		}
+		if !strings.Contains(rec.Body.String(), "OperationOutcome") {
+			t.Fatalf("%s: expected OperationOutcome body, got %s", path, rec.Body.String())
+		}
+	}
+}
@@ -12,8 +12,12 @@ func Root() http.Handler {
 			"ok": true,
 			"paths": []string{
 				"/ping",
-				"/fhir/Patient (POST create, GET search)",
-				"/fhir/Patient/{id} (GET read, PUT update, DELETE delete)",
+				"/fhir (POST batch/transaction Bundle)",
+				"/fhir/metadata (GET CapabilityStatement)",
+				"/fhir/{type} (POST create, GET search)",
+				"/fhir/{type}/{id} (GET read, PUT update, PATCH patch, DELETE delete)",
+				"/fhir/{type}/{id}/_history[/{vid}] (GET history, vread)",
+				"/fhir/{type}/_history, /fhir/_history (GET history)",
 			},
 		}, "application/json")
 	})
@@ -0,0 +1,264 @@
+package handlers_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/httpapi/handlers"
+	"go-fhir-server/internal/storage/memory"
+)
+
+// fixture is a resource PUT to path before a test runs.
+type fixture struct{ path, body string }
+
+// newSearchFixture serves an indexed in-memory store holding fixtures, which
+// are PUT in order so that references between them resolve.
+func newSearchFixture(t *testing.T, fixtures ...fixture) http.Handler {
+	t.Helper()
+	h := handlers.Resource(fhir.DefaultRegistry(), memory.NewStore(memory.WithIndexes(fhir.DefaultRegistry())))
+	for _, f := range fixtures {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, f.path, bytes.NewBufferString(f.body)))
+		if rec.Code >= 300 {
+			t.Fatalf("PUT %s status=%d body=%s", f.path, rec.Code, rec.Body.String())
+		}
+	}
+	return h
+}
+
+// searchIDs runs GET /fhir/{query} and returns the ids of every entry,
+// matches and includes alike. They are sorted unless the query orders them
+// with _sort, so that a comparison doesn't depend on write timing.
+func searchIDs(t *testing.T, h http.Handler, query string) []string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fhir/"+query, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s: status=%d body=%s", query, rec.Code, rec.Body.String())
+	}
+	ids := []string{}
+	entries, _ := readJSON(t, rec)["entry"].([]any)
+	for _, e := range entries {
+		ids = append(ids, e.(map[string]any)["resource"].(map[string]any)["id"].(string))
+	}
+	_, rawQuery, _ := strings.Cut(query, "?")
+	if values, _ := url.ParseQuery(rawQuery); !values.Has("_sort") {
+		slices.Sort(ids)
+	}
+	return ids
+}
+
+func TestSearch_PatientParameters(t *testing.T) {
+	h := handlers.Resource(fhir.DefaultRegistry(), memory.NewStore(memory.WithIndexes(fhir.DefaultRegistry())))
+
+	for _, body := range []string{
+		`{"resourceType":"Patient","id":"jose","gender":"male","birthDate":"1975-02-11","name":[{"family":"Núñez","given":["José"]}]}`,
+		`{"resourceType":"Patient","id":"ann","gender":"female","birthDate":"1990-08-01","name":[{"family":"Nunn","given":["Ann"]}],"identifier":[{"system":"http://mrn","value":"42"}]}`,
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/fhir/Patient", bytes.NewBufferString(body)))
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("create status=%d body=%s", rec.Code, rec.Body.String())
+		}
+	}
+
+	cases := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"ann", "jose"}},
+		{"family=nun", []string{"ann", "jose"}},
+		{"family:exact=Nunn", []string{"ann"}},
+		{"given=jose", []string{"jose"}},
+		{"gender=female", []string{"ann"}},
+		{"birthdate=lt1980", []string{"jose"}},
+		{"identifier=http://mrn|42", []string{"ann"}},
+		{"name=ann&gender=male", nil},
+		// Standard result parameters are accepted, not taken as criteria.
+		{"name=ann&_format=json", []string{"ann"}},
+		{"gender=male&_summary=false&_elements=name&_pretty=true&_total=accurate&_contained=false", []string{"jose"}},
+	}
+
+	for _, tc := range cases {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fhir/Patient?"+tc.query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status=%d body=%s", tc.query, rec.Code, rec.Body.String())
+		}
+		got := map[string]bool{}
+		entries, _ := readJSON(t, rec)["entry"].([]any)
+		for _, e := range entries {
+			got[e.(map[string]any)["resource"].(map[string]any)["id"].(string)] = true
+		}
+		if len(got) != len(tc.want) {
+			t.Fatalf("%s: expected %v, got %v", tc.query, tc.want, got)
+		}
+		for _, id := range tc.want {
+			if !got[id] {
+				t.Fatalf("%s: expected %s in %v", tc.query, id, got)
+			}
+		}
+	}
+
+	for _, query := range []string{"shoe-size=9", "birthdate=yesterday", "gender:exact=male"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fhir/Patient?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestSearch_PagingLinks(t *testing.T) {
+	h := handlers.Resource(fhir.DefaultRegistry(), memory.NewStore(), handlers.WithMaxPageSize(3))
+
+	for _, id := range []string{"p1", "p2", "p3", "p4", "p5"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/fhir/Patient/"+id, bytes.NewBufferString(`{"resourceType":"Patient","gender":"male"}`)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("put %s status=%d body=%s", id, rec.Code, rec.Body.String())
+		}
+	}
+
+	get := func(url string) map[string]any {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s status=%d body=%s", url, rec.Code, rec.Body.String())
+		}
+		return readJSON(t, rec)
+	}
+	links := func(bundle map[string]any) map[string]string {
+		out := map[string]string{}
+		for _, l := range bundle["link"].([]any) {
+			m := l.(map[string]any)
+			out[m["relation"].(string)] = m["url"].(string)
+		}
+		return out
+	}
+	ids := func(bundle map[string]any) string {
+		var s string
+		entries, _ := bundle["entry"].([]any)
+		for _, e := range entries {
+			s += e.(map[string]any)["resource"].(map[string]any)["id"].(string)
+		}
+		return s
+	}
+
+	// _count above the server maximum is capped.
+	first := get("/fhir/Patient?gender=male&_count=10")
+	if ids(first) != "p1p2p3" || first["total"] != float64(5) {
+		t.Fatalf("unexpected first page %q total=%v", ids(first), first["total"])
+	}
+	l := links(first)
+	if l["self"] != "/fhir/Patient?gender=male&_count=10" || l["previous"] != "" || l["next"] == "" {
+		t.Fatalf("unexpected links on first page: %v", l)
+	}
+
+	second := get(l["next"])
+	if ids(second) != "p4p5" {
+		t.Fatalf("unexpected second page %q", ids(second))
+	}
+	l = links(second)
+	if l["next"] != "" || l["previous"] == "" {
+		t.Fatalf("unexpected links on last page: %v", l)
+	}
+	if back := get(l["previous"]); ids(back) != "p1p2p3" {
+		t.Fatalf("expected previous link to return the first page, got %q", ids(back))
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fhir/Patient?_cursor=garbage", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid cursor, got %d", rec.Code)
+	}
+}
+
+func TestSearch_SortAndPageTogether(t *testing.T) {
+	h := handlers.Resource(fhir.DefaultRegistry(), memory.NewStore(memory.WithIndexes(fhir.DefaultRegistry())))
+
+	for id, birthDate := range map[string]string{"a": "2001-01-01", "b": "1999-01-01", "c": "2000-01-01"} {
+		rec := httptest.NewRecorder()
+		body := `{"resourceType":"Patient","birthDate":"` + birthDate + `"}`
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/fhir/Patient/"+id, bytes.NewBufferString(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("put %s status=%d body=%s", id, rec.Code, rec.Body.String())
+		}
+	}
+
+	var got string
+	url := "/fhir/Patient?_sort=-birthdate&_count=1"
+	for url != "" {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s status=%d body=%s", url, rec.Code, rec.Body.String())
+		}
+		bundle := readJSON(t, rec)
+		for _, e := range bundle["entry"].([]any) {
+			got += e.(map[string]any)["resource"].(map[string]any)["id"].(string)
+		}
+		url = ""
+		for _, l := range bundle["link"].([]any) {
+			if l.(map[string]any)["relation"] == "next" {
+				url = l.(map[string]any)["url"].(string)
+			}
+		}
+	}
+	if got != "acb" {
+		t.Fatalf("expected newest birthDate first across pages, got %s", got)
+	}
+}
+
+func TestSearch_ChainedAndHas(t *testing.T) {
+	h := handlers.Resource(fhir.DefaultRegistry(), memory.NewStore(memory.WithIndexes(fhir.DefaultRegistry())))
+
+	// Patients first: an Observation's subject must exist.
+	for _, put := range []struct{ path, body string }{
+		{"/fhir/Patient/p1", `{"resourceType":"Patient","identifier":[{"system":"sys","value":"123"}]}`},
+		{"/fhir/Patient/p2", `{"resourceType":"Patient","identifier":[{"system":"sys","value":"456"}]}`},
+		{"/fhir/Observation/o1", `{"resourceType":"Observation","status":"final","code":{"coding":[{"system":"http://loinc.org","code":"1234-5"}]},"subject":{"reference":"Patient/p1"},"valueString":"positive"}`},
+		{"/fhir/Observation/o2", `{"resourceType":"Observation","status":"final","code":{"coding":[{"system":"http://loinc.org","code":"9999-9"}]},"subject":{"reference":"Patient/p2"},"valueString":"negative"}`},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, put.path, bytes.NewBufferString(put.body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("PUT %s status=%d body=%s", put.path, rec.Code, rec.Body.String())
+		}
+	}
+
+	ids := func(url string) string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s status=%d body=%s", url, rec.Code, rec.Body.String())
+		}
+		var s string
+		entries, _ := readJSON(t, rec)["entry"].([]any)
+		for _, e := range entries {
+			s += e.(map[string]any)["resource"].(map[string]any)["id"].(string)
+		}
+		return s
+	}
+
+	if got := ids("/fhir/Observation?subject:Patient.identifier=sys|123"); got != "o1" {
+		t.Fatalf("chained search: expected o1, got %q", got)
+	}
+	if got := ids("/fhir/Patient?_has:Observation:subject:code=1234-5"); got != "p1" {
+		t.Fatalf("_has search: expected p1, got %q", got)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fhir/Patient?_has:Observation:status:code=1", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for _has through a non-reference parameter, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/httpapi/respond"
+	"go-fhir-server/internal/search"
+	"go-fhir-server/internal/storage"
+)
+
+// pendingReference is a reference a transaction entry made, checked once all
+// entries have run.
+type pendingReference struct {
+	entry  int
+	path   string
+	target string
+}
+
+// transactionTx is the store transaction a transaction Bundle's entries run
+// against. It defers reference checks to the end, since a later entry may
+// create the target.
+type transactionTx struct {
+	storage.Tx
+	entry   int
+	pending []pendingReference
+}
+
+// validateResource applies rt's Validate and References rules to a resource
+// about to be stored. It writes a 422 and returns false when one is broken.
+// Only literal references are checked; a "#id" reference must name one of
+// the resource's contained resources.
+func validateResource(rt fhir.ResourceType, store storage.Tx, resource map[string]any, w http.ResponseWriter) bool {
+	if rt.Validate != nil {
+		if err := rt.Validate(resource); err != nil {
+			respond.JSON(w, http.StatusUnprocessableEntity, fhir.OperationOutcome(err.Error()), "application/fhir+json")
+			return false
+		}
+	}
+
+	for _, rule := range rt.References {
+		for _, el := range search.Values(resource, rule.Path) {
+			m, _ := el.(map[string]any)
+			ref, _ := m["reference"].(string)
+			if ref == "" {
+				// A Reference may identify its target only by identifier or
+				// display; there is nothing on this server to check.
+				continue
+			}
+			if local, contained := strings.CutPrefix(ref, "#"); contained {
+				if !containsResource(resource, local, rule.Targets) {
+					msg := rt.Name + "." + rule.Path + " references " + ref + ", which is not a contained " + strings.Join(rule.Targets, " or ")
+					respond.JSON(w, http.StatusUnprocessableEntity, fhir.OperationOutcome(msg), "application/fhir+json")
+					return false
+				}
+				continue
+			}
+			targetType, id, ok := search.ParseReference(ref)
+			if !ok || strings.Contains(ref, "://") || !slices.Contains(rule.Targets, targetType) {
+				msg := rt.Name + "." + rule.Path + " must reference " + strings.Join(rule.Targets, " or ") + " on this server, as Type/id"
+				respond.JSON(w, http.StatusUnprocessableEntity, fhir.OperationOutcome(msg), "application/fhir+json")
+				return false
+			}
+			if tx, deferred := store.(*transactionTx); deferred {
+				tx.pending = append(tx.pending, pendingReference{entry: tx.entry, path: rt.Name + "." + rule.Path, target: targetType + "/" + id})
+				continue
+			}
+			if !checkReference(store, rt.Name+"."+rule.Path, targetType+"/"+id, w) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// containsResource reports whether resource contains a resource with id of
+// one of the types targets.
+func containsResource(resource map[string]any, id string, targets []string) bool {
+	contained, _ := resource["contained"].([]any)
+	for _, c := range contained {
+		m, _ := c.(map[string]any)
+		if rt, _ := m["resourceType"].(string); m["id"] == id && slices.Contains(targets, rt) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkReference writes a 422 and returns false unless target exists.
+func checkReference(store storage.Tx, path, target string, w http.ResponseWriter) bool {
+	targetType, id, _ := strings.Cut(target, "/")
+	_, ok, err := store.Get(targetType, id)
+	if err != nil {
+		respond.JSON(w, http.StatusInternalServerError, fhir.OperationOutcome("storage error"), "application/fhir+json")
+		return false
+	}
+	if !ok {
+		respond.JSON(w, http.StatusUnprocessableEntity, fhir.OperationOutcome(path+" references "+target+", which does not exist"), "application/fhir+json")
+		return false
+	}
+	return true
+}
+
+// applyTransition runs rt.Transition against the stored version of id. It
+// returns the version the write must be pinned to, so that a concurrent
+// change can't slip past the check, or writes an error and returns false.
+// Clients override the rules with ?_force=true.
+func applyTransition(rt fhir.ResourceType, store storage.Tx, id string, resource map[string]any, expected int, w http.ResponseWriter, r *http.Request) (int, bool) {
+	if rt.Transition == nil {
+		return expected, true
+	}
+	current, ok, err := store.Get(rt.Name, id)
+	if err != nil {
+		respond.JSON(w, http.StatusInternalServerError, fhir.OperationOutcome("storage error"), "application/fhir+json")
+		return 0, false
+	}
+	if expected == storage.AnyVersion {
+		expected = 0
+		if ok {
+			expected = versionOf(current)
+		}
+	}
+	if !ok {
+		return expected, true
+	}
+	if err := rt.Transition(current, resource, forced(r)); err != nil {
+		respond.JSON(w, http.StatusUnprocessableEntity, fhir.OperationOutcome(err.Error()), "application/fhir+json")
+		return 0, false
+	}
+	return expected, true
+}
+
+// forced reports whether the request asks to override business rules such
+// as a status state machine.
+func forced(r *http.Request) bool {
+	return r.URL.Query().Get("_force") == "true"
+}
@@ -0,0 +1,476 @@
+package patch
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go-fhir-server/internal/fhir"
+)
+
+// ApplyFHIRPathPatch applies a FHIRPath Patch Parameters resource to a copy
+// of doc, a resource of type rt. Paths support the subset of FHIRPath used for
+// patching in practice: element navigation, [n] indexers, first()/last() and
+// where(path = literal). "add" takes from rt whether a new element starts a
+// list, and fails for elements whose cardinality rt doesn't know.
+func ApplyFHIRPathPatch(rt fhir.ResourceType, doc map[string]any, params map[string]any) (map[string]any, error) {
+	if rt, _ := params["resourceType"].(string); rt != "Parameters" {
+		return nil, fmt.Errorf("FHIRPath Patch body must be a Parameters resource")
+	}
+	rootType, _ := doc["resourceType"].(string)
+
+	out := deepCopy(doc)
+	parameters, _ := params["parameter"].([]any)
+	for i, p := range parameters {
+		pm, _ := p.(map[string]any)
+		if name, _ := pm["name"].(string); name != "operation" {
+			return nil, fmt.Errorf("parameter %d: expected name 'operation'", i)
+		}
+		op, err := parseOperation(pm)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %v", i, err)
+		}
+		if err := op.apply(out, rootType, rt); err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %v", i, op.kind, op.path, err)
+		}
+	}
+	return out, nil
+}
+
+type operation struct {
+	kind        string
+	path        string
+	name        string
+	value       any
+	hasValue    bool
+	index       int
+	source      int
+	destination int
+}
+
+func parseOperation(param map[string]any) (operation, error) {
+	op := operation{index: -1, source: -1, destination: -1}
+	parts, _ := param["part"].([]any)
+	for _, raw := range parts {
+		part, _ := raw.(map[string]any)
+		name, _ := part["name"].(string)
+		switch name {
+		case "type":
+			op.kind, _ = part["valueCode"].(string)
+		case "path":
+			op.path, _ = part["valueString"].(string)
+		case "name":
+			op.name, _ = part["valueString"].(string)
+		case "value":
+			v, ok := partValue(part)
+			if !ok {
+				return op, fmt.Errorf("value part has no value[x]")
+			}
+			op.value, op.hasValue = v, true
+		case "index", "source", "destination":
+			n, ok := part["valueInteger"].(float64)
+			if !ok {
+				return op, fmt.Errorf("%s must be a valueInteger", name)
+			}
+			switch name {
+			case "index":
+				op.index = int(n)
+			case "source":
+				op.source = int(n)
+			case "destination":
+				op.destination = int(n)
+			}
+		}
+	}
+
+	if op.path == "" {
+		return op, fmt.Errorf("missing path")
+	}
+	switch op.kind {
+	case "add":
+		if op.name == "" || !op.hasValue {
+			return op, fmt.Errorf("add requires name and value")
+		}
+	case "insert":
+		if !op.hasValue || op.index < 0 {
+			return op, fmt.Errorf("insert requires value and index")
+		}
+	case "replace":
+		if !op.hasValue {
+			return op, fmt.Errorf("replace requires value")
+		}
+	case "move":
+		if op.source < 0 || op.destination < 0 {
+			return op, fmt.Errorf("move requires source and destination")
+		}
+	case "delete":
+	default:
+		return op, fmt.Errorf("unsupported operation type %q", op.kind)
+	}
+	return op, nil
+}
+
+// partValue extracts value[x] from a Parameters part, or builds an object
+// from nested parts for complex values given part-by-part.
+func partValue(part map[string]any) (any, bool) {
+	for k, v := range part {
+		if strings.HasPrefix(k, "value") {
+			return deepCopyValue(v), true
+		}
+	}
+	nested, ok := part["part"].([]any)
+	if !ok {
+		return nil, false
+	}
+	obj := map[string]any{}
+	for _, raw := range nested {
+		np, _ := raw.(map[string]any)
+		name, _ := np["name"].(string)
+		if v, ok := partValue(np); ok && name != "" {
+			obj[name] = v
+		}
+	}
+	return obj, true
+}
+
+func (op operation) apply(doc map[string]any, rootType string, rt fhir.ResourceType) error {
+	switch op.kind {
+	case "add":
+		locs, err := evaluate(doc, rootType, op.path)
+		if err != nil {
+			return err
+		}
+		if len(locs) != 1 {
+			return fmt.Errorf("path must match exactly one element, matched %d", len(locs))
+		}
+		target, ok := locs[0].get().(map[string]any)
+		if !ok {
+			return fmt.Errorf("path does not resolve to an object")
+		}
+		switch existing := target[op.name].(type) {
+		case nil:
+			path, err := elementPath(op.path, op.name)
+			if err != nil {
+				return err
+			}
+			repeats, known := rt.Repeats(path)
+			if !known {
+				return fmt.Errorf("cardinality of %s.%s is not known; use JSON Patch to add it", rt.Name, path)
+			}
+			if repeats {
+				target[op.name] = []any{op.value}
+			} else {
+				target[op.name] = op.value
+			}
+		case []any:
+			target[op.name] = append(existing, op.value)
+		default:
+			return fmt.Errorf("element %q already has a value", op.name)
+		}
+		return nil
+
+	case "insert", "move":
+		parent, name, err := listOwner(doc, rootType, op.path)
+		if err != nil {
+			return err
+		}
+		list, _ := parent[name].([]any)
+		if op.kind == "insert" {
+			if op.index > len(list) {
+				return fmt.Errorf("index %d out of range", op.index)
+			}
+			list = append(list, nil)
+			copy(list[op.index+1:], list[op.index:])
+			list[op.index] = op.value
+		} else {
+			if op.source >= len(list) || op.destination >= len(list) {
+				return fmt.Errorf("move index out of range")
+			}
+			item := list[op.source]
+			list = append(list[:op.source:op.source], list[op.source+1:]...)
+			list = append(list[:op.destination], append([]any{item}, list[op.destination:]...)...)
+		}
+		parent[name] = list
+		return nil
+
+	case "replace":
+		locs, err := evaluate(doc, rootType, op.path)
+		if err != nil {
+			return err
+		}
+		if len(locs) != 1 {
+			return fmt.Errorf("path must match exactly one element, matched %d", len(locs))
+		}
+		if locs[0].parent == nil {
+			return fmt.Errorf("cannot replace the whole resource")
+		}
+		locs[0].set(op.value)
+		return nil
+
+	case "delete":
+		locs, err := evaluate(doc, rootType, op.path)
+		if err != nil {
+			return err
+		}
+		// Deleting something that isn't there is explicitly not an error.
+		if len(locs) == 0 {
+			return nil
+		}
+		if len(locs) > 1 {
+			return fmt.Errorf("path must match at most one element, matched %d", len(locs))
+		}
+		if locs[0].parent == nil {
+			return fmt.Errorf("cannot delete the whole resource")
+		}
+		locs[0].remove()
+		return nil
+	}
+	return fmt.Errorf("unsupported operation type %q", op.kind)
+}
+
+// location addresses one element: parent[key], or parent[key][index] when the
+// element is a list item. The resource root has a nil parent.
+type location struct {
+	root   map[string]any
+	parent map[string]any
+	key    string
+	index  int
+}
+
+func (l location) get() any {
+	if l.parent == nil {
+		return l.root
+	}
+	if l.index >= 0 {
+		list, _ := l.parent[l.key].([]any)
+		return list[l.index]
+	}
+	return l.parent[l.key]
+}
+
+func (l location) set(v any) {
+	if l.index >= 0 {
+		l.parent[l.key].([]any)[l.index] = v
+		return
+	}
+	l.parent[l.key] = v
+}
+
+func (l location) remove() {
+	if l.index < 0 {
+		delete(l.parent, l.key)
+		return
+	}
+	list := l.parent[l.key].([]any)
+	list = append(list[:l.index:l.index], list[l.index+1:]...)
+	if len(list) == 0 {
+		delete(l.parent, l.key)
+		return
+	}
+	l.parent[l.key] = list
+}
+
+// listOwner resolves "X.y" to the single object X and the list element name y,
+// which is what insert and move operate on.
+func listOwner(doc map[string]any, rootType, path string) (map[string]any, string, error) {
+	steps, err := splitPath(path)
+	if err != nil {
+		return nil, "", err
+	}
+	last := steps[len(steps)-1]
+	if len(steps) < 2 || !isIdentifier(last) {
+		return nil, "", fmt.Errorf("path must end in a list element name")
+	}
+	locs, err := evaluateSteps(doc, rootType, steps[:len(steps)-1])
+	if err != nil {
+		return nil, "", err
+	}
+	if len(locs) != 1 {
+		return nil, "", fmt.Errorf("path must match exactly one owner element, matched %d", len(locs))
+	}
+	owner, ok := locs[0].get().(map[string]any)
+	if !ok {
+		return nil, "", fmt.Errorf("path does not resolve to an object")
+	}
+	if v, exists := owner[last]; exists {
+		if _, isList := v.([]any); !isList {
+			return nil, "", fmt.Errorf("element %q is not a list", last)
+		}
+	}
+	return owner, last, nil
+}
+
+// elementPath turns the path of an add and the name it adds into a dotted
+// element path from the resource: "Patient.name.where(use='official')" and
+// "given" become "name.given".
+func elementPath(path, name string) (string, error) {
+	steps, err := splitPath(path)
+	if err != nil {
+		return "", err
+	}
+	var elems []string
+	for _, step := range steps[1:] {
+		if strings.HasSuffix(step, ")") {
+			continue
+		}
+		if open := strings.Index(step, "["); open >= 0 {
+			step = step[:open]
+		}
+		elems = append(elems, step)
+	}
+	return strings.Join(append(elems, name), "."), nil
+}
+
+func evaluate(doc map[string]any, rootType, path string) ([]location, error) {
+	steps, err := splitPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return evaluateSteps(doc, rootType, steps)
+}
+
+func evaluateSteps(doc map[string]any, rootType string, steps []string) ([]location, error) {
+	if len(steps) == 0 || steps[0] != rootType {
+		return nil, fmt.Errorf("path must start with %s", rootType)
+	}
+
+	current := []location{{root: doc, index: -1}}
+	for _, step := range steps[1:] {
+		var err error
+		if current, err = evalStep(current, step); err != nil {
+			return nil, err
+		}
+	}
+	return current, nil
+}
+
+func evalStep(in []location, step string) ([]location, error) {
+	switch {
+	case step == "first()":
+		if len(in) == 0 {
+			return nil, nil
+		}
+		return in[:1], nil
+	case step == "last()":
+		if len(in) == 0 {
+			return nil, nil
+		}
+		return in[len(in)-1:], nil
+	case strings.HasPrefix(step, "where(") && strings.HasSuffix(step, ")"):
+		lhs, literal, err := parseEquality(step[len("where(") : len(step)-1])
+		if err != nil {
+			return nil, err
+		}
+		var out []location
+		for _, l := range in {
+			node, _ := l.get().(map[string]any)
+			if node == nil {
+				continue
+			}
+			sub := []location{{root: node, index: -1}}
+			for _, s := range strings.Split(lhs, ".") {
+				sub, _ = evalStep(sub, s)
+			}
+			for _, s := range sub {
+				if fmt.Sprint(s.get()) == literal {
+					out = append(out, l)
+					break
+				}
+			}
+		}
+		return out, nil
+	}
+
+	name, index := step, -1
+	if open := strings.Index(step, "["); open >= 0 && strings.HasSuffix(step, "]") {
+		n, err := strconv.Atoi(step[open+1 : len(step)-1])
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid indexer in %q", step)
+		}
+		name, index = step[:open], n
+	}
+	if !isIdentifier(name) {
+		return nil, fmt.Errorf("unsupported FHIRPath step %q", step)
+	}
+
+	var out []location
+	for _, l := range in {
+		node, _ := l.get().(map[string]any)
+		if node == nil {
+			continue
+		}
+		switch v := node[name].(type) {
+		case nil:
+		case []any:
+			for i := range v {
+				out = append(out, location{parent: node, key: name, index: i})
+			}
+		default:
+			out = append(out, location{parent: node, key: name, index: -1})
+		}
+	}
+	if index >= 0 {
+		if index >= len(out) {
+			return nil, nil
+		}
+		return out[index : index+1], nil
+	}
+	return out, nil
+}
+
+// parseEquality parses "a.b = 'text'" (or a number/boolean) from a where() clause.
+func parseEquality(expr string) (string, string, error) {
+	lhs, rhs, ok := strings.Cut(expr, "=")
+	if !ok {
+		return "", "", fmt.Errorf("where() only supports path = literal")
+	}
+	lhs, rhs = strings.TrimSpace(lhs), strings.TrimSpace(rhs)
+	if strings.HasPrefix(rhs, "'") && strings.HasSuffix(rhs, "'") && len(rhs) >= 2 {
+		rhs = strings.ReplaceAll(rhs[1:len(rhs)-1], `\'`, "'")
+	}
+	return lhs, rhs, nil
+}
+
+// splitPath splits a FHIRPath expression on dots that are outside of
+// parentheses and string literals.
+func splitPath(path string) ([]string, error) {
+	var steps []string
+	depth, inString, start := 0, false, 0
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; {
+		case c == '\'' && (i == 0 || path[i-1] != '\\'):
+			inString = !inString
+		case inString:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == '.' && depth == 0:
+			steps = append(steps, strings.TrimSpace(path[start:i]))
+			start = i + 1
+		}
+	}
+	if depth != 0 || inString {
+		return nil, fmt.Errorf("unbalanced FHIRPath expression %q", path)
+	}
+	steps = append(steps, strings.TrimSpace(path[start:]))
+	for _, s := range steps {
+		if s == "" {
+			return nil, fmt.Errorf("empty step in FHIRPath expression %q", path)
+		}
+	}
+	return steps, nil
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		isLetter := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+		if !isLetter && c != '_' && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
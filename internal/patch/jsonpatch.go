@@ -0,0 +1,299 @@
+// Package patch applies JSON Patch (RFC 6902) documents and FHIRPath Patch
+// Parameters resources to decoded FHIR resources.
+package patch
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type jsonPatchOp struct {
+	Op    string          `json:"op"`
+	Path  *string         `json:"path"`
+	From  *string         `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// ApplyJSONPatch applies an RFC 6902 patch document to a copy of doc. The
+// original is left untouched. The patch is all-or-nothing: any failing
+// operation discards the partial result.
+func ApplyJSONPatch(doc map[string]any, patchDoc []byte) (map[string]any, error) {
+	var ops []jsonPatchOp
+	if err := json.Unmarshal(patchDoc, &ops); err != nil {
+		return nil, fmt.Errorf("JSON Patch must be an array of operations: %v", err)
+	}
+
+	var root any = deepCopy(doc)
+	for i, op := range ops {
+		if op.Path == nil {
+			return nil, fmt.Errorf("operation %d: missing path", i)
+		}
+		path, err := parsePointer(*op.Path)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %v", i, err)
+		}
+
+		var value any
+		needsValue := op.Op == "add" || op.Op == "replace" || op.Op == "test"
+		if needsValue {
+			if len(op.Value) == 0 {
+				return nil, fmt.Errorf("operation %d: %s requires a value", i, op.Op)
+			}
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return nil, fmt.Errorf("operation %d: invalid value: %v", i, err)
+			}
+		}
+
+		var from []string
+		if op.Op == "move" || op.Op == "copy" {
+			if op.From == nil {
+				return nil, fmt.Errorf("operation %d: %s requires from", i, op.Op)
+			}
+			if from, err = parsePointer(*op.From); err != nil {
+				return nil, fmt.Errorf("operation %d: %v", i, err)
+			}
+		}
+
+		switch op.Op {
+		case "add":
+			root, err = pointerAdd(root, path, value)
+		case "remove":
+			root, _, err = pointerRemove(root, path)
+		case "replace":
+			root, err = pointerReplace(root, path, value)
+		case "move":
+			if isPrefix(from, path) && len(from) < len(path) {
+				err = fmt.Errorf("cannot move %s into one of its children", *op.From)
+				break
+			}
+			var moved any
+			if root, moved, err = pointerRemove(root, from); err == nil {
+				root, err = pointerAdd(root, path, moved)
+			}
+		case "copy":
+			var copied any
+			if copied, err = pointerGet(root, from); err == nil {
+				root, err = pointerAdd(root, path, deepCopyValue(copied))
+			}
+		case "test":
+			var got any
+			if got, err = pointerGet(root, path); err == nil && !reflect.DeepEqual(got, value) {
+				err = fmt.Errorf("test failed at %s", *op.Path)
+			}
+		default:
+			err = fmt.Errorf("unsupported op %q", op.Op)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s): %v", i, op.Op, err)
+		}
+	}
+
+	out, ok := root.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("patch result is not a JSON object")
+	}
+	return out, nil
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into unescaped tokens.
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(p, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", p)
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		t = strings.ReplaceAll(t, "~1", "/")
+		tokens[i] = strings.ReplaceAll(t, "~0", "~")
+	}
+	return tokens, nil
+}
+
+func isPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func pointerGet(node any, path []string) (any, error) {
+	for _, tok := range path {
+		next, err := child(node, tok)
+		if err != nil {
+			return nil, err
+		}
+		node = next
+	}
+	return node, nil
+}
+
+func child(node any, tok string) (any, error) {
+	switch n := node.(type) {
+	case map[string]any:
+		v, ok := n[tok]
+		if !ok {
+			return nil, fmt.Errorf("path element %q not found", tok)
+		}
+		return v, nil
+	case []any:
+		i, err := arrayIndex(tok, len(n)-1)
+		if err != nil {
+			return nil, err
+		}
+		return n[i], nil
+	default:
+		return nil, fmt.Errorf("cannot descend into %q of a primitive value", tok)
+	}
+}
+
+// arrayIndex parses tok as an index in [0, max].
+func arrayIndex(tok string, max int) (int, error) {
+	i, err := strconv.Atoi(tok)
+	if err != nil || i < 0 || (len(tok) > 1 && tok[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", tok)
+	}
+	if i > max {
+		return 0, fmt.Errorf("array index %d out of range", i)
+	}
+	return i, nil
+}
+
+// mutate walks to the parent of the last path token, lets op rebuild that
+// parent and writes the result back up the tree. Rebuilding is needed because
+// inserting into a slice may reallocate it.
+func mutate(node any, path []string, op func(parent any, last string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return op(node, path[0])
+	}
+	c, err := child(node, path[0])
+	if err != nil {
+		return nil, err
+	}
+	updated, err := mutate(c, path[1:], op)
+	if err != nil {
+		return nil, err
+	}
+	switch n := node.(type) {
+	case map[string]any:
+		n[path[0]] = updated
+	case []any:
+		i, _ := strconv.Atoi(path[0])
+		n[i] = updated
+	}
+	return node, nil
+}
+
+func pointerAdd(root any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return mutate(root, path, func(parent any, last string) (any, error) {
+		switch p := parent.(type) {
+		case map[string]any:
+			p[last] = value
+			return p, nil
+		case []any:
+			if last == "-" {
+				return append(p, value), nil
+			}
+			i, err := arrayIndex(last, len(p))
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, nil)
+			copy(p[i+1:], p[i:])
+			p[i] = value
+			return p, nil
+		default:
+			return nil, fmt.Errorf("cannot add %q to a primitive value", last)
+		}
+	})
+}
+
+func pointerRemove(root any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, nil, fmt.Errorf("cannot remove the whole resource")
+	}
+	var removed any
+	root, err := mutate(root, path, func(parent any, last string) (any, error) {
+		switch p := parent.(type) {
+		case map[string]any:
+			v, ok := p[last]
+			if !ok {
+				return nil, fmt.Errorf("path element %q not found", last)
+			}
+			removed = v
+			delete(p, last)
+			return p, nil
+		case []any:
+			i, err := arrayIndex(last, len(p)-1)
+			if err != nil {
+				return nil, err
+			}
+			removed = p[i]
+			return append(p[:i:i], p[i+1:]...), nil
+		default:
+			return nil, fmt.Errorf("cannot remove %q from a primitive value", last)
+		}
+	})
+	return root, removed, err
+}
+
+func pointerReplace(root any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return mutate(root, path, func(parent any, last string) (any, error) {
+		switch p := parent.(type) {
+		case map[string]any:
+			if _, ok := p[last]; !ok {
+				return nil, fmt.Errorf("path element %q not found", last)
+			}
+			p[last] = value
+			return p, nil
+		case []any:
+			i, err := arrayIndex(last, len(p)-1)
+			if err != nil {
+				return nil, err
+			}
+			p[i] = value
+			return p, nil
+		default:
+			return nil, fmt.Errorf("cannot replace %q in a primitive value", last)
+		}
+	})
+}
+
+func deepCopy(m map[string]any) map[string]any {
+	out, _ := deepCopyValue(m).(map[string]any)
+	return out
+}
+
+func deepCopyValue(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(t))
+		for k, val := range t {
+			out[k] = deepCopyValue(val)
+		}
+		return out
+	case []any:
+		out := make([]any, len(t))
+		for i, val := range t {
+			out[i] = deepCopyValue(val)
+		}
+		return out
+	default:
+		return v
+	}
+}
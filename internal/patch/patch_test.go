@@ -0,0 +1,203 @@
+package patch
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"go-fhir-server/internal/fhir"
+)
+
+func decode(t *testing.T, s string) map[string]any {
+	t.Helper()
+	var m map[string]any
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		t.Fatalf("bad fixture: %v", err)
+	}
+	return m
+}
+
+const patientFixture = `{
+	"resourceType": "Patient",
+	"id": "p1",
+	"active": false,
+	"name": [
+		{"use": "official", "family": "Doe", "given": ["Jane"]},
+		{"use": "nickname", "given": ["JD"]}
+	],
+	"a/b": 1,
+	"m~n": 2
+}`
+
+func TestApplyJSONPatch(t *testing.T) {
+	doc := decode(t, patientFixture)
+
+	got, err := ApplyJSONPatch(doc, []byte(`[
+		{"op": "test", "path": "/active", "value": false},
+		{"op": "replace", "path": "/active", "value": true},
+		{"op": "add", "path": "/name/0/given/-", "value": "Q"},
+		{"op": "add", "path": "/name/0/given/0", "value": "Dr"},
+		{"op": "remove", "path": "/name/1"},
+		{"op": "copy", "from": "/name/0/family", "path": "/birthName"},
+		{"op": "move", "from": "/a~1b", "path": "/ab"},
+		{"op": "remove", "path": "/m~0n"}
+	]`))
+	if err != nil {
+		t.Fatalf("apply err: %v", err)
+	}
+
+	want := decode(t, `{
+		"resourceType": "Patient",
+		"id": "p1",
+		"active": true,
+		"name": [{"use": "official", "family": "Doe", "given": ["Dr", "Jane", "Q"]}],
+		"birthName": "Doe",
+		"ab": 1
+	}`)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected result:\n got=%v\nwant=%v", got, want)
+	}
+	if doc["active"] != false {
+		t.Fatalf("expected original document to be untouched")
+	}
+}
+
+func TestApplyJSONPatch_Failures(t *testing.T) {
+	doc := decode(t, patientFixture)
+
+	for _, p := range []string{
+		`{"op": "replace"}`,
+		`[{"op": "test", "path": "/active", "value": true}]`,
+		`[{"op": "replace", "path": "/missing", "value": 1}]`,
+		`[{"op": "remove", "path": "/name/5"}]`,
+		`[{"op": "add", "path": "/name/01", "value": {}}]`,
+		`[{"op": "move", "from": "/name", "path": "/name/0"}]`,
+		`[{"op": "frobnicate", "path": "/active"}]`,
+	} {
+		if _, err := ApplyJSONPatch(doc, []byte(p)); err == nil {
+			t.Fatalf("expected error for %s", p)
+		}
+	}
+}
+
+func opParam(parts ...map[string]any) map[string]any {
+	ps := make([]any, len(parts))
+	for i, p := range parts {
+		ps[i] = p
+	}
+	return map[string]any{"name": "operation", "part": ps}
+}
+
+func part(name string, kv ...any) map[string]any {
+	m := map[string]any{"name": name}
+	for i := 0; i+1 < len(kv); i += 2 {
+		m[kv[i].(string)] = kv[i+1]
+	}
+	return m
+}
+
+func TestApplyFHIRPathPatch(t *testing.T) {
+	doc := decode(t, patientFixture)
+	params := map[string]any{
+		"resourceType": "Parameters",
+		"parameter": []any{
+			opParam(part("type", "valueCode", "replace"), part("path", "valueString", "Patient.active"), part("value", "valueBoolean", true)),
+			opParam(part("type", "valueCode", "add"), part("path", "valueString", "Patient"), part("name", "valueString", "birthDate"), part("value", "valueDate", "1980-02-03")),
+			opParam(part("type", "valueCode", "add"), part("path", "valueString", "Patient"), part("name", "valueString", "telecom"),
+				map[string]any{"name": "value", "part": []any{
+					part("system", "valueCode", "phone"),
+					part("value", "valueString", "555-0100"),
+				}}),
+			opParam(part("type", "valueCode", "insert"), part("path", "valueString", "Patient.name.where(use = 'official').given"), part("value", "valueString", "Ann"), part("index", "valueInteger", float64(0))),
+			opParam(part("type", "valueCode", "delete"), part("path", "valueString", "Patient.name.where(use='nickname')")),
+			opParam(part("type", "valueCode", "delete"), part("path", "valueString", "Patient.deceasedBoolean")),
+			opParam(part("type", "valueCode", "move"), part("path", "valueString", "Patient.name.first().given"), part("source", "valueInteger", float64(1)), part("destination", "valueInteger", float64(0))),
+		},
+	}
+
+	got, err := ApplyFHIRPathPatch(fhir.Patient(), doc, params)
+	if err != nil {
+		t.Fatalf("apply err: %v", err)
+	}
+
+	want := decode(t, `{
+		"resourceType": "Patient",
+		"id": "p1",
+		"active": true,
+		"birthDate": "1980-02-03",
+		"telecom": [{"system": "phone", "value": "555-0100"}],
+		"name": [{"use": "official", "family": "Doe", "given": ["Jane", "Ann"]}],
+		"a/b": 1,
+		"m~n": 2
+	}`)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected result:\n got=%v\nwant=%v", got, want)
+	}
+}
+
+func TestApplyFHIRPathPatch_Failures(t *testing.T) {
+	doc := decode(t, patientFixture)
+
+	for name, op := range map[string]map[string]any{
+		"wrong root":     opParam(part("type", "valueCode", "replace"), part("path", "valueString", "Observation.status"), part("value", "valueCode", "final")),
+		"ambiguous":      opParam(part("type", "valueCode", "replace"), part("path", "valueString", "Patient.name"), part("value", "valueString", "x")),
+		"missing value":  opParam(part("type", "valueCode", "replace"), part("path", "valueString", "Patient.active")),
+		"bad index":      opParam(part("type", "valueCode", "insert"), part("path", "valueString", "Patient.name"), part("value", "valueString", "x"), part("index", "valueInteger", float64(9))),
+		"unknown type":   opParam(part("type", "valueCode", "upsert"), part("path", "valueString", "Patient.active")),
+		"already single": opParam(part("type", "valueCode", "add"), part("path", "valueString", "Patient"), part("name", "valueString", "active"), part("value", "valueBoolean", true)),
+	} {
+		params := map[string]any{"resourceType": "Parameters", "parameter": []any{op}}
+		if _, err := ApplyFHIRPathPatch(fhir.Patient(), doc, params); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+func TestApplyFHIRPathPatch_AddFollowsTypeCardinality(t *testing.T) {
+	add := func(path, name string) map[string]any {
+		return map[string]any{"resourceType": "Parameters", "parameter": []any{
+			opParam(part("type", "valueCode", "add"), part("path", "valueString", path), part("name", "valueString", name), part("value", "valueString", "x")),
+		}}
+	}
+	cases := []struct {
+		name string
+		rt   fhir.ResourceType
+		doc  string
+		path string
+		elem string
+		want string
+	}{
+		{"Location.address is 0..1", fhir.Location(), `{"resourceType":"Location"}`, "Location", "address", `{"resourceType":"Location","address":"x"}`},
+		{"Procedure.category is 0..1", fhir.Procedure(), `{"resourceType":"Procedure"}`, "Procedure", "category", `{"resourceType":"Procedure","category":"x"}`},
+		{"Encounter.type is 0..*", fhir.Encounter(), `{"resourceType":"Encounter"}`, "Encounter", "type", `{"resourceType":"Encounter","type":["x"]}`},
+		{"Observation.interpretation is 0..*", fhir.Observation(), `{"resourceType":"Observation"}`, "Observation", "interpretation", `{"resourceType":"Observation","interpretation":["x"]}`},
+		{"choice element", fhir.Observation(), `{"resourceType":"Observation"}`, "Observation", "valueString", `{"resourceType":"Observation","valueString":"x"}`},
+		{"backbone element", fhir.Encounter(), `{"resourceType":"Encounter","participant":[{}]}`, "Encounter.participant[0]", "type", `{"resourceType":"Encounter","participant":[{"type":["x"]}]}`},
+		{"data type element", fhir.Patient(), `{"resourceType":"Patient","name":[{"use":"official"}]}`, "Patient.name.where(use = 'official')", "given", `{"resourceType":"Patient","name":[{"use":"official","given":["x"]}]}`},
+	}
+	for _, tc := range cases {
+		got, err := ApplyFHIRPathPatch(tc.rt, decode(t, tc.doc), add(tc.path, tc.elem))
+		if err != nil {
+			t.Fatalf("%s: apply err: %v", tc.name, err)
+		}
+		if want := decode(t, tc.want); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: unexpected result:\n got=%v\nwant=%v", tc.name, got, want)
+		}
+	}
+
+	// Elements whose cardinality isn't known are rejected rather than guessed.
+	for _, tc := range []struct {
+		rt   fhir.ResourceType
+		doc  string
+		path string
+		elem string
+	}{
+		{fhir.Patient(), `{"resourceType":"Patient"}`, "Patient", "nickname"},
+		{fhir.Encounter(), `{"resourceType":"Encounter","participant":[{}]}`, "Encounter.participant[0]", "role"},
+		{fhir.Patient(), `{"resourceType":"Patient","contained":[{"resourceType":"Organization"}]}`, "Patient.contained[0]", "name"},
+	} {
+		if _, err := ApplyFHIRPathPatch(tc.rt, decode(t, tc.doc), add(tc.path, tc.elem)); err == nil {
+			t.Fatalf("%s add %s: expected error", tc.path, tc.elem)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"go-fhir-server/internal/fhir"
+)
+
+// Resolver gives the search engine access to other resource types, which
+// chained and _has parameters need. storage.Tx together with fhir.Registry
+// satisfies it.
+type Resolver interface {
+	Lookup(name string) (fhir.ResourceType, bool)
+	Search(q Query) ([]map[string]any, error)
+}
+
+// ErrResolve wraps failures to read from the Resolver, as opposed to
+// problems with the query itself.
+var ErrResolve = errors.New("resolving chained search")
+
+// idParam is the _id parameter, used for the criteria _has turns into.
+var idParam = commonParams[0]
+
+// resolveChain evaluates one chained or _has parameter for rt and returns
+// the equivalent criterion on rt itself.
+func resolveChain(rt fhir.ResourceType, raw, value string, resolver Resolver) (Criterion, error) {
+	if rest, ok := strings.CutPrefix(raw, "_has:"); ok {
+		return resolveHas(rt, rest, value, resolver)
+	}
+
+	head, rest, _ := strings.Cut(raw, ".")
+	name, typeModifier, _ := strings.Cut(head, ":")
+	param, ok := lookupParam(rt, name)
+	if !ok {
+		return Criterion{}, fmt.Errorf("unknown search parameter %q for %s", name, rt.Name)
+	}
+	if param.Type != fhir.SearchReference {
+		return Criterion{}, fmt.Errorf("cannot chain through %s: it is not a reference parameter", name)
+	}
+	targets := param.Targets
+	if typeModifier != "" {
+		if !slices.Contains(targets, typeModifier) {
+			return Criterion{}, fmt.Errorf("%s cannot refer to %s", name, typeModifier)
+		}
+		targets = []string{typeModifier}
+	}
+
+	// Find the referenced resources first, then match references to them.
+	// With several possible targets, the chain only has to make sense for one.
+	refs := []string{}
+	var lastErr error
+	searched := false
+	for _, target := range targets {
+		trt, ok := resolver.Lookup(target)
+		if !ok {
+			continue
+		}
+		matches, err := subSearch(trt, rest, value, resolver)
+		if errors.Is(err, ErrResolve) {
+			return Criterion{}, err
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		searched = true
+		for _, m := range matches {
+			id, _ := m["id"].(string)
+			refs = append(refs, target+"/"+id)
+		}
+	}
+	if !searched {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no supported target type for chained parameter %q", raw)
+		}
+		return Criterion{}, lastErr
+	}
+	// No referenced resource matched: refs stays empty and matches nothing.
+	return Criterion{Param: param, Values: refs}, nil
+}
+
+// resolveHas evaluates "_has:Type:param:rest=value": the resources of rt
+// that some Type resource, matching rest=value, references through param.
+func resolveHas(rt fhir.ResourceType, spec, value string, resolver Resolver) (Criterion, error) {
+	parts := strings.SplitN(spec, ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return Criterion{}, fmt.Errorf("_has must look like _has:Type:reference:parameter, got %q", "_has:"+spec)
+	}
+	source, ok := resolver.Lookup(parts[0])
+	if !ok {
+		return Criterion{}, fmt.Errorf("unsupported resource type in _has: %s", parts[0])
+	}
+	param, ok := source.SearchParam(parts[1])
+	if !ok || param.Type != fhir.SearchReference || !slices.Contains(param.Targets, rt.Name) {
+		return Criterion{}, fmt.Errorf("%s:%s is not a reference to %s", parts[0], parts[1], rt.Name)
+	}
+
+	matches, err := subSearch(source, parts[2], value, resolver)
+	if err != nil {
+		return Criterion{}, err
+	}
+	ids := []string{}
+	for _, m := range matches {
+		for _, ref := range References(m, param) {
+			if refType, id, _ := ParseReference(ref); refType == rt.Name {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return Criterion{Param: idParam, Values: ids}, nil
+}
+
+// resolveBelow evaluates "param:below=value" for a reference parameter that
+// points back at rt, such as Location.partof: the resources that are part of
+// value directly or through any number of intermediate levels, but not value
+// itself. value is a "Type/id" reference or a bare id; values may be ORed.
+func resolveBelow(rt fhir.ResourceType, param fhir.SearchParam, value string, resolver Resolver) (Criterion, error) {
+	if !slices.Contains(param.Targets, rt.Name) {
+		return Criterion{}, fmt.Errorf("%s:below needs a reference from %s to itself", param.Name, rt.Name)
+	}
+
+	seen := make(map[string]bool)
+	var frontier []string
+	for _, v := range strings.Split(value, ",") {
+		ref := v
+		if _, _, typed := ParseReference(v); !typed {
+			ref = rt.Name + "/" + v
+		}
+		seen[ref] = true
+		frontier = append(frontier, ref)
+	}
+
+	ids := []string{}
+	for len(frontier) > 0 {
+		matches, err := resolver.Search(Query{
+			ResourceType: rt.Name,
+			Count:        -1,
+			Criteria:     []Criterion{{Param: param, Values: frontier}},
+		})
+		if err != nil {
+			return Criterion{}, fmt.Errorf("%w: %v", ErrResolve, err)
+		}
+		frontier = nil
+		for _, m := range matches {
+			id, _ := m["id"].(string)
+			ref := rt.Name + "/" + id
+			// A hierarchy with a cycle is still walked only once.
+			if seen[ref] {
+				continue
+			}
+			seen[ref] = true
+			ids = append(ids, id)
+			frontier = append(frontier, ref)
+		}
+	}
+	return Criterion{Param: idParam, Values: ids}, nil
+}
+
+// subSearch runs name=value against every resource of rt. name may itself be
+// chained or another _has.
+func subSearch(rt fhir.ResourceType, name, value string, resolver Resolver) ([]map[string]any, error) {
+	if base, _, _ := strings.Cut(name, ":"); strings.HasPrefix(base, "_") && base != "_has" {
+		// Result parameters such as _sort make no sense inside a chain.
+		if _, ok := lookupParam(rt, base); !ok {
+			return nil, fmt.Errorf("%s cannot be used in a chained parameter", base)
+		}
+	}
+	q, err := parse(rt, url.Values{name: {value}}, resolver)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := resolver.Search(q)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrResolve, err)
+	}
+	return matches, nil
+}
@@ -0,0 +1,39 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+
+	"go-fhir-server/internal/fhir"
+)
+
+// splitComposite splits a composite search value such as
+// "http://loinc.org|8480-6$gt100" into one value per component.
+func splitComposite(param fhir.SearchParam, value string) ([]string, error) {
+	parts := strings.Split(value, "$")
+	if len(parts) != len(param.Components) {
+		return nil, fmt.Errorf("%s expects %d values separated by $, got %q", param.Name, len(param.Components), value)
+	}
+	return parts, nil
+}
+
+// matchComposite reports whether one element, e.g. a single
+// Observation.component, satisfies every part of a composite value. The
+// parts must match the same element, not just any element of the resource.
+func matchComposite(element any, value string, param fhir.SearchParam) bool {
+	el, ok := element.(map[string]any)
+	if !ok {
+		return false
+	}
+	parts, err := splitComposite(param, value)
+	if err != nil {
+		return false
+	}
+	for i, part := range parts {
+		c := Criterion{Param: param.Components[i], Values: []string{part}}
+		if !c.matches(el) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,165 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// datePrefixes are the FHIR comparison prefixes valid for date parameters.
+var datePrefixes = []string{"eq", "ne", "gt", "lt", "ge", "le", "sa", "eb", "ap"}
+
+// DateRange is the half-open interval [Start, End) a date value covers at its
+// precision: "2020" spans the whole year, "2020-03-01" a single day. A zero
+// Start or End is unbounded, as in a Period missing one side.
+type DateRange struct {
+	Start, End time.Time
+}
+
+func (r DateRange) startsBefore(t time.Time) bool { return r.Start.IsZero() || r.Start.Before(t) }
+func (r DateRange) endsAfter(t time.Time) bool    { return r.End.IsZero() || r.End.After(t) }
+
+// within reports whether r lies entirely inside outer.
+func (r DateRange) within(outer DateRange) bool {
+	return !r.Start.IsZero() && !r.End.IsZero() &&
+		!r.Start.Before(outer.Start) && !r.End.After(outer.End)
+}
+
+func (r DateRange) overlaps(other DateRange) bool {
+	return r.startsBefore(other.End) && r.endsAfter(other.Start)
+}
+
+// dateLayouts pairs each accepted FHIR date/dateTime layout with the step to
+// the end of the range it denotes.
+var dateLayouts = []struct {
+	layout string
+	step   func(time.Time) time.Time
+}{
+	{"2006", func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }},
+	{"2006-01", func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }},
+	{"2006-01-02", func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }},
+	{"2006-01-02T15:04Z07:00", func(t time.Time) time.Time { return t.Add(time.Minute) }},
+	{"2006-01-02T15:04", func(t time.Time) time.Time { return t.Add(time.Minute) }},
+	{"2006-01-02T15:04:05Z07:00", func(t time.Time) time.Time { return t.Add(time.Second) }},
+	{"2006-01-02T15:04:05", func(t time.Time) time.Time { return t.Add(time.Second) }},
+}
+
+// parseDateRange parses a FHIR date, dateTime or instant into the range it
+// covers. Values without a time zone are taken as UTC.
+func parseDateRange(s string) (DateRange, bool) {
+	for _, l := range dateLayouts {
+		t, err := time.Parse(l.layout, s)
+		if err == nil {
+			return DateRange{Start: t, End: l.step(t)}, true
+		}
+	}
+	// Fractional seconds: the range is as precise as the digits given.
+	if dot := strings.IndexByte(s, '.'); dot > 0 {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			t, err = time.Parse("2006-01-02T15:04:05.999999999", s)
+		}
+		if err == nil {
+			digits := len(strings.TrimRightFunc(s[dot+1:], func(r rune) bool { return r < '0' || r > '9' }))
+			unit := time.Second
+			for i := 0; i < digits && i < 9; i++ {
+				unit /= 10
+			}
+			return DateRange{Start: t, End: t.Add(unit)}, true
+		}
+	}
+	return DateRange{}, false
+}
+
+// parseDateValue splits a date search value such as "ge2020-01" into its
+// prefix (default "eq") and the range it denotes.
+func parseDateValue(v string) (string, DateRange, error) {
+	prefix := "eq"
+	for _, p := range datePrefixes {
+		if strings.HasPrefix(v, p) {
+			prefix, v = p, v[len(p):]
+			break
+		}
+	}
+	r, ok := parseDateRange(v)
+	if !ok {
+		return "", DateRange{}, fmt.Errorf("invalid date %q", v)
+	}
+	return prefix, r, nil
+}
+
+// elementRange reads a date, dateTime, instant or Period element.
+func elementRange(element any) (DateRange, bool) {
+	switch el := element.(type) {
+	case string:
+		return parseDateRange(el)
+	case map[string]any:
+		var out DateRange
+		start, hasStart := el["start"].(string)
+		end, hasEnd := el["end"].(string)
+		if !hasStart && !hasEnd {
+			return DateRange{}, false
+		}
+		if hasStart {
+			r, ok := parseDateRange(start)
+			if !ok {
+				return DateRange{}, false
+			}
+			out.Start = r.Start
+		}
+		if hasEnd {
+			r, ok := parseDateRange(end)
+			if !ok {
+				return DateRange{}, false
+			}
+			// The end of a Period is inclusive at its own precision.
+			out.End = r.End
+		}
+		return out, true
+	}
+	return DateRange{}, false
+}
+
+// matchDate compares element against a date search value using the prefix
+// semantics from the FHIR search spec, where both sides are ranges.
+func matchDate(element any, value string, now time.Time) bool {
+	target, ok := elementRange(element)
+	return ok && matchDateRange(target, value, now)
+}
+
+func matchDateRange(target DateRange, value string, now time.Time) bool {
+	prefix, search, err := parseDateValue(value)
+	if err != nil {
+		return false
+	}
+
+	switch prefix {
+	case "eq":
+		return target.within(search)
+	case "ne":
+		return !target.within(search)
+	case "gt":
+		return target.endsAfter(search.End)
+	case "lt":
+		return target.startsBefore(search.Start)
+	case "ge":
+		return target.endsAfter(search.End) || target.within(search)
+	case "le":
+		return target.startsBefore(search.Start) || target.within(search)
+	case "sa":
+		return !target.Start.IsZero() && !target.Start.Before(search.End)
+	case "eb":
+		return !target.End.IsZero() && !target.End.After(search.Start)
+	case "ap":
+		// The spec leaves "approximately" to the server; like most servers
+		// we allow 10% of the distance between the value and now.
+		slack := now.Sub(search.Start)
+		if slack < 0 {
+			slack = -slack
+		}
+		slack /= 10
+		widened := DateRange{Start: search.Start.Add(-slack), End: search.End.Add(slack)}
+		return target.overlaps(widened)
+	}
+	return false
+}
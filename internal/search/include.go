@@ -0,0 +1,83 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+
+	"go-fhir-server/internal/fhir"
+)
+
+// Include is one _include or _revinclude value, e.g.
+// "Patient:general-practitioner" or "Observation:subject:Patient".
+type Include struct {
+	// Reverse marks _revinclude: pull in SourceType resources that point at
+	// the results, instead of the resources the results point at.
+	Reverse bool
+	// Iterate (the :iterate modifier) also applies the include to resources
+	// that were themselves included.
+	Iterate    bool
+	SourceType string
+	// Param is the reference search parameter on SourceType, or "*" for all.
+	Param string
+	// TargetType optionally restricts the referenced type.
+	TargetType string
+}
+
+func parseInclude(rt fhir.ResourceType, name, modifier, v string) (Include, error) {
+	if modifier != "" && modifier != "iterate" {
+		return Include{}, fmt.Errorf("unsupported modifier %q on %s", modifier, name)
+	}
+	parts := strings.Split(v, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Include{}, fmt.Errorf("%s must look like Type:parameter[:targetType], got %q", name, v)
+	}
+
+	inc := Include{
+		Reverse:    name == "_revinclude",
+		Iterate:    modifier == "iterate",
+		SourceType: parts[0],
+		Param:      parts[1],
+	}
+	if len(parts) == 3 {
+		inc.TargetType = parts[2]
+	}
+
+	// Without :iterate an include only applies to the matches themselves.
+	if !inc.Iterate {
+		if !inc.Reverse && inc.SourceType != rt.Name {
+			return Include{}, fmt.Errorf("_include source type must be %s, got %s", rt.Name, inc.SourceType)
+		}
+		if inc.Reverse && inc.TargetType != "" && inc.TargetType != rt.Name {
+			return Include{}, fmt.Errorf("_revinclude target type must be %s, got %s", rt.Name, inc.TargetType)
+		}
+	}
+	return inc, nil
+}
+
+// Params resolves the include against the registry into the reference
+// parameters it follows.
+func (inc Include) Params(registry *fhir.Registry) ([]fhir.SearchParam, error) {
+	rt, ok := registry.Lookup(inc.SourceType)
+	if !ok {
+		return nil, fmt.Errorf("unsupported resource type in include: %s", inc.SourceType)
+	}
+
+	var out []fhir.SearchParam
+	for _, p := range rt.SearchParams {
+		if p.Type == fhir.SearchReference && (inc.Param == "*" || p.Name == inc.Param) {
+			out = append(out, p)
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%s has no reference search parameter %q", inc.SourceType, inc.Param)
+	}
+	return out, nil
+}
+
+// Follows reports whether ref ("Type/id") is a reference this include keeps.
+func (inc Include) Follows(ref string) bool {
+	if inc.TargetType == "" {
+		return true
+	}
+	return strings.HasPrefix(ref, inc.TargetType+"/")
+}
@@ -0,0 +1,158 @@
+package search
+
+import (
+	"strconv"
+	"time"
+
+	"go-fhir-server/internal/fhir"
+)
+
+// The functions below let a store keep secondary indexes that agree with the
+// matching rules in this package. A store indexes every resource under
+// Terms (token, string and reference parameters) or DateRanges (date
+// parameters), and looks a criterion up with Criterion.Terms or
+// Criterion.MatchesRange. Index lookups may return extra candidates but never
+// miss one, so stores still confirm results with Query.Matches.
+
+// maxPrefixTerm caps how many leading characters of a string are indexed.
+// Longer search values are looked up by their first maxPrefixTerm characters.
+const maxPrefixTerm = 24
+
+// Terms returns the index terms of resource for a token, string or reference
+// parameter. Date parameters have no terms; see DateRanges.
+func Terms(param fhir.SearchParam, resource map[string]any) []string {
+	var out []string
+	for _, path := range param.Paths {
+		for _, el := range Values(resource, path) {
+			switch param.Type {
+			case fhir.SearchToken:
+				out = appendTokenTerms(out, el)
+			case fhir.SearchString:
+				out = appendStringTerms(out, el)
+			case fhir.SearchReference:
+				if rt, id, ok := elementReference(el, param); ok {
+					out = append(out, rt+"/"+id, "id:"+id)
+				}
+			}
+		}
+	}
+	return out
+}
+
+// DateRanges returns the ranges covered by resource for a date parameter.
+func DateRanges(param fhir.SearchParam, resource map[string]any) []DateRange {
+	var out []DateRange
+	for _, path := range param.Paths {
+		for _, el := range Values(resource, path) {
+			if r, ok := elementRange(el); ok {
+				out = append(out, r)
+			}
+		}
+	}
+	return out
+}
+
+// Terms returns the index terms any matching resource is indexed under. ok is
+// false when the criterion can't be answered from terms, e.g. :contains or a
+// date parameter.
+func (c Criterion) Terms() (terms []string, ok bool) {
+	for _, v := range c.Values {
+		switch c.Param.Type {
+		case fhir.SearchToken:
+			if c.Modifier != "" {
+				return nil, false
+			}
+			terms = append(terms, v)
+		case fhir.SearchString:
+			if c.Modifier == "contains" {
+				return nil, false
+			}
+			n := prefixTerm(normalizeString(v))
+			if n == "" {
+				return nil, false
+			}
+			terms = append(terms, "p:"+n)
+		case fhir.SearchReference:
+			rt, id, typed := ParseReference(v)
+			switch {
+			case typed:
+				terms = append(terms, rt+"/"+id)
+			case c.Modifier != "":
+				terms = append(terms, c.Modifier+"/"+v)
+			default:
+				terms = append(terms, "id:"+v)
+			}
+		default:
+			return nil, false
+		}
+	}
+	return terms, true
+}
+
+// MatchesRange reports whether a resource covering r satisfies a date
+// criterion.
+func (c Criterion) MatchesRange(r DateRange) bool {
+	now := time.Now()
+	for _, v := range c.Values {
+		if matchDateRange(r, v, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// appendTokenTerms adds the token search values that match element, in the
+// forms "code", "system|code", "system|" and "|code" (no system).
+func appendTokenTerms(out []string, element any) []string {
+	switch el := element.(type) {
+	case string:
+		return append(out, el)
+	case bool:
+		return append(out, strconv.FormatBool(el))
+	case map[string]any:
+		if codings, ok := el["coding"].([]any); ok {
+			for _, c := range codings {
+				out = appendTokenTerms(out, c)
+			}
+			return out
+		}
+		system, _ := el["system"].(string)
+		code, ok := el["code"].(string)
+		if !ok {
+			code, _ = el["value"].(string)
+		}
+		out = append(out, code)
+		if system == "" {
+			return append(out, "|"+code)
+		}
+		return append(out, system+"|"+code, system+"|")
+	}
+	return out
+}
+
+// appendStringTerms adds every normalized prefix of element's strings, so a
+// default (starts-with) string search is a single term lookup.
+func appendStringTerms(out []string, element any) []string {
+	switch el := element.(type) {
+	case string:
+		runes := []rune(prefixTerm(normalizeString(el)))
+		for i := 1; i <= len(runes); i++ {
+			out = append(out, "p:"+string(runes[:i]))
+		}
+	case map[string]any:
+		for _, part := range stringParts {
+			for _, v := range appendFlat(nil, el[part]) {
+				out = appendStringTerms(out, v)
+			}
+		}
+	}
+	return out
+}
+
+func prefixTerm(s string) string {
+	runes := []rune(s)
+	if len(runes) > maxPrefixTerm {
+		return string(runes[:maxPrefixTerm])
+	}
+	return s
+}
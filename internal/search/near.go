@@ -0,0 +1,84 @@
+package search
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// earthRadiusKm is the mean radius used for great-circle distances.
+const earthRadiusKm = 6371.0088
+
+// defaultNearKm is how close "near" means when the search gives no distance.
+const defaultNearKm = 10
+
+// nearUnits converts the distance units accepted by near into kilometres.
+var nearUnits = map[string]float64{"km": 1, "m": 0.001, "[mi_i]": 1.609344, "mi": 1.609344}
+
+// nearValue is a parsed near search value,
+// "latitude|longitude[|distance[|units]]".
+type nearValue struct {
+	lat, lng, km float64
+}
+
+func parseNearValue(v string) (nearValue, error) {
+	parts := strings.Split(v, "|")
+	if len(parts) < 2 || len(parts) > 4 {
+		return nearValue{}, fmt.Errorf("invalid near %q: expected latitude|longitude|distance|units", v)
+	}
+	var nums [3]float64
+	for i := 0; i < len(parts) && i < 3; i++ {
+		n, err := strconv.ParseFloat(parts[i], 64)
+		if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+			return nearValue{}, fmt.Errorf("invalid near %q", v)
+		}
+		nums[i] = n
+	}
+	near := nearValue{lat: nums[0], lng: nums[1], km: defaultNearKm}
+	if math.Abs(near.lat) > 90 || math.Abs(near.lng) > 180 {
+		return nearValue{}, fmt.Errorf("invalid near %q: coordinates out of range", v)
+	}
+	if len(parts) >= 3 {
+		unit := 1.0
+		if len(parts) == 4 {
+			var ok bool
+			if unit, ok = nearUnits[parts[3]]; !ok {
+				return nearValue{}, fmt.Errorf("invalid near %q: unknown distance unit %q", v, parts[3])
+			}
+		}
+		if nums[2] < 0 {
+			return nearValue{}, fmt.Errorf("invalid near %q: negative distance", v)
+		}
+		near.km = nums[2] * unit
+	}
+	return near, nil
+}
+
+// matchNear reports whether a Location.position lies within the distance of
+// a near value, measured along the earth's surface.
+func matchNear(element any, value string) bool {
+	near, err := parseNearValue(value)
+	if err != nil {
+		return false
+	}
+	pos, ok := element.(map[string]any)
+	if !ok {
+		return false
+	}
+	lat, okLat := pos["latitude"].(float64)
+	lng, okLng := pos["longitude"].(float64)
+	if !okLat || !okLng {
+		return false
+	}
+	return haversineKm(near.lat, near.lng, lat, lng) <= near.km
+}
+
+func haversineKm(lat1, lng1, lat2, lng2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLng := (lng2 - lng1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
@@ -0,0 +1,97 @@
+package search
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+// ErrInvalidCursor is returned for a _cursor token this server didn't issue.
+var ErrInvalidCursor = errors.New("invalid _cursor")
+
+// Page is one page of search results together with the cursors that select
+// its neighbours. Next and Prev are empty at either end of the result set.
+type Page struct {
+	Resources []map[string]any
+	Next      string
+	Prev      string
+}
+
+// cursor is the decoded form of a _cursor token. It holds the sort key of the
+// resource the page boundary sits on rather than an offset, so paging stays
+// consistent while resources are created or deleted in between requests.
+type cursor struct {
+	Key    []any `json:"k"`
+	Before bool  `json:"b,omitempty"`
+}
+
+func (c cursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(token string) (cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil || len(c.Key) == 0 {
+		return cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// Page orders matches and returns the count resources selected by q.Cursor:
+// those after its key for a next link, or the ones just before it for a
+// previous link.
+func (q Query) Page(matches []map[string]any, count int) (Page, error) {
+	type keyed struct {
+		resource map[string]any
+		key      []any
+	}
+	sorted := make([]keyed, len(matches))
+	for i, m := range matches {
+		sorted[i] = keyed{m, q.sortKey(m)}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return q.compareKeys(sorted[i].key, sorted[j].key) < 0
+	})
+
+	start, end := 0, len(sorted)
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return Page{}, err
+		}
+		if len(c.Key) != len(q.sortKeys())+1 {
+			// Issued for a different _sort.
+			return Page{}, ErrInvalidCursor
+		}
+		if c.Before {
+			// The page ends just before the cursor key.
+			end = sort.Search(len(sorted), func(i int) bool {
+				return q.compareKeys(sorted[i].key, c.Key) >= 0
+			})
+			start = max(0, end-count)
+		} else {
+			start = sort.Search(len(sorted), func(i int) bool {
+				return q.compareKeys(sorted[i].key, c.Key) > 0
+			})
+		}
+	}
+	end = min(end, start+count)
+
+	page := Page{Resources: make([]map[string]any, 0, end-start)}
+	for _, k := range sorted[start:end] {
+		page.Resources = append(page.Resources, k.resource)
+	}
+	if end > start && end < len(sorted) {
+		page.Next = cursor{Key: sorted[end-1].key}.encode()
+	}
+	if end > start && start > 0 {
+		page.Prev = cursor{Key: sorted[start].key, Before: true}.encode()
+	}
+	return page, nil
+}
@@ -0,0 +1,36 @@
+package search
+
+import "strings"
+
+// Values walks a dotted element path through a decoded resource and returns
+// every value found. Arrays are flattened at each step, so "name.given"
+// yields all given names across all HumanNames.
+func Values(resource map[string]any, path string) []any {
+	current := []any{resource}
+	for _, step := range strings.Split(path, ".") {
+		var next []any
+		for _, node := range current {
+			m, ok := node.(map[string]any)
+			if !ok {
+				continue
+			}
+			next = appendFlat(next, m[step])
+		}
+		current = next
+	}
+	return current
+}
+
+func appendFlat(out []any, v any) []any {
+	switch t := v.(type) {
+	case nil:
+		return out
+	case []any:
+		for _, item := range t {
+			out = appendFlat(out, item)
+		}
+		return out
+	default:
+		return append(out, v)
+	}
+}
@@ -0,0 +1,178 @@
+package search
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ucumSystem is the code system of UCUM units, the only one whose codes are
+// converted between; other systems must match code for code.
+const ucumSystem = "http://unitsofmeasure.org"
+
+// ucumUnits maps common UCUM codes onto a base unit of the same dimension,
+// so that a search in g finds values recorded in mg. Units not listed, such
+// as Cel and [degF] whose conversion isn't a plain factor, only match
+// themselves.
+var ucumUnits = map[string]struct {
+	base   string
+	factor float64
+}{
+	"kg": {"g", 1e3}, "g": {"g", 1}, "mg": {"g", 1e-3}, "ug": {"g", 1e-6}, "ng": {"g", 1e-9},
+	"[lb_av]": {"g", 453.59237}, "[oz_av]": {"g", 28.349523125},
+	"km": {"m", 1e3}, "m": {"m", 1}, "cm": {"m", 1e-2}, "mm": {"m", 1e-3},
+	"[in_i]": {"m", 0.0254}, "[ft_i]": {"m", 0.3048},
+	"L": {"L", 1}, "dL": {"L", 1e-1}, "mL": {"L", 1e-3}, "uL": {"L", 1e-6},
+	"h": {"s", 3600}, "min": {"s", 60}, "s": {"s", 1}, "ms": {"s", 1e-3},
+	"kPa": {"Pa", 1e3}, "Pa": {"Pa", 1}, "mm[Hg]": {"Pa", 133.322387415},
+	"mol/L": {"mol/L", 1}, "mmol/L": {"mol/L", 1e-3}, "umol/L": {"mol/L", 1e-6},
+	"g/L": {"g/L", 1}, "g/dL": {"g/L", 10}, "mg/dL": {"g/L", 1e-2}, "mg/L": {"g/L", 1e-3},
+	"/min": {"/s", 1.0 / 60}, "/s": {"/s", 1},
+}
+
+// quantityValue is a parsed quantity search value such as
+// "gt5.4|http://unitsofmeasure.org|mg". An empty system matches any system
+// and an empty code any unit.
+type quantityValue struct {
+	prefix       string
+	number       float64
+	system, code string
+	// low and high bound the implicit range of number at the precision it
+	// was written with: "5.4" covers [5.35, 5.45).
+	low, high float64
+}
+
+// parseQuantityValue parses "[prefix]number[|system|code]".
+func parseQuantityValue(v string) (quantityValue, error) {
+	q := quantityValue{prefix: "eq"}
+	for _, p := range datePrefixes {
+		if strings.HasPrefix(v, p) {
+			q.prefix, v = p, v[len(p):]
+			break
+		}
+	}
+
+	num, unit, hasUnit := strings.Cut(v, "|")
+	if hasUnit {
+		var ok bool
+		if q.system, q.code, ok = strings.Cut(unit, "|"); !ok {
+			return quantityValue{}, fmt.Errorf("invalid quantity %q: expected number|system|code", v)
+		}
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || math.IsInf(n, 0) || math.IsNaN(n) {
+		return quantityValue{}, fmt.Errorf("invalid quantity %q", v)
+	}
+	q.number = n
+	half := math.Pow10(-decimals(num)) / 2
+	q.low, q.high = n-half, n+half
+	return q, nil
+}
+
+// decimals is the number of significant decimal places in a number as
+// written, taking an exponent into account: "5.40" has 2, "1e2" has -2.
+func decimals(num string) int {
+	mantissa, exp, _ := strings.Cut(strings.ToLower(num), "e")
+	d := 0
+	if _, frac, ok := strings.Cut(mantissa, "."); ok {
+		d = len(frac)
+	}
+	if e, err := strconv.Atoi(exp); err == nil {
+		d -= e
+	}
+	return d
+}
+
+// matchQuantity compares a Quantity element against a quantity search value.
+// Units are compared as described on ucumUnits; when they differ the
+// element's value is converted into the search value's unit first.
+func matchQuantity(element any, value string) bool {
+	q, err := parseQuantityValue(value)
+	if err != nil {
+		return false
+	}
+	x, ok := q.convert(element)
+	if !ok {
+		return false
+	}
+
+	switch q.prefix {
+	case "eq":
+		return x >= q.low && x < q.high
+	case "ne":
+		return x < q.low || x >= q.high
+	case "gt", "sa":
+		return x > q.number
+	case "lt", "eb":
+		return x < q.number
+	case "ge":
+		return x >= q.number
+	case "le":
+		return x <= q.number
+	case "ap":
+		// As with dates, "approximately" is left to the server: within 10%.
+		return math.Abs(x-q.number) <= math.Abs(q.number)/10
+	}
+	return false
+}
+
+// convert returns the value of a Quantity element in q's unit, or false when
+// the element is in a unit q doesn't match.
+func (q quantityValue) convert(element any) (float64, bool) {
+	el, ok := element.(map[string]any)
+	if !ok {
+		return 0, false
+	}
+	x, ok := el["value"].(float64)
+	if !ok {
+		return 0, false
+	}
+	system, _ := el["system"].(string)
+	code, _ := el["code"].(string)
+	unit, _ := el["unit"].(string)
+
+	if q.system != "" && q.system != system {
+		return 0, false
+	}
+	if q.code == "" || q.code == code || (q.system == "" && q.code == unit) {
+		return x, true
+	}
+	if system != ucumSystem {
+		return 0, false
+	}
+	from, okFrom := ucumUnits[code]
+	to, okTo := ucumUnits[q.code]
+	if !okFrom || !okTo || from.base != to.base {
+		return 0, false
+	}
+	return x * from.factor / to.factor, true
+}
+
+// quantitySortValue renders a Quantity so that string order is numeric
+// order, in the base unit where the unit is a known UCUM code.
+func quantitySortValue(element any) (string, bool) {
+	el, ok := element.(map[string]any)
+	if !ok {
+		return "", false
+	}
+	x, ok := el["value"].(float64)
+	if !ok {
+		return "", false
+	}
+	if system, _ := el["system"].(string); system == ucumSystem {
+		code, _ := el["code"].(string)
+		if u, ok := ucumUnits[code]; ok {
+			x *= u.factor
+		}
+	}
+	// Flip the sign bit of positive numbers and every bit of negative ones,
+	// which makes the IEEE 754 bit patterns sort like the numbers.
+	bits := math.Float64bits(x)
+	if bits>>63 == 0 {
+		bits |= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return fmt.Sprintf("%016x", bits), true
+}
@@ -0,0 +1,73 @@
+package search
+
+import (
+	"slices"
+	"strings"
+
+	"go-fhir-server/internal/fhir"
+)
+
+// ParseReference splits a literal reference such as "Patient/123",
+// "http://host/fhir/Patient/123/_history/2" or "Patient/123/_history/2" into
+// its type and id. Contained ("#x") and urn references report false.
+func ParseReference(ref string) (resourceType, id string, ok bool) {
+	if ref == "" || strings.HasPrefix(ref, "#") || strings.HasPrefix(ref, "urn:") {
+		return "", "", false
+	}
+	if before, _, found := strings.Cut(ref, "/_history/"); found {
+		ref = before
+	}
+	parts := strings.Split(strings.TrimSuffix(ref, "/"), "/")
+	if len(parts) < 2 {
+		return "", "", false
+	}
+	resourceType, id = parts[len(parts)-2], parts[len(parts)-1]
+	if resourceType == "" || id == "" || !fhir.IDRe.MatchString(id) {
+		return "", "", false
+	}
+	return resourceType, id, true
+}
+
+// References returns the "Type/id" of every literal reference the parameter
+// finds in resource, skipping types the parameter can't point to.
+func References(resource map[string]any, param fhir.SearchParam) []string {
+	var out []string
+	for _, path := range param.Paths {
+		for _, el := range Values(resource, path) {
+			if rt, id, ok := elementReference(el, param); ok {
+				out = append(out, rt+"/"+id)
+			}
+		}
+	}
+	return out
+}
+
+func elementReference(element any, param fhir.SearchParam) (string, string, bool) {
+	m, ok := element.(map[string]any)
+	if !ok {
+		return "", "", false
+	}
+	ref, _ := m["reference"].(string)
+	rt, id, ok := ParseReference(ref)
+	if !ok || (len(param.Targets) > 0 && !slices.Contains(param.Targets, rt)) {
+		return "", "", false
+	}
+	return rt, id, true
+}
+
+// matchReference compares a reference search value ("Type/id", an absolute
+// URL or a bare id) against a Reference element. A type modifier, as in
+// subject:Patient=123, restricts the target type.
+func matchReference(element any, value string, modifier string, param fhir.SearchParam) bool {
+	rt, id, ok := elementReference(element, param)
+	if !ok {
+		return false
+	}
+	if modifier != "" && modifier != rt {
+		return false
+	}
+	if wantType, wantID, typed := ParseReference(value); typed {
+		return wantType == rt && wantID == id
+	}
+	return value == id
+}
@@ -0,0 +1,295 @@
+// Package search parses FHIR search query strings and evaluates them against
+// resources. It knows nothing about storage; callers feed it candidates.
+package search
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-fhir-server/internal/fhir"
+)
+
+// commonParams apply to every resource type.
+var commonParams = []fhir.SearchParam{
+	{Name: "_id", Type: fhir.SearchToken, Paths: []string{"id"}},
+	lastUpdatedParam,
+}
+
+// CommonParams returns the search parameters every resource type supports.
+func CommonParams() []fhir.SearchParam {
+	return slices.Clone(commonParams)
+}
+
+var lastUpdatedParam = fhir.SearchParam{Name: "_lastUpdated", Type: fhir.SearchDate, Paths: []string{"meta.lastUpdated"}}
+
+// Criterion is one search parameter from the query string. Values are ORed.
+type Criterion struct {
+	Param    fhir.SearchParam
+	Modifier string
+	Values   []string
+}
+
+// Query is a parsed search. Criteria are ANDed together.
+type Query struct {
+	ResourceType string
+	Criteria     []Criterion
+	// Count is the page size requested with _count, or -1 when absent.
+	Count int
+	// Cursor is the _cursor token from a previous page's next/previous link.
+	Cursor string
+	// Sort is the _sort order; empty means _lastUpdated, then id.
+	Sort []SortKey
+	// Includes are the _include and _revinclude requests, in query order.
+	Includes []Include
+}
+
+// Parse builds a Query for rt from URL query values, including the _count,
+// _cursor, _sort, _include and _revinclude result parameters; _format,
+// _summary and the other standard ones are accepted and ignored. Unknown
+// parameters and modifiers are rejected so that conditional interactions
+// never silently ignore part of their criteria. Chained and _has parameters
+// need ParseChained.
+func Parse(rt fhir.ResourceType, values url.Values) (Query, error) {
+	return parse(rt, values, nil)
+}
+
+// ParseChained is Parse plus chained (subject:Patient.name=x), reverse
+// chained (_has:Observation:subject:code=x) and hierarchy (partof:below=x)
+// parameters, which are evaluated right away through resolver and become
+// plain reference or _id criteria.
+func ParseChained(rt fhir.ResourceType, values url.Values, resolver Resolver) (Query, error) {
+	return parse(rt, values, resolver)
+}
+
+func parse(rt fhir.ResourceType, values url.Values, resolver Resolver) (Query, error) {
+	q := Query{ResourceType: rt.Name, Count: -1}
+
+	// Sort for deterministic error messages and criterion order.
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, raw := range names {
+		switch raw {
+		case "_count":
+			n, err := strconv.Atoi(values.Get(raw))
+			if err != nil || n < 0 {
+				return Query{}, fmt.Errorf("_count must be a non-negative integer")
+			}
+			q.Count = n
+			continue
+		case "_cursor":
+			q.Cursor = values.Get(raw)
+			continue
+		case "_sort":
+			sort, err := parseSort(rt, values.Get(raw))
+			if err != nil {
+				return Query{}, err
+			}
+			q.Sort = sort
+			continue
+		case "_format", "_pretty", "_summary", "_elements", "_total", "_contained", "_containedType":
+			// Standard result and HTTP parameters the server accepts but
+			// doesn't act on: responses are always complete JSON resources.
+			continue
+		}
+
+		if strings.HasPrefix(raw, "_has:") || strings.Contains(raw, ".") {
+			if resolver == nil {
+				return Query{}, fmt.Errorf("chained parameter %q is not supported here", raw)
+			}
+			for _, v := range values[raw] {
+				if v == "" {
+					continue
+				}
+				c, err := resolveChain(rt, raw, v, resolver)
+				if err != nil {
+					return Query{}, err
+				}
+				q.Criteria = append(q.Criteria, c)
+			}
+			continue
+		}
+
+		name, modifier, _ := strings.Cut(raw, ":")
+		if name == "_include" || name == "_revinclude" {
+			for _, v := range values[raw] {
+				inc, err := parseInclude(rt, name, modifier, v)
+				if err != nil {
+					return Query{}, err
+				}
+				q.Includes = append(q.Includes, inc)
+			}
+			continue
+		}
+
+		param, ok := lookupParam(rt, name)
+		if !ok {
+			return Query{}, fmt.Errorf("unknown search parameter %q for %s", name, rt.Name)
+		}
+		if modifier == "below" && param.Type == fhir.SearchReference {
+			if resolver == nil {
+				return Query{}, fmt.Errorf("%s:below is not supported here", name)
+			}
+			for _, v := range values[raw] {
+				if v == "" {
+					continue
+				}
+				c, err := resolveBelow(rt, param, v, resolver)
+				if err != nil {
+					return Query{}, err
+				}
+				q.Criteria = append(q.Criteria, c)
+			}
+			continue
+		}
+		if modifier != "" && !supportsModifier(param, modifier) {
+			return Query{}, fmt.Errorf("unsupported modifier %q on %s", modifier, name)
+		}
+
+		// Repeated parameters AND; commas within one parameter OR.
+		for _, v := range values[raw] {
+			if v == "" {
+				continue
+			}
+			orValues := strings.Split(v, ",")
+			for _, ov := range orValues {
+				if err := validateValue(param, ov); err != nil {
+					return Query{}, fmt.Errorf("%s: %v", name, err)
+				}
+			}
+			q.Criteria = append(q.Criteria, Criterion{
+				Param:    param,
+				Modifier: modifier,
+				Values:   orValues,
+			})
+		}
+	}
+
+	return q, nil
+}
+
+// ParseString parses a raw query string such as "identifier=sys|123", as
+// found in If-None-Exist or conditional URLs. A leading "?" or "Type?" is ignored.
+func ParseString(rt fhir.ResourceType, raw string) (Query, error) {
+	if _, after, ok := strings.Cut(raw, "?"); ok {
+		raw = after
+	}
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		return Query{}, fmt.Errorf("invalid search query: %v", err)
+	}
+	return Parse(rt, values)
+}
+
+// Matches reports whether resource satisfies every criterion of q.
+func (q Query) Matches(resource map[string]any) bool {
+	for _, c := range q.Criteria {
+		if !c.matches(resource) {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter returns the resources that match q, preserving order.
+func (q Query) Filter(resources []map[string]any) []map[string]any {
+	out := make([]map[string]any, 0, len(resources))
+	for _, r := range resources {
+		if q.Matches(r) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func (c Criterion) matches(resource map[string]any) bool {
+	var elements []any
+	for _, path := range c.Param.Paths {
+		elements = append(elements, Values(resource, path)...)
+	}
+
+	for _, v := range c.Values {
+		for _, el := range elements {
+			if c.matchValue(el, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c Criterion) matchValue(element any, value string) bool {
+	switch c.Param.Type {
+	case fhir.SearchString:
+		return matchString(element, value, c.Modifier)
+	case fhir.SearchToken:
+		return matchToken(element, value)
+	case fhir.SearchDate:
+		return matchDate(element, value, time.Now())
+	case fhir.SearchReference:
+		return matchReference(element, value, c.Modifier, c.Param)
+	case fhir.SearchQuantity:
+		return matchQuantity(element, value)
+	case fhir.SearchComposite:
+		return matchComposite(element, value, c.Param)
+	case fhir.SearchSpecial:
+		return matchNear(element, value)
+	}
+	return false
+}
+
+// validateValue rejects a malformed date, quantity, composite or near value up
+// front, rather than letting it silently match nothing.
+func validateValue(param fhir.SearchParam, value string) error {
+	switch param.Type {
+	case fhir.SearchDate:
+		_, _, err := parseDateValue(value)
+		return err
+	case fhir.SearchQuantity:
+		_, err := parseQuantityValue(value)
+		return err
+	case fhir.SearchSpecial:
+		_, err := parseNearValue(value)
+		return err
+	case fhir.SearchComposite:
+		parts, err := splitComposite(param, value)
+		if err != nil {
+			return err
+		}
+		for i, part := range parts {
+			if err := validateValue(param.Components[i], part); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// supportsModifier reports whether modifier is implemented for param.
+func supportsModifier(param fhir.SearchParam, modifier string) bool {
+	switch param.Type {
+	case fhir.SearchString:
+		return modifier == "exact" || modifier == "contains"
+	case fhir.SearchReference:
+		// A type modifier such as subject:Patient.
+		return slices.Contains(param.Targets, modifier)
+	}
+	return false
+}
+
+func lookupParam(rt fhir.ResourceType, name string) (fhir.SearchParam, bool) {
+	for _, p := range commonParams {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return rt.SearchParam(name)
+}
@@ -0,0 +1,580 @@
+package search
+
+import (
+	"net/url"
+	"testing"
+
+	"go-fhir-server/internal/fhir"
+)
+
+func TestQuery_TokenMatching(t *testing.T) {
+	patient := map[string]any{
+		"resourceType": "Patient",
+		"id":           "p1",
+		"identifier": []any{
+			map[string]any{"system": "http://mrn", "value": "123"},
+			map[string]any{"value": "no-system"},
+		},
+	}
+
+	cases := []struct {
+		query string
+		want  bool
+	}{
+		{"identifier=http://mrn|123", true},
+		{"identifier=123", true},
+		{"identifier=http://mrn|", true},
+		{"identifier=|no-system", true},
+		{"identifier=|123", false},
+		{"identifier=http://other|123", false},
+		{"identifier=999,123", true},
+		{"identifier=http://mrn|123&identifier=999", false},
+		{"_id=p1", true},
+		{"_id=p2", false},
+	}
+
+	for _, tc := range cases {
+		q, err := ParseString(fhir.Patient(), tc.query)
+		if err != nil {
+			t.Fatalf("%s: parse err: %v", tc.query, err)
+		}
+		if got := q.Matches(patient); got != tc.want {
+			t.Fatalf("%s: expected match=%v, got %v", tc.query, tc.want, got)
+		}
+	}
+}
+
+func TestParse_RejectsUnknownParameters(t *testing.T) {
+	if _, err := Parse(fhir.Patient(), url.Values{"shoe-size": {"9"}}); err == nil {
+		t.Fatalf("expected error for unknown parameter")
+	}
+	if _, err := Parse(fhir.Patient(), url.Values{"identifier:fuzzy": {"9"}}); err == nil {
+		t.Fatalf("expected error for unsupported modifier")
+	}
+	q, err := ParseString(fhir.Patient(), "identifier=x&_format=json&_summary=true&_total=none")
+	if err != nil || len(q.Criteria) != 1 {
+		t.Fatalf("expected standard result parameters to be ignored, got %+v, %v", q.Criteria, err)
+	}
+	q, err = ParseString(fhir.Patient(), "Patient?identifier=a|b")
+	if err != nil {
+		t.Fatalf("expected Type? prefix to be accepted, got %v", err)
+	}
+	if len(q.Criteria) != 1 || q.Criteria[0].Values[0] != "a|b" {
+		t.Fatalf("unexpected criteria %+v", q.Criteria)
+	}
+}
+
+func TestQuery_StringMatching(t *testing.T) {
+	patient := map[string]any{
+		"resourceType": "Patient",
+		"name": []any{
+			map[string]any{"family": "Muñoz-García", "given": []any{"José", "Luis"}},
+		},
+	}
+
+	cases := []struct {
+		query string
+		want  bool
+	}{
+		{"family=munoz", true},
+		{"family=MUÑOZ-GARCIA", true},
+		{"family=garcia", false},
+		{"family:contains=garcia", true},
+		{"family:exact=Muñoz-García", true},
+		{"family:exact=munoz-garcia", false},
+		{"given=jose", true},
+		{"given=lu", true},
+		{"name=luis", true},
+		{"name=smith,jos", true},
+		{"name=smith", false},
+	}
+
+	for _, tc := range cases {
+		q, err := ParseString(fhir.Patient(), tc.query)
+		if err != nil {
+			t.Fatalf("%s: parse err: %v", tc.query, err)
+		}
+		if got := q.Matches(patient); got != tc.want {
+			t.Fatalf("%s: expected match=%v, got %v", tc.query, tc.want, got)
+		}
+	}
+}
+
+func TestQuery_DateMatching(t *testing.T) {
+	day := map[string]any{"resourceType": "Patient", "birthDate": "1980-06-15"}
+	year := map[string]any{"resourceType": "Patient", "birthDate": "1980"}
+
+	cases := []struct {
+		query string
+		res   map[string]any
+		want  bool
+	}{
+		{"birthdate=1980-06-15", day, true},
+		{"birthdate=1980-06", day, true},
+		{"birthdate=1980", day, true},
+		{"birthdate=1980-06-16", day, false},
+		{"birthdate=ne1980-06-16", day, true},
+		{"birthdate=gt1980-06-14", day, true},
+		{"birthdate=gt1980-06-15", day, false},
+		{"birthdate=ge1980-06-15", day, true},
+		{"birthdate=lt1980-07", day, true},
+		{"birthdate=le1980-06-14", day, false},
+		{"birthdate=sa1980-05", day, true},
+		{"birthdate=eb1980-06-15", day, false},
+		{"birthdate=eb1981", day, true},
+		{"birthdate=ap1980-06-10", day, true},
+		// A year-precision birthDate is not contained in a single day, but
+		// its range does extend past it.
+		{"birthdate=1980-06-15", year, false},
+		{"birthdate=1980", year, true},
+		{"birthdate=gt1980-06-15", year, true},
+		{"birthdate=lt1980-06-15", year, true},
+		{"birthdate=ge1979&birthdate=le1980", year, true},
+	}
+
+	for _, tc := range cases {
+		q, err := ParseString(fhir.Patient(), tc.query)
+		if err != nil {
+			t.Fatalf("%s: parse err: %v", tc.query, err)
+		}
+		if got := q.Matches(tc.res); got != tc.want {
+			t.Fatalf("%s on %v: expected match=%v, got %v", tc.query, tc.res["birthDate"], tc.want, got)
+		}
+	}
+
+	if _, err := ParseString(fhir.Patient(), "birthdate=last-tuesday"); err == nil {
+		t.Fatalf("expected malformed date to be rejected")
+	}
+}
+
+func TestQuery_QuantityMatching(t *testing.T) {
+	obs := map[string]any{
+		"resourceType":  "Observation",
+		"valueQuantity": map[string]any{"value": 5.4, "unit": "mg", "system": "http://unitsofmeasure.org", "code": "mg"},
+	}
+
+	cases := []struct {
+		query string
+		want  bool
+	}{
+		{"value-quantity=5.4", true},
+		{"value-quantity=5", true}, // 5 covers [4.5, 5.5)
+		{"value-quantity=5.41", false},
+		{"value-quantity=ne5.4", false},
+		{"value-quantity=gt5", true},
+		{"value-quantity=gt5.4", false},
+		{"value-quantity=ge5.4", true},
+		{"value-quantity=lt6", true},
+		{"value-quantity=le5.3", false},
+		{"value-quantity=ap5", true},
+		{"value-quantity=ap7", false},
+		{"value-quantity=5.4|http://unitsofmeasure.org|mg", true},
+		{"value-quantity=5.4||mg", true},
+		{"value-quantity=5.4|http://other|mg", false},
+		// Other UCUM units of the same dimension are converted.
+		{"value-quantity=0.0054|http://unitsofmeasure.org|g", true},
+		{"value-quantity=5400||ug", true},
+		{"value-quantity=gt5|http://unitsofmeasure.org|g", false},
+		{"value-quantity=lt1|http://unitsofmeasure.org|g", true},
+		{"value-quantity=5.4|http://unitsofmeasure.org|mL", false},
+	}
+
+	for _, tc := range cases {
+		q, err := ParseString(fhir.Observation(), tc.query)
+		if err != nil {
+			t.Fatalf("%s: parse err: %v", tc.query, err)
+		}
+		if got := q.Matches(obs); got != tc.want {
+			t.Fatalf("%s: expected match=%v, got %v", tc.query, tc.want, got)
+		}
+	}
+
+	for _, bad := range []string{"value-quantity=high", "value-quantity=gt5|mg", "value-quantity=NaN"} {
+		if _, err := ParseString(fhir.Observation(), bad); err == nil {
+			t.Fatalf("%s: expected malformed quantity to be rejected", bad)
+		}
+	}
+}
+
+func TestQuery_CompositeMatching(t *testing.T) {
+	component := func(code string, value float64) map[string]any {
+		return map[string]any{
+			"code":          map[string]any{"coding": []any{map[string]any{"system": "http://loinc.org", "code": code}}},
+			"valueQuantity": map[string]any{"value": value, "system": "http://unitsofmeasure.org", "code": "mm[Hg]"},
+		}
+	}
+	bp := map[string]any{
+		"resourceType": "Observation",
+		"component":    []any{component("8480-6", 150), component("8462-4", 95)},
+	}
+
+	cases := []struct {
+		query string
+		want  bool
+	}{
+		{"component-code-value-quantity=http://loinc.org|8480-6$gt140", true},
+		{"component-code-value-quantity=8462-4$gt90", true},
+		// Both parts must hold for the same component: the diastolic value
+		// is not above 140.
+		{"component-code-value-quantity=8462-4$gt140", false},
+		{"component-code-value-quantity=8480-6$lt140,8462-4$lt100", true},
+		{"component-code-value-quantity=8480-6$gt20|http://unitsofmeasure.org|kPa", false},
+		{"component-code-value-quantity=8480-6$gt19|http://unitsofmeasure.org|kPa", true},
+		{"component-code=8462-4", true},
+	}
+
+	for _, tc := range cases {
+		q, err := ParseString(fhir.Observation(), tc.query)
+		if err != nil {
+			t.Fatalf("%s: parse err: %v", tc.query, err)
+		}
+		if got := q.Matches(bp); got != tc.want {
+			t.Fatalf("%s: expected match=%v, got %v", tc.query, tc.want, got)
+		}
+	}
+
+	for _, bad := range []string{"component-code-value-quantity=8480-6", "component-code-value-quantity=8480-6$high"} {
+		if _, err := ParseString(fhir.Observation(), bad); err == nil {
+			t.Fatalf("%s: expected malformed composite to be rejected", bad)
+		}
+	}
+}
+
+func TestQuery_NearMatching(t *testing.T) {
+	// Boston, about 306 km from New York.
+	loc := map[string]any{
+		"resourceType": "Location",
+		"position":     map[string]any{"latitude": 42.3601, "longitude": -71.0589},
+	}
+
+	cases := []struct {
+		query string
+		want  bool
+	}{
+		{"near=42.36|-71.06", true},
+		{"near=40.7128|-74.0060", false},
+		{"near=40.7128|-74.0060|310|km", true},
+		{"near=40.7128|-74.0060|300|km", false},
+		{"near=40.7128|-74.0060|200|[mi_i]", true},
+		{"near=42.3601|-71.0589|0", true},
+		{"near=42.37|-71.0589|500|m", false},
+	}
+	for _, tc := range cases {
+		q, err := ParseString(fhir.Location(), tc.query)
+		if err != nil {
+			t.Fatalf("%s: parse err: %v", tc.query, err)
+		}
+		if got := q.Matches(loc); got != tc.want {
+			t.Fatalf("%s: expected match=%v, got %v", tc.query, tc.want, got)
+		}
+	}
+
+	q, _ := ParseString(fhir.Location(), "near=42.36|-71.06")
+	if q.Matches(map[string]any{"resourceType": "Location"}) {
+		t.Fatalf("expected a Location without a position not to match")
+	}
+
+	for _, bad := range []string{"near=42.36", "near=91|0", "near=42|-71|-1", "near=42|-71|5|furlong", "near=a|b", "_sort=near"} {
+		if _, err := ParseString(fhir.Location(), bad); err == nil {
+			t.Fatalf("%s: expected error", bad)
+		}
+	}
+}
+
+func TestQuery_GenderToken(t *testing.T) {
+	q, err := ParseString(fhir.Patient(), "gender=female")
+	if err != nil {
+		t.Fatalf("parse err: %v", err)
+	}
+	if !q.Matches(map[string]any{"gender": "female"}) || q.Matches(map[string]any{"gender": "male"}) {
+		t.Fatalf("gender token matched incorrectly")
+	}
+}
+
+func TestQuery_PageWithCursors(t *testing.T) {
+	var matches []map[string]any
+	for _, id := range []string{"e", "a", "d", "b", "c"} {
+		matches = append(matches, map[string]any{"id": id})
+	}
+	ids := func(p Page) string {
+		var s string
+		for _, r := range p.Resources {
+			s += r["id"].(string)
+		}
+		return s
+	}
+
+	q := Query{}
+	first, err := q.Page(matches, 2)
+	if err != nil {
+		t.Fatalf("page err: %v", err)
+	}
+	if ids(first) != "ab" || first.Prev != "" || first.Next == "" {
+		t.Fatalf("unexpected first page %q prev=%q next=%q", ids(first), first.Prev, first.Next)
+	}
+
+	// A resource inserted before the cursor doesn't shift the next page.
+	matches = append(matches, map[string]any{"id": "aa"})
+	second, err := Query{Cursor: first.Next}.Page(matches, 2)
+	if err != nil {
+		t.Fatalf("page err: %v", err)
+	}
+	if ids(second) != "cd" || second.Prev == "" || second.Next == "" {
+		t.Fatalf("unexpected second page %q", ids(second))
+	}
+
+	last, _ := Query{Cursor: second.Next}.Page(matches, 2)
+	if ids(last) != "e" || last.Next != "" {
+		t.Fatalf("unexpected last page %q next=%q", ids(last), last.Next)
+	}
+
+	// Going back reflects the current data: the two resources before "c".
+	back, _ := Query{Cursor: second.Prev}.Page(matches, 2)
+	if ids(back) != "aab" {
+		t.Fatalf("expected previous page [aa b], got %q", ids(back))
+	}
+
+	if _, err := (Query{Cursor: "not-a-cursor"}).Page(matches, 2); err == nil {
+		t.Fatalf("expected invalid cursor to be rejected")
+	}
+}
+
+func TestQuery_Sort(t *testing.T) {
+	patients := []map[string]any{
+		{"id": "1", "birthDate": "1990", "name": []any{map[string]any{"family": "Zed"}}},
+		{"id": "2", "birthDate": "1980-05-01", "name": []any{map[string]any{"family": "Ávila"}}},
+		{"id": "3", "name": []any{map[string]any{"family": "Brown"}}},
+		{"id": "4", "birthDate": "1980-05-01", "name": []any{map[string]any{"family": "Brown"}}},
+	}
+	order := func(query string) string {
+		t.Helper()
+		q, err := ParseString(fhir.Patient(), query)
+		if err != nil {
+			t.Fatalf("%s: parse err: %v", query, err)
+		}
+		page, err := q.Page(patients, 10)
+		if err != nil {
+			t.Fatalf("%s: page err: %v", query, err)
+		}
+		var s string
+		for _, r := range page.Resources {
+			s += r["id"].(string)
+		}
+		return s
+	}
+
+	cases := map[string]string{
+		"_sort=family":            "2341", // accents ignored, ties broken by id
+		"_sort=-family":           "1342",
+		"_sort=birthdate":         "2413", // missing values last
+		"_sort=-birthdate":        "1243",
+		"_sort=family,-birthdate": "2431",
+		"_sort=-birthdate,-_id":   "1423",
+		"":                        "1234", // no meta: every _lastUpdated ties, so by id
+	}
+	for query, want := range cases {
+		if got := order(query); got != want {
+			t.Fatalf("%q: expected order %s, got %s", query, want, got)
+		}
+	}
+
+	if _, err := ParseString(fhir.Patient(), "_sort=shoe-size"); err == nil {
+		t.Fatalf("expected unknown sort key to be rejected")
+	}
+}
+
+func TestQuery_ReferenceMatching(t *testing.T) {
+	obs := map[string]any{
+		"resourceType": "Observation",
+		"subject":      map[string]any{"reference": "http://example.org/fhir/Patient/p1/_history/3"},
+	}
+
+	cases := []struct {
+		query string
+		want  bool
+	}{
+		{"subject=Patient/p1", true},
+		{"subject=p1", true},
+		{"subject:Patient=p1", true},
+		{"subject:Group=p1", false},
+		{"subject=Patient/p2", false},
+		{"patient=p1", true},
+	}
+	for _, tc := range cases {
+		q, err := ParseString(fhir.Observation(), tc.query)
+		if err != nil {
+			t.Fatalf("%s: parse err: %v", tc.query, err)
+		}
+		if got := q.Matches(obs); got != tc.want {
+			t.Fatalf("%s: expected match=%v, got %v", tc.query, tc.want, got)
+		}
+	}
+
+	if _, err := ParseString(fhir.Observation(), "subject:Medication=1"); err == nil {
+		t.Fatalf("expected a type modifier outside the targets to be rejected")
+	}
+}
+
+func TestParse_Includes(t *testing.T) {
+	q, err := ParseString(fhir.Patient(), "_include=Patient:general-practitioner:Practitioner&_revinclude=Observation:subject&_include:iterate=Observation:performer")
+	if err != nil {
+		t.Fatalf("parse err: %v", err)
+	}
+	if len(q.Includes) != 3 {
+		t.Fatalf("expected 3 includes, got %+v", q.Includes)
+	}
+
+	for _, bad := range []string{
+		"_include=Observation:subject",          // wrong source type without :iterate
+		"_include=Patient",                      // missing parameter
+		"_revinclude=Observation:subject:Group", // target must be the searched type
+		"_include:recurse=Patient:organization",
+	} {
+		if _, err := ParseString(fhir.Patient(), bad); err == nil {
+			t.Fatalf("%s: expected error", bad)
+		}
+	}
+}
+
+// fakeResolver serves fixed resources for chained and _has tests.
+type fakeResolver struct {
+	registry  *fhir.Registry
+	resources map[string][]map[string]any
+}
+
+func (f fakeResolver) Lookup(name string) (fhir.ResourceType, bool) { return f.registry.Lookup(name) }
+
+func (f fakeResolver) Search(q Query) ([]map[string]any, error) {
+	return q.Filter(f.resources[q.ResourceType]), nil
+}
+
+func TestParseChained(t *testing.T) {
+	resolver := fakeResolver{
+		registry: fhir.DefaultRegistry(),
+		resources: map[string][]map[string]any{
+			"Organization": {
+				{"resourceType": "Organization", "id": "org1", "name": "Acme Clinic"},
+			},
+			"Patient": {
+				{"resourceType": "Patient", "id": "p1", "identifier": []any{map[string]any{"system": "sys", "value": "123"}}, "managingOrganization": map[string]any{"reference": "Organization/org1"}},
+				{"resourceType": "Patient", "id": "p2", "identifier": []any{map[string]any{"system": "sys", "value": "456"}}},
+			},
+			"Observation": {
+				{"resourceType": "Observation", "id": "o1", "code": map[string]any{"coding": []any{map[string]any{"system": "http://loinc.org", "code": "1234-5"}}}, "subject": map[string]any{"reference": "Patient/p2"}},
+			},
+		},
+	}
+
+	match := func(rt fhir.ResourceType, query string) string {
+		t.Helper()
+		values, _ := url.ParseQuery(query)
+		q, err := ParseChained(rt, values, resolver)
+		if err != nil {
+			t.Fatalf("%s: parse err: %v", query, err)
+		}
+		var ids string
+		for _, r := range q.Filter(resolver.resources[rt.Name]) {
+			ids += r["id"].(string)
+		}
+		return ids
+	}
+
+	obs := []map[string]any{
+		{"resourceType": "Observation", "id": "a", "subject": map[string]any{"reference": "Patient/p1"}},
+		{"resourceType": "Observation", "id": "b", "subject": map[string]any{"reference": "Patient/p2"}},
+	}
+	resolver.resources["Observation"] = append(resolver.resources["Observation"], obs...)
+
+	cases := []struct {
+		rt    fhir.ResourceType
+		query string
+		want  string
+	}{
+		{fhir.Observation(), "subject:Patient.identifier=sys|123", "a"},
+		{fhir.Observation(), "subject.identifier=sys|456", "o1b"},
+		{fhir.Observation(), "subject:Patient.organization.name=acme", "a"},
+		{fhir.Observation(), "subject:Patient.identifier=sys|999", ""},
+		{fhir.Patient(), "_has:Observation:subject:code=1234-5", "p2"},
+		{fhir.Patient(), "_has:Observation:subject:code=9999-9", ""},
+		{fhir.Organization(), "_has:Patient:organization:_has:Observation:subject:code=1234-5", ""},
+		{fhir.Organization(), "_has:Patient:organization:identifier=sys|123", "org1"},
+	}
+	for _, tc := range cases {
+		if got := match(tc.rt, tc.query); got != tc.want {
+			t.Fatalf("%s?%s: expected %q, got %q", tc.rt.Name, tc.query, tc.want, got)
+		}
+	}
+
+	for _, bad := range []string{
+		"subject:Medication.code=1",
+		"status.code=1",
+		"subject:Patient.shoe-size=9",
+		"subject:Patient._sort=name",
+	} {
+		values, _ := url.ParseQuery(bad)
+		if _, err := ParseChained(fhir.Observation(), values, resolver); err == nil {
+			t.Fatalf("%s: expected error", bad)
+		}
+	}
+	if _, err := Parse(fhir.Observation(), url.Values{"subject.name": {"x"}}); err == nil {
+		t.Fatalf("expected Parse to reject chained parameters")
+	}
+}
+
+func TestParseChained_Below(t *testing.T) {
+	partOf := func(id, parent string) map[string]any {
+		loc := map[string]any{"resourceType": "Location", "id": id}
+		if parent != "" {
+			loc["partOf"] = map[string]any{"reference": "Location/" + parent}
+		}
+		return loc
+	}
+	resolver := fakeResolver{
+		registry: fhir.DefaultRegistry(),
+		resources: map[string][]map[string]any{
+			// hospital > wing > ward > bed, plus a cycle between x and y.
+			"Location": {
+				partOf("hospital", ""),
+				partOf("wing", "hospital"),
+				partOf("ward", "wing"),
+				partOf("bed", "ward"),
+				partOf("clinic", ""),
+				partOf("x", "y"),
+				partOf("y", "x"),
+			},
+		},
+	}
+
+	cases := []struct {
+		query string
+		want  string
+	}{
+		{"partof:below=hospital", "wingwardbed"},
+		{"partof:below=Location/wing", "wardbed"},
+		{"partof:below=bed", ""},
+		{"partof:below=clinic,ward", "bed"},
+		{"partof:below=x", "y"},
+		{"partof=hospital", "wing"},
+	}
+	for _, tc := range cases {
+		values, _ := url.ParseQuery(tc.query)
+		q, err := ParseChained(fhir.Location(), values, resolver)
+		if err != nil {
+			t.Fatalf("%s: parse err: %v", tc.query, err)
+		}
+		var got string
+		for _, r := range q.Filter(resolver.resources["Location"]) {
+			got += r["id"].(string)
+		}
+		if got != tc.want {
+			t.Fatalf("%s: expected %q, got %q", tc.query, tc.want, got)
+		}
+	}
+
+	if _, err := ParseChained(fhir.Location(), url.Values{"organization:below": {"org1"}}, resolver); err == nil {
+		t.Fatalf("expected :below to be rejected on a reference to another type")
+	}
+	if _, err := Parse(fhir.Location(), url.Values{"partof:below": {"hospital"}}); err == nil {
+		t.Fatalf("expected Parse to reject :below")
+	}
+}
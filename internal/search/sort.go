@@ -0,0 +1,161 @@
+package search
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go-fhir-server/internal/fhir"
+)
+
+// SortKey is one entry of _sort: a search parameter and its direction.
+type SortKey struct {
+	Param      fhir.SearchParam
+	Descending bool
+}
+
+// defaultSort is used when _sort is absent: oldest change first. Every order
+// ends with the id, which keeps it total and stable.
+var defaultSort = []SortKey{{Param: lastUpdatedParam}}
+
+// parseSort parses a _sort value such as "family,-birthdate".
+func parseSort(rt fhir.ResourceType, v string) ([]SortKey, error) {
+	var keys []SortKey
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		desc := strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+		if name == "" {
+			return nil, fmt.Errorf("_sort has an empty key")
+		}
+		param, ok := lookupParam(rt, name)
+		if !ok {
+			return nil, fmt.Errorf("cannot _sort by unknown search parameter %q for %s", name, rt.Name)
+		}
+		if param.Type == fhir.SearchComposite || param.Type == fhir.SearchSpecial {
+			return nil, fmt.Errorf("cannot _sort by %s parameter %q", param.Type, name)
+		}
+		keys = append(keys, SortKey{Param: param, Descending: desc})
+	}
+	return keys, nil
+}
+
+func (q Query) sortKeys() []SortKey {
+	if len(q.Sort) == 0 {
+		return defaultSort
+	}
+	return q.Sort
+}
+
+// sortKey is the ordering key of a resource: one value per sort key, then the
+// id. Ids are unique within a type, which makes the order total and the
+// cursors unambiguous.
+func (q Query) sortKey(resource map[string]any) []any {
+	keys := q.sortKeys()
+	out := make([]any, 0, len(keys)+1)
+	for _, k := range keys {
+		out = append(out, sortValue(resource, k))
+	}
+	id, _ := resource["id"].(string)
+	return append(out, id)
+}
+
+// compareKeys orders two sort keys built by sortKey.
+func (q Query) compareKeys(a, b []any) int {
+	keys := q.sortKeys()
+	for i := 0; i < len(a) && i < len(b); i++ {
+		desc := i < len(keys) && keys[i].Descending
+		if c := compareValues(a[i], b[i], desc); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+// compareValues orders two key values. Missing values sort last in either
+// direction.
+func compareValues(a, b any, desc bool) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	as, _ := a.(string)
+	bs, _ := b.(string)
+	c := strings.Compare(as, bs)
+	if desc {
+		c = -c
+	}
+	return c
+}
+
+// sortValue reduces a resource's values for k to one comparable string, or
+// nil when it has none. Per the FHIR spec a repeating element sorts by its
+// lowest value ascending and its highest descending.
+func sortValue(resource map[string]any, k SortKey) any {
+	var best any
+	for _, path := range k.Param.Paths {
+		for _, el := range Values(resource, path) {
+			v, ok := elementSortValue(el, k.Param.Type)
+			if !ok {
+				continue
+			}
+			if best == nil || compareValues(v, best, k.Descending) < 0 {
+				best = v
+			}
+		}
+	}
+	return best
+}
+
+// sortableTime renders times so that string order is chronological.
+const sortableTime = "2006-01-02T15:04:05.000000000Z"
+
+func elementSortValue(element any, t fhir.SearchParamType) (string, bool) {
+	switch t {
+	case fhir.SearchString:
+		var parts []string
+		if m, ok := element.(map[string]any); ok {
+			for _, part := range stringParts {
+				for _, v := range appendFlat(nil, m[part]) {
+					if s, ok := v.(string); ok {
+						parts = append(parts, s)
+					}
+				}
+			}
+		} else if s, ok := element.(string); ok {
+			parts = append(parts, s)
+		}
+		if len(parts) == 0 {
+			return "", false
+		}
+		return normalizeString(strings.Join(parts, " ")), true
+	case fhir.SearchToken:
+		switch el := element.(type) {
+		case string:
+			return el, true
+		case bool:
+			return strconv.FormatBool(el), true
+		case map[string]any:
+			if codings, ok := el["coding"].([]any); ok && len(codings) > 0 {
+				return elementSortValue(codings[0], t)
+			}
+			if code, ok := el["code"].(string); ok {
+				return code, true
+			}
+			if value, ok := el["value"].(string); ok {
+				return value, true
+			}
+		}
+	case fhir.SearchDate:
+		if r, ok := elementRange(element); ok {
+			return r.Start.UTC().Format(sortableTime), true
+		}
+	case fhir.SearchQuantity:
+		return quantitySortValue(element)
+	}
+	return "", false
+}
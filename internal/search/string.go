@@ -0,0 +1,102 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// stringParts are the sub-elements searched when a string parameter points
+// at a complex type such as HumanName or Address.
+var stringParts = []string{"family", "given", "prefix", "suffix", "text", "line", "city", "district", "state", "postalCode", "country"}
+
+// matchString applies FHIR string search semantics. By default the element
+// must start with value, ignoring case and accents; ":contains" matches
+// anywhere and ":exact" requires an exact, case-sensitive match.
+func matchString(element any, value, modifier string) bool {
+	switch el := element.(type) {
+	case string:
+		switch modifier {
+		case "exact":
+			return el == value
+		case "contains":
+			return strings.Contains(normalizeString(el), normalizeString(value))
+		default:
+			return strings.HasPrefix(normalizeString(el), normalizeString(value))
+		}
+	case map[string]any:
+		for _, part := range stringParts {
+			for _, v := range appendFlat(nil, el[part]) {
+				if matchString(v, value, modifier) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// normalizeString lowercases s, strips diacritics from Latin letters and
+// collapses runs of whitespace, so "  José" and "jose" compare equal.
+func normalizeString(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	space := false
+	for _, r := range strings.TrimSpace(s) {
+		if unicode.IsSpace(r) {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		if unicode.Is(unicode.Mn, r) {
+			// Combining marks from already-decomposed input.
+			continue
+		}
+		r = unicode.ToLower(r)
+		if base, ok := foldAccent[r]; ok {
+			b.WriteString(base)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// foldAccent maps lowercase accented Latin letters to their base letters.
+// The standard library has no Unicode decomposition, and names in the Latin
+// ranges are what patient searches run into in practice.
+var foldAccent = func() map[rune]string {
+	groups := map[string]string{
+		"a":  "àáâãäåāăąǎǟǡǻ",
+		"ae": "æǣǽ",
+		"c":  "çćĉċč",
+		"d":  "ďđ",
+		"e":  "èéêëēĕėęě",
+		"g":  "ĝğġģǧ",
+		"h":  "ĥħ",
+		"i":  "ìíîïĩīĭįıǐ",
+		"j":  "ĵ",
+		"k":  "ķǩ",
+		"l":  "ĺļľŀł",
+		"n":  "ñńņňŉ",
+		"o":  "òóôõöøōŏőǒǿ",
+		"oe": "œ",
+		"r":  "ŕŗř",
+		"s":  "śŝşšș",
+		"ss": "ß",
+		"t":  "ţťŧț",
+		"u":  "ùúûüũūŭůűųǔǖǘǚǜ",
+		"w":  "ŵ",
+		"y":  "ýÿŷ",
+		"z":  "źżž",
+	}
+	m := make(map[rune]string)
+	for base, accented := range groups {
+		for _, r := range accented {
+			m[r] = base
+		}
+	}
+	return m
+}()
@@ -0,0 +1,52 @@
+package search
+
+import (
+	"strconv"
+	"strings"
+)
+
+// matchToken compares a token search value ("code", "system|code", "|code"
+// or "system|") against a code, boolean, Coding, Identifier, ContactPoint or
+// CodeableConcept element.
+func matchToken(element any, value string) bool {
+	system, code, hasSystem := strings.Cut(value, "|")
+	if !hasSystem {
+		code, system = system, ""
+	}
+
+	switch el := element.(type) {
+	case string:
+		return !hasSystem && el == code
+	case bool:
+		return !hasSystem && strconv.FormatBool(el) == code
+	case map[string]any:
+		if codings, ok := el["coding"].([]any); ok {
+			for _, c := range codings {
+				if matchToken(c, value) {
+					return true
+				}
+			}
+			return false
+		}
+
+		elSystem, _ := el["system"].(string)
+		elCode, ok := el["code"].(string)
+		if !ok {
+			// Identifier and ContactPoint carry the code in "value".
+			elCode, _ = el["value"].(string)
+		}
+
+		if hasSystem {
+			if system == "" {
+				// "|code" means the element must have no system.
+				return elSystem == "" && elCode == code
+			}
+			if elSystem != system {
+				return false
+			}
+			return code == "" || elCode == code
+		}
+		return elCode == code
+	}
+	return false
+}
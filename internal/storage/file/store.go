@@ -0,0 +1,535 @@
+// Package file is a storage.ResourceStore that survives restarts. State is
+// served from memory; every write is first appended to a checksummed
+// write-ahead log and fsynced, and the full state is snapshotted from time to
+// time so startup doesn't replay the whole log.
+//
+// A data directory holds one "snapshot" file plus log segments named
+// "wal-<seq>.log", where seq is the first commit the segment may contain.
+// Taking a snapshot starts a new segment and deletes the ones it covers.
+package file
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/search"
+	"go-fhir-server/internal/storage"
+	"go-fhir-server/internal/storage/memory"
+)
+
+// DefaultSnapshotEvery is how many commits are logged between snapshots.
+const DefaultSnapshotEvery = 1000
+
+const (
+	snapshotName = "snapshot"
+	segmentGlob  = "wal-*.log"
+)
+
+// ErrClosed is returned by writes after Close.
+var ErrClosed = errors.New("file store: closed")
+
+type Store struct {
+	dir string
+	mem *memory.Store
+
+	// mu guards the log. Writes reach it through the memory store's commit
+	// log, so it is always taken after the memory store's lock.
+	mu          sync.Mutex
+	wal         *os.File
+	walSize     int64
+	walStart    uint64
+	seq         uint64
+	snapshotSeq uint64
+	// broken is set when a failed append couldn't be cut back off the log;
+	// later writes would land after garbage, so they are refused.
+	broken error
+	// closing is set once Close starts; closed once the log is closed.
+	closing, closed bool
+
+	snapshotEvery int
+	snapshotMu    sync.Mutex
+	snapshotReq   chan struct{}
+	done          chan struct{}
+	wg            sync.WaitGroup
+	// snapshotErr is the last background snapshot failure, reported by Close.
+	snapshotErr error
+}
+
+// Option configures a Store.
+type Option func(*options)
+
+type options struct {
+	registry      *fhir.Registry
+	snapshotEvery int
+}
+
+// WithIndexes maintains secondary search indexes; see memory.WithIndexes.
+func WithIndexes(registry *fhir.Registry) Option {
+	return func(o *options) { o.registry = registry }
+}
+
+// WithSnapshotEvery takes a snapshot in the background after every n logged
+// commits. n <= 0 disables automatic snapshots.
+func WithSnapshotEvery(n int) Option {
+	return func(o *options) { o.snapshotEvery = n }
+}
+
+// Open loads the store in dir, creating the directory if needed. The last
+// snapshot is loaded and the log replayed on top of it; a write torn by a
+// crash is cut off the end of the log.
+func Open(dir string, opts ...Option) (*Store, error) {
+	o := options{snapshotEvery: DefaultSnapshotEvery}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	f := &Store{
+		dir:           dir,
+		snapshotEvery: o.snapshotEvery,
+		snapshotReq:   make(chan struct{}, 1),
+		done:          make(chan struct{}),
+	}
+	memOpts := []memory.Option{memory.WithCommitLog(f.append)}
+	if o.registry != nil {
+		memOpts = append(memOpts, memory.WithIndexes(o.registry))
+	}
+	f.mem = memory.NewStore(memOpts...)
+
+	if err := f.load(); err != nil {
+		if f.wal != nil {
+			f.wal.Close()
+		}
+		return nil, err
+	}
+
+	f.wg.Add(1)
+	go f.snapshotLoop()
+	return f, nil
+}
+
+func (f *Store) Put(resourceType, id string, resource map[string]any, expectedVersion int) (map[string]any, error) {
+	return f.mem.Put(resourceType, id, resource, expectedVersion)
+}
+
+func (f *Store) Get(resourceType, id string) (map[string]any, bool, error) {
+	return f.mem.Get(resourceType, id)
+}
+
+func (f *Store) Delete(resourceType, id string, expectedVersion int) (bool, error) {
+	return f.mem.Delete(resourceType, id, expectedVersion)
+}
+
+func (f *Store) List(resourceType string) ([]map[string]any, error) {
+	return f.mem.List(resourceType)
+}
+
+func (f *Store) Search(q search.Query) ([]map[string]any, error) {
+	return f.mem.Search(q)
+}
+
+func (f *Store) History(resourceType, id string) ([]storage.Version, error) {
+	return f.mem.History(resourceType, id)
+}
+
+func (f *Store) VRead(resourceType, id string, versionID int) (storage.Version, bool, error) {
+	return f.mem.VRead(resourceType, id, versionID)
+}
+
+// Transaction logs all of fn's writes as a single record, so after a crash
+// either all or none of them are replayed.
+func (f *Store) Transaction(fn func(tx storage.Tx) error) error {
+	return f.mem.Transaction(fn)
+}
+
+// Close takes a final snapshot and closes the log. Writes fail afterwards.
+func (f *Store) Close() error {
+	f.mu.Lock()
+	if f.closing {
+		f.mu.Unlock()
+		return nil
+	}
+	f.closing = true
+	f.mu.Unlock()
+
+	close(f.done)
+	f.wg.Wait()
+	err := f.Snapshot()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	if cerr := f.wal.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = f.snapshotErr
+	}
+	return err
+}
+
+// append is the memory store's commit log: it writes one record and fsyncs
+// it before the write is acknowledged.
+func (f *Store) append(versions []storage.Version) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrClosed
+	}
+	if f.broken != nil {
+		return f.broken
+	}
+
+	rec := logRecord{Seq: f.seq + 1, Versions: make([]versionRecord, len(versions))}
+	for i, v := range versions {
+		rec.Versions[i] = toRecord(v)
+	}
+	frame, err := appendFrame(nil, rec)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.wal.Write(frame); err != nil {
+		return f.abortAppend(err)
+	}
+	if err := f.wal.Sync(); err != nil {
+		return f.abortAppend(err)
+	}
+	f.walSize += int64(len(frame))
+	f.seq = rec.Seq
+
+	if f.snapshotEvery > 0 && f.seq-f.snapshotSeq >= uint64(f.snapshotEvery) {
+		select {
+		case f.snapshotReq <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// abortAppend cuts a partly written record back off the log. Callers must
+// hold f.mu.
+func (f *Store) abortAppend(err error) error {
+	if terr := f.wal.Truncate(f.walSize); terr != nil {
+		f.broken = fmt.Errorf("file store: log unusable after failed write: %w", err)
+		return f.broken
+	}
+	if _, serr := f.wal.Seek(f.walSize, io.SeekStart); serr != nil {
+		f.broken = fmt.Errorf("file store: log unusable after failed write: %w", err)
+		return f.broken
+	}
+	return err
+}
+
+func (f *Store) snapshotLoop() {
+	defer f.wg.Done()
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-f.snapshotReq:
+			err := f.Snapshot()
+			f.mu.Lock()
+			f.snapshotErr = err
+			f.mu.Unlock()
+		}
+	}
+}
+
+// Snapshot writes the full state to disk and deletes the log segments it
+// covers. Writes are blocked only while the state is copied, not while it is
+// written out.
+func (f *Store) Snapshot() error {
+	f.snapshotMu.Lock()
+	defer f.snapshotMu.Unlock()
+
+	var (
+		versions []storage.Version
+		seq      uint64
+		skip     bool
+	)
+	// An empty transaction holds the memory store's write lock, so no commit
+	// can land between copying the state and starting the next segment.
+	err := f.mem.Transaction(func(tx storage.Tx) error {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.closed {
+			return ErrClosed
+		}
+		seq = f.seq
+		if seq == f.snapshotSeq {
+			skip = true
+			return nil
+		}
+
+		var err error
+		if versions, err = tx.History("", ""); err != nil {
+			return err
+		}
+		if f.walSize > 0 {
+			return f.openSegment(seq + 1)
+		}
+		return nil
+	})
+	if err != nil || skip {
+		return err
+	}
+
+	if err := f.writeSnapshot(seq, versions); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.snapshotSeq = seq
+	current := f.walStart
+	f.mu.Unlock()
+	return f.removeSegmentsBefore(current)
+}
+
+// writeSnapshot replaces the snapshot file atomically: the new one is
+// written and fsynced under a temporary name, then renamed over the old.
+func (f *Store) writeSnapshot(seq uint64, newestFirst []storage.Version) error {
+	tmp := filepath.Join(f.dir, snapshotName+".tmp")
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	buf, err := appendFrame(nil, snapshotHeader{Seq: seq, Count: len(newestFirst)})
+	if err != nil {
+		out.Close()
+		return err
+	}
+	for i := len(newestFirst) - 1; i >= 0; i-- {
+		if buf, err = appendFrame(buf, toRecord(newestFirst[i])); err != nil {
+			out.Close()
+			return err
+		}
+		if len(buf) >= 1<<20 {
+			if _, err := out.Write(buf); err != nil {
+				out.Close()
+				return err
+			}
+			buf = buf[:0]
+		}
+	}
+	if _, err := out.Write(buf); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(f.dir, snapshotName)); err != nil {
+		return err
+	}
+	return syncDir(f.dir)
+}
+
+// load restores the snapshot, replays the log and leaves the last segment
+// open for appending.
+func (f *Store) load() error {
+	if err := f.loadSnapshot(); err != nil {
+		return err
+	}
+
+	segments, err := f.segments()
+	if err != nil {
+		return err
+	}
+	for i, seg := range segments {
+		last := i == len(segments)-1
+		if err := f.replaySegment(seg, last); err != nil {
+			return err
+		}
+	}
+
+	if len(segments) == 0 {
+		return f.openSegment(f.seq + 1)
+	}
+	seg := segments[len(segments)-1]
+	wal, err := os.OpenFile(seg.path, os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	size, err := wal.Seek(0, io.SeekEnd)
+	if err != nil {
+		wal.Close()
+		return err
+	}
+	f.wal, f.walSize, f.walStart = wal, size, seg.start
+	return nil
+}
+
+func (f *Store) loadSnapshot() error {
+	in, err := os.Open(filepath.Join(f.dir, snapshotName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	fr, err := newFrameReader(in)
+	if err != nil {
+		return err
+	}
+	// The snapshot is renamed into place only once complete, so unlike the
+	// log it must never be torn.
+	snapshotErr := func(err error) error {
+		if err == io.EOF || err == errTorn {
+			return fmt.Errorf("%w: snapshot truncated", ErrCorrupt)
+		}
+		return fmt.Errorf("snapshot: %w", err)
+	}
+
+	var header snapshotHeader
+	if err := fr.next(&header); err != nil {
+		return snapshotErr(err)
+	}
+	for i := 0; i < header.Count; i++ {
+		var rec versionRecord
+		if err := fr.next(&rec); err != nil {
+			return snapshotErr(err)
+		}
+		if err := f.mem.Restore(rec.version()); err != nil {
+			return fmt.Errorf("%w: snapshot: %v", ErrCorrupt, err)
+		}
+	}
+	f.seq, f.snapshotSeq = header.Seq, header.Seq
+	return nil
+}
+
+// replaySegment applies the records of one segment that the snapshot
+// doesn't already cover. A torn record at the end of the last segment is
+// the write that was in flight during a crash; it was never acknowledged, so
+// it is cut off.
+func (f *Store) replaySegment(seg segment, last bool) error {
+	in, err := os.Open(seg.path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	fr, err := newFrameReader(in)
+	if err != nil {
+		return err
+	}
+	for {
+		var rec logRecord
+		err := fr.next(&rec)
+		if err == io.EOF {
+			return nil
+		}
+		if err == errTorn && last {
+			return os.Truncate(seg.path, fr.off)
+		}
+		if err == errTorn {
+			return fmt.Errorf("%w: %s is truncated", ErrCorrupt, filepath.Base(seg.path))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(seg.path), err)
+		}
+
+		if rec.Seq <= f.seq {
+			continue
+		}
+		if rec.Seq != f.seq+1 {
+			return fmt.Errorf("%w: %s: commit %d follows %d", ErrCorrupt, filepath.Base(seg.path), rec.Seq, f.seq)
+		}
+		for _, v := range rec.Versions {
+			if err := f.mem.Restore(v.version()); err != nil {
+				return fmt.Errorf("%w: %s: %v", ErrCorrupt, filepath.Base(seg.path), err)
+			}
+		}
+		f.seq = rec.Seq
+	}
+}
+
+// openSegment starts a new log segment for commits from start on and makes
+// it the one appended to. Callers must hold f.mu or be loading.
+func (f *Store) openSegment(start uint64) error {
+	path := filepath.Join(f.dir, segmentName(start))
+	wal, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := syncDir(f.dir); err != nil {
+		wal.Close()
+		return err
+	}
+	if f.wal != nil {
+		f.wal.Close()
+	}
+	f.wal, f.walSize, f.walStart = wal, 0, start
+	return nil
+}
+
+func (f *Store) removeSegmentsBefore(start uint64) error {
+	segments, err := f.segments()
+	if err != nil {
+		return err
+	}
+	for _, seg := range segments {
+		if seg.start < start {
+			if err := os.Remove(seg.path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type segment struct {
+	path  string
+	start uint64
+}
+
+// segments lists the log segments in dir, oldest first.
+func (f *Store) segments() ([]segment, error) {
+	paths, err := filepath.Glob(filepath.Join(f.dir, segmentGlob))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]segment, 0, len(paths))
+	for _, p := range paths {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(p), "wal-"), ".log")
+		start, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		out = append(out, segment{path: p, start: start})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].start < out[j].start })
+	return out, nil
+}
+
+func segmentName(start uint64) string {
+	return fmt.Sprintf("wal-%020d.log", start)
+}
+
+// syncDir makes a created, renamed or removed file in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
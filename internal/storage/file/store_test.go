@@ -0,0 +1,285 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/storage"
+	"go-fhir-server/internal/storage/storagetest"
+)
+
+func openStore(t *testing.T, dir string, opts ...Option) *Store {
+	t.Helper()
+	s, err := Open(dir, opts...)
+	if err != nil {
+		t.Fatalf("open err: %v", err)
+	}
+	return s
+}
+
+// abandon simulates a crash: the store stops without a final snapshot.
+func abandon(t *testing.T, s *Store) {
+	t.Helper()
+	close(s.done)
+	s.wg.Wait()
+	s.mu.Lock()
+	s.closed = true
+	s.wal.Close()
+	s.mu.Unlock()
+}
+
+func patient(id, family string) map[string]any {
+	return map[string]any{"resourceType": "Patient", "id": id, "name": []any{map[string]any{"family": family}}}
+}
+
+// writeSample makes two versions of p1, creates and deletes p2, and commits
+// a transaction touching p3 and p4.
+func writeSample(t *testing.T, s storage.ResourceStore) {
+	t.Helper()
+	if _, err := s.Put("Patient", "p1", patient("p1", "Smith"), 0); err != nil {
+		t.Fatalf("put err: %v", err)
+	}
+	if _, err := s.Put("Patient", "p1", patient("p1", "Jones"), 1); err != nil {
+		t.Fatalf("put err: %v", err)
+	}
+	if _, err := s.Put("Patient", "p2", patient("p2", "Brown"), 0); err != nil {
+		t.Fatalf("put err: %v", err)
+	}
+	if _, err := s.Delete("Patient", "p2", storage.AnyVersion); err != nil {
+		t.Fatalf("delete err: %v", err)
+	}
+	err := s.Transaction(func(tx storage.Tx) error {
+		if _, err := tx.Put("Patient", "p3", patient("p3", "Green"), 0); err != nil {
+			return err
+		}
+		_, err := tx.Put("Patient", "p4", patient("p4", "White"), 0)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("transaction err: %v", err)
+	}
+}
+
+func assertSameState(t *testing.T, want, got storage.ResourceStore) {
+	t.Helper()
+	wantHist, _ := want.History("", "")
+	gotHist, _ := got.History("", "")
+	if len(gotHist) != len(wantHist) {
+		t.Fatalf("expected %d versions, got %d", len(wantHist), len(gotHist))
+	}
+	for i := range wantHist {
+		w, g := wantHist[i], gotHist[i]
+		if w.ResourceType != g.ResourceType || w.ID != g.ID || w.VersionID != g.VersionID ||
+			w.Deleted != g.Deleted || !w.LastUpdated.Equal(g.LastUpdated) || !reflect.DeepEqual(w.Resource, g.Resource) {
+			t.Fatalf("version %d differs:\nwant %+v\ngot  %+v", i, w, g)
+		}
+	}
+
+	wantList, _ := want.List("Patient")
+	gotList, _ := got.List("Patient")
+	if !reflect.DeepEqual(wantList, gotList) {
+		t.Fatalf("expected patients %v, got %v", wantList, gotList)
+	}
+}
+
+func TestStore_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.ResourceStore {
+		s := openStore(t, t.TempDir(), WithIndexes(fhir.DefaultRegistry()))
+		t.Cleanup(func() {
+			if err := s.Close(); err != nil {
+				t.Errorf("close err: %v", err)
+			}
+		})
+		return s
+	})
+}
+
+// TestStore_ConformanceWhileSnapshotting runs the suite with a snapshot
+// after every few commits, racing the background snapshots against writes.
+func TestStore_ConformanceWhileSnapshotting(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.ResourceStore {
+		s := openStore(t, t.TempDir(), WithSnapshotEvery(3))
+		t.Cleanup(func() {
+			if err := s.Close(); err != nil {
+				t.Errorf("close err: %v", err)
+			}
+		})
+		return s
+	})
+}
+
+func TestStore_ReopenRestoresState(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir)
+	writeSample(t, s)
+	if err := s.Close(); err != nil {
+		t.Fatalf("close err: %v", err)
+	}
+
+	again := openStore(t, dir)
+	defer again.Close()
+	assertSameState(t, s, again)
+
+	// Version numbering carries on, including across the delete.
+	stored, err := again.Put("Patient", "p2", patient("p2", "Black"), 0)
+	if err != nil {
+		t.Fatalf("put err: %v", err)
+	}
+	if v := stored["meta"].(map[string]any)["versionId"]; v != "3" {
+		t.Fatalf("expected versionId 3, got %v", v)
+	}
+}
+
+func TestStore_ReplaysLogAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir)
+	writeSample(t, s)
+	abandon(t, s)
+
+	if _, err := os.Stat(filepath.Join(dir, snapshotName)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected no snapshot, got %v", err)
+	}
+	again := openStore(t, dir)
+	defer again.Close()
+	assertSameState(t, s, again)
+}
+
+func TestStore_DiscardsTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir)
+	writeSample(t, s)
+	abandon(t, s)
+
+	// A crash in the middle of the next append leaves part of a frame.
+	segments, _ := s.segments()
+	last := segments[len(segments)-1].path
+	info, _ := os.Stat(last)
+	frame, _ := appendFrame(nil, logRecord{Seq: s.seq + 1, Versions: []versionRecord{{ResourceType: "Patient", ID: "lost", VersionID: 1}}})
+	for _, torn := range [][]byte{frame[:5], frame[:len(frame)-3], make([]byte, 64)} {
+		if err := os.Truncate(last, info.Size()); err != nil {
+			t.Fatal(err)
+		}
+		out, _ := os.OpenFile(last, os.O_APPEND|os.O_WRONLY, 0)
+		out.Write(torn)
+		out.Close()
+
+		again := openStore(t, dir)
+		assertSameState(t, s, again)
+		if _, ok, _ := again.Get("Patient", "lost"); ok {
+			t.Fatalf("expected torn write to be discarded")
+		}
+		if after, _ := os.Stat(last); after.Size() != info.Size() {
+			t.Fatalf("expected log cut back to %d bytes, got %d", info.Size(), after.Size())
+		}
+		abandon(t, again)
+	}
+
+	// Writes after recovery are appended cleanly.
+	again := openStore(t, dir)
+	if _, err := again.Put("Patient", "p5", patient("p5", "Grey"), 0); err != nil {
+		t.Fatalf("put err: %v", err)
+	}
+	abandon(t, again)
+	final := openStore(t, dir)
+	defer final.Close()
+	if _, ok, _ := final.Get("Patient", "p5"); !ok {
+		t.Fatalf("expected p5 after recovery")
+	}
+}
+
+func TestStore_RejectsCorruptLog(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir)
+	writeSample(t, s)
+	abandon(t, s)
+
+	segments, _ := s.segments()
+	path := segments[0].path
+	data, _ := os.ReadFile(path)
+	data[frameHeaderSize+2] ^= 0xff // inside the first record's payload
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(dir); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected ErrCorrupt, got %v", err)
+	}
+}
+
+func TestStore_SnapshotCompactsLog(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir, WithSnapshotEvery(0))
+	writeSample(t, s)
+	if err := s.Snapshot(); err != nil {
+		t.Fatalf("snapshot err: %v", err)
+	}
+
+	segments, _ := s.segments()
+	if len(segments) != 1 || segments[0].start != s.seq+1 {
+		t.Fatalf("expected only a fresh segment after the snapshot, got %v", segments)
+	}
+	// Commits after the snapshot come from the log.
+	if _, err := s.Put("Patient", "p1", patient("p1", "Taylor"), storage.AnyVersion); err != nil {
+		t.Fatalf("put err: %v", err)
+	}
+	abandon(t, s)
+
+	again := openStore(t, dir)
+	defer again.Close()
+	assertSameState(t, s, again)
+}
+
+func TestStore_SnapshotsInBackground(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir, WithSnapshotEvery(2))
+	writeSample(t, s)
+	if err := s.Close(); err != nil {
+		t.Fatalf("close err: %v", err)
+	}
+	segments, _ := s.segments()
+	if len(segments) != 1 {
+		t.Fatalf("expected old segments to be removed, got %v", segments)
+	}
+
+	again := openStore(t, dir)
+	defer again.Close()
+	assertSameState(t, s, again)
+}
+
+func TestStore_FailedTransactionIsNotLogged(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir)
+	err := s.Transaction(func(tx storage.Tx) error {
+		if _, err := tx.Put("Patient", "p1", patient("p1", "Smith"), 0); err != nil {
+			return err
+		}
+		return errors.New("abort")
+	})
+	if err == nil {
+		t.Fatalf("expected transaction error")
+	}
+	abandon(t, s)
+
+	again := openStore(t, dir)
+	defer again.Close()
+	if hist, _ := again.History("", ""); len(hist) != 0 {
+		t.Fatalf("expected empty history, got %v", hist)
+	}
+}
+
+func TestStore_WritesFailAfterClose(t *testing.T) {
+	s := openStore(t, t.TempDir())
+	if err := s.Close(); err != nil {
+		t.Fatalf("close err: %v", err)
+	}
+	if _, err := s.Put("Patient", "p1", patient("p1", "Smith"), 0); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	if _, ok, _ := s.Get("Patient", "p1"); ok {
+		t.Fatalf("expected the rejected write to be undone")
+	}
+}
@@ -0,0 +1,166 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+
+	"go-fhir-server/internal/storage"
+)
+
+// Both the log and snapshots are sequences of frames: a little-endian uint32
+// payload length, the CRC-32C of the payload, then the payload (JSON).
+const (
+	frameHeaderSize = 8
+	maxFrameSize    = 1 << 30
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrCorrupt is returned by Open when the log or snapshot fails its checksum
+// somewhere other than a torn final write.
+var ErrCorrupt = errors.New("file store: corrupt data")
+
+// errTorn marks a frame cut short by a crash mid-write.
+var errTorn = errors.New("torn write")
+
+// logRecord is one committed write: a Put, a Delete or a whole Transaction.
+type logRecord struct {
+	Seq      uint64          `json:"seq"`
+	Versions []versionRecord `json:"versions"`
+}
+
+// snapshotHeader is the first frame of a snapshot; Count versions follow.
+type snapshotHeader struct {
+	Seq   uint64 `json:"seq"`
+	Count int    `json:"count"`
+}
+
+type versionRecord struct {
+	ResourceType string         `json:"resourceType"`
+	ID           string         `json:"id"`
+	VersionID    int            `json:"versionId"`
+	LastUpdated  time.Time      `json:"lastUpdated"`
+	Deleted      bool           `json:"deleted,omitempty"`
+	Resource     map[string]any `json:"resource,omitempty"`
+}
+
+func toRecord(v storage.Version) versionRecord {
+	return versionRecord{
+		ResourceType: v.ResourceType,
+		ID:           v.ID,
+		VersionID:    v.VersionID,
+		LastUpdated:  v.LastUpdated,
+		Deleted:      v.Deleted,
+		Resource:     v.Resource,
+	}
+}
+
+func (r versionRecord) version() storage.Version {
+	return storage.Version{
+		ResourceType: r.ResourceType,
+		ID:           r.ID,
+		VersionID:    r.VersionID,
+		LastUpdated:  r.LastUpdated,
+		Deleted:      r.Deleted,
+		Resource:     r.Resource,
+	}
+}
+
+// appendFrame encodes v as one frame onto buf.
+func appendFrame(buf []byte, v any) ([]byte, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return buf, err
+	}
+	if len(payload) > maxFrameSize {
+		return buf, fmt.Errorf("record of %d bytes is too large", len(payload))
+	}
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(payload)))
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.Checksum(payload, crcTable))
+	return append(buf, payload...), nil
+}
+
+// frameReader reads frames from a file and tracks the offset of the end of
+// the last good one.
+type frameReader struct {
+	r    *bufio.Reader
+	size int64
+	off  int64
+}
+
+func newFrameReader(f *os.File) (*frameReader, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &frameReader{r: bufio.NewReader(f), size: info.Size()}, nil
+}
+
+// next decodes the next frame into v. It returns io.EOF at a clean end,
+// errTorn when the file ends inside a frame, and ErrCorrupt when a complete
+// frame fails its checksum.
+func (fr *frameReader) next(v any) error {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(fr.r, header[:]); err != nil {
+		if err == io.EOF {
+			return io.EOF
+		}
+		if err == io.ErrUnexpectedEOF {
+			return errTorn
+		}
+		return err
+	}
+	n := int64(binary.LittleEndian.Uint32(header[:4]))
+	sum := binary.LittleEndian.Uint32(header[4:])
+
+	end := fr.off + frameHeaderSize + n
+	if end > fr.size {
+		return errTorn
+	}
+	if n == 0 || n > maxFrameSize {
+		return fr.invalid(header[:])
+	}
+
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(fr.r, payload); err != nil {
+		return err
+	}
+	if crc32.Checksum(payload, crcTable) != sum {
+		if end == fr.size {
+			// The final frame was only partly written to disk.
+			return errTorn
+		}
+		return fmt.Errorf("%w: checksum mismatch at offset %d", ErrCorrupt, fr.off)
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return fmt.Errorf("%w: offset %d: %v", ErrCorrupt, fr.off, err)
+	}
+	fr.off = end
+	return nil
+}
+
+// invalid classifies a frame header that can't be right. Some file systems
+// leave zeros after a crash while extending a file; anything else is
+// corruption.
+func (fr *frameReader) invalid(header []byte) error {
+	rest, err := io.ReadAll(fr.r)
+	if err != nil {
+		return err
+	}
+	if isZero(header) && isZero(rest) {
+		return errTorn
+	}
+	return fmt.Errorf("%w: bad frame header at offset %d", ErrCorrupt, fr.off)
+}
+
+func isZero(b []byte) bool {
+	return len(bytes.Trim(b, "\x00")) == 0
+}
@@ -0,0 +1,114 @@
+package memory
+
+import (
+	"slices"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/search"
+)
+
+// typeIndex holds the secondary indexes of one resource type: one paramIndex
+// per search parameter, kept up to date on every write.
+type typeIndex struct {
+	params map[string]*paramIndex
+}
+
+// paramIndex maps the values of one search parameter back to resource ids.
+// Token, string and reference parameters use terms (see search.Terms); date
+// parameters keep each resource's ranges, which are compact enough to scan.
+type paramIndex struct {
+	param fhir.SearchParam
+	terms map[string]map[string]struct{}
+	dates map[string][]search.DateRange
+	// byID remembers what each resource was indexed under, for removal.
+	byID map[string][]string
+}
+
+func newTypeIndex(rt fhir.ResourceType) *typeIndex {
+	ti := &typeIndex{params: make(map[string]*paramIndex)}
+	for _, p := range append(search.CommonParams(), rt.SearchParams...) {
+		pi := &paramIndex{param: p, byID: make(map[string][]string)}
+		if p.Type == fhir.SearchDate {
+			pi.dates = make(map[string][]search.DateRange)
+		} else {
+			pi.terms = make(map[string]map[string]struct{})
+		}
+		ti.params[p.Name] = pi
+	}
+	return ti
+}
+
+func (ti *typeIndex) add(id string, resource map[string]any) {
+	for _, pi := range ti.params {
+		if pi.dates != nil {
+			if ranges := search.DateRanges(pi.param, resource); len(ranges) > 0 {
+				pi.dates[id] = ranges
+			}
+			continue
+		}
+
+		terms := search.Terms(pi.param, resource)
+		slices.Sort(terms)
+		terms = slices.Compact(terms)
+		for _, t := range terms {
+			ids, ok := pi.terms[t]
+			if !ok {
+				ids = make(map[string]struct{})
+				pi.terms[t] = ids
+			}
+			ids[id] = struct{}{}
+		}
+		if len(terms) > 0 {
+			pi.byID[id] = terms
+		}
+	}
+}
+
+func (ti *typeIndex) remove(id string) {
+	for _, pi := range ti.params {
+		if pi.dates != nil {
+			delete(pi.dates, id)
+			continue
+		}
+		for _, t := range pi.byID[id] {
+			delete(pi.terms[t], id)
+			if len(pi.terms[t]) == 0 {
+				delete(pi.terms, t)
+			}
+		}
+		delete(pi.byID, id)
+	}
+}
+
+// candidates returns the ids that may match c. ok is false when the index
+// can't narrow c down and every resource has to be checked.
+func (ti *typeIndex) candidates(c search.Criterion) (ids map[string]struct{}, ok bool) {
+	pi, found := ti.params[c.Param.Name]
+	if !found || pi.param.Type != c.Param.Type || !slices.Equal(pi.param.Paths, c.Param.Paths) {
+		return nil, false
+	}
+
+	ids = make(map[string]struct{})
+	if pi.dates != nil {
+		for id, ranges := range pi.dates {
+			for _, r := range ranges {
+				if c.MatchesRange(r) {
+					ids[id] = struct{}{}
+					break
+				}
+			}
+		}
+		return ids, true
+	}
+
+	terms, ok := c.Terms()
+	if !ok {
+		return nil, false
+	}
+	for _, t := range terms {
+		for id := range pi.terms[t] {
+			ids[id] = struct{}{}
+		}
+	}
+	return ids, true
+}
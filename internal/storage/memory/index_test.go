@@ -0,0 +1,177 @@
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/search"
+	"go-fhir-server/internal/storage"
+)
+
+var indexQueries = []string{
+	"identifier=http://mrn|17",
+	"identifier=17,18",
+	"identifier=http://mrn|",
+	"gender=female",
+	"family=smi",
+	"family:exact=Smith-3",
+	"family:contains=th-1",
+	"name=jo",
+	"birthdate=1970",
+	"birthdate=ge1990-06",
+	"birthdate=ne1975",
+	"general-practitioner=Practitioner/dr3",
+	"general-practitioner=dr3",
+	"organization:Organization=org1",
+	"_id=p7,p8",
+	"_lastUpdated=gt2000-01-01",
+	"gender=male&family=smith&birthdate=lt1980",
+}
+
+func seedPatients(t testing.TB, s *Store, n int) {
+	t.Helper()
+	genders := []string{"male", "female", "other"}
+	given := []string{"Jo", "José", "Ann", "Łukasz"}
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("p%d", i)
+		p := map[string]any{
+			"resourceType": "Patient",
+			"id":           id,
+			"identifier":   []any{map[string]any{"system": "http://mrn", "value": fmt.Sprint(i)}},
+			"gender":       genders[i%len(genders)],
+			"birthDate":    fmt.Sprintf("%d-%02d-%02d", 1950+i%60, 1+i%12, 1+i%28),
+			"name": []any{map[string]any{
+				"family": fmt.Sprintf("Smith-%d", i%10),
+				"given":  []any{given[i%len(given)]},
+			}},
+			"generalPractitioner":  []any{map[string]any{"reference": fmt.Sprintf("Practitioner/dr%d", i%7)}},
+			"managingOrganization": map[string]any{"reference": fmt.Sprintf("Organization/org%d", i%3)},
+		}
+		if _, err := s.Put("Patient", id, p, storage.AnyVersion); err != nil {
+			t.Fatalf("put err: %v", err)
+		}
+	}
+}
+
+func searchIDs(t *testing.T, s *Store, query string) string {
+	t.Helper()
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	q, err := search.Parse(fhir.Patient(), values)
+	if err != nil {
+		t.Fatalf("%s: parse err: %v", query, err)
+	}
+	got, err := s.Search(q)
+	if err != nil {
+		t.Fatalf("%s: search err: %v", query, err)
+	}
+	ids := make([]string, len(got))
+	for i, r := range got {
+		ids[i] = r["id"].(string)
+	}
+	return strings.Join(ids, ",")
+}
+
+// TestStore_IndexedSearchAgreesWithScan runs the same queries against an
+// indexed and an unindexed store through creates, updates, deletes and a
+// rolled back transaction.
+func TestStore_IndexedSearchAgreesWithScan(t *testing.T) {
+	indexed := NewStore(WithIndexes(fhir.DefaultRegistry()))
+	scan := NewStore()
+
+	check := func(stage string) {
+		t.Helper()
+		for _, query := range indexQueries {
+			want := searchIDs(t, scan, query)
+			if got := searchIDs(t, indexed, query); got != want {
+				t.Fatalf("%s: %s: indexed=%q scan=%q", stage, query, got, want)
+			}
+		}
+	}
+
+	for _, s := range []*Store{indexed, scan} {
+		seedPatients(t, s, 200)
+	}
+	check("seeded")
+	if searchIDs(t, indexed, "identifier=http://mrn|17") != "p17" {
+		t.Fatalf("expected identifier lookup to find p17")
+	}
+
+	for _, s := range []*Store{indexed, scan} {
+		// Change indexed values and delete a few resources.
+		if _, err := s.Put("Patient", "p17", map[string]any{"resourceType": "Patient", "id": "p17", "gender": "female", "identifier": []any{map[string]any{"value": "18"}}}, storage.AnyVersion); err != nil {
+			t.Fatalf("update err: %v", err)
+		}
+		for _, id := range []string{"p7", "p18", "p3"} {
+			if _, err := s.Delete("Patient", id, storage.AnyVersion); err != nil {
+				t.Fatalf("delete err: %v", err)
+			}
+		}
+	}
+	check("updated")
+
+	for _, s := range []*Store{indexed, scan} {
+		err := s.Transaction(func(tx storage.Tx) error {
+			if _, err := tx.Put("Patient", "p8", map[string]any{"resourceType": "Patient", "id": "p8", "gender": "unknown"}, storage.AnyVersion); err != nil {
+				return err
+			}
+			if _, err := tx.Delete("Patient", "p17", storage.AnyVersion); err != nil {
+				return err
+			}
+			if _, err := tx.Put("Patient", "new", map[string]any{"resourceType": "Patient", "id": "new", "gender": "female"}, 0); err != nil {
+				return err
+			}
+			return errors.New("roll back")
+		})
+		if err == nil {
+			t.Fatalf("expected transaction error")
+		}
+	}
+	check("rolled back")
+}
+
+func BenchmarkSearch(b *testing.B) {
+	const n = 10000
+	queries := []string{
+		"identifier=http://mrn|4242",
+		"family=smith-4",
+		"birthdate=1980-03",
+		"general-practitioner=Practitioner/dr3",
+	}
+
+	indexed := NewStore(WithIndexes(fhir.DefaultRegistry()))
+	seedPatients(b, indexed, n)
+
+	for _, query := range queries {
+		values, _ := url.ParseQuery(query)
+		q, err := search.Parse(fhir.Patient(), values)
+		if err != nil {
+			b.Fatalf("%s: %v", query, err)
+		}
+
+		b.Run("indexed/"+query, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := indexed.Search(q); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		// The linear scan is what search did before the indexes: copy every
+		// resource out of the store, then filter.
+		b.Run("scan/"+query, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				all, err := indexed.List("Patient")
+				if err != nil {
+					b.Fatal(err)
+				}
+				_ = q.Filter(all)
+			}
+		})
+	}
+}
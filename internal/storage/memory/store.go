@@ -0,0 +1,400 @@
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/search"
+	"go-fhir-server/internal/storage"
+)
+
+// key identifies a single resource instance across all resource types.
+type key struct {
+	resourceType string
+	id           string
+}
+
+type Store struct {
+	mu sync.RWMutex
+	// data is indexed by resourceType first so List doesn't scan other types.
+	data map[string]map[string]map[string]any
+	// versions holds the newest versionId ever written per resource,
+	// including deletion tombstones.
+	versions map[key]int
+
+	// history is append-only and ordered oldest first; byKey indexes into it.
+	history []storage.Version
+	byKey   map[key][]int
+
+	// registry decides which search parameters get secondary indexes; with
+	// no registry, Search checks every resource of the type.
+	registry *fhir.Registry
+	indexes  map[string]*typeIndex
+
+	// commitLog, when set, sees the versions of every write before it is
+	// acknowledged.
+	commitLog func(versions []storage.Version) error
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithIndexes maintains secondary indexes for the search parameters of every
+// type in registry, so Search doesn't have to look at every resource.
+func WithIndexes(registry *fhir.Registry) Option {
+	return func(s *Store) { s.registry = registry }
+}
+
+// WithCommitLog calls log with the versions each Put, Delete or Transaction
+// adds, oldest first, while the write lock is still held. When log returns an
+// error the write is undone and the error returned, so a durable log never
+// falls behind the store. log must not modify the versions or call back into
+// the store.
+func WithCommitLog(log func(versions []storage.Version) error) Option {
+	return func(s *Store) { s.commitLog = log }
+}
+
+func NewStore(opts ...Option) *Store {
+	s := &Store{
+		data:     make(map[string]map[string]map[string]any),
+		versions: make(map[key]int),
+		byKey:    make(map[key][]int),
+		indexes:  make(map[string]*typeIndex),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *Store) Put(resourceType, id string, resource map[string]any, expectedVersion int) (map[string]any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.commitLog == nil {
+		return s.put(resourceType, id, resource, expectedVersion)
+	}
+
+	var stored map[string]any
+	err := s.transaction(func(tx storage.Tx) (err error) {
+		stored, err = tx.Put(resourceType, id, resource, expectedVersion)
+		return err
+	})
+	return stored, err
+}
+
+func (s *Store) Get(resourceType, id string) (map[string]any, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.get(resourceType, id)
+}
+
+// Delete removes the current resource and records a tombstone version so the
+// deletion itself shows up in history.
+func (s *Store) Delete(resourceType, id string, expectedVersion int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.commitLog == nil {
+		return s.delete(resourceType, id, expectedVersion)
+	}
+
+	var deleted bool
+	err := s.transaction(func(tx storage.Tx) (err error) {
+		deleted, err = tx.Delete(resourceType, id, expectedVersion)
+		return err
+	})
+	return deleted, err
+}
+
+func (s *Store) List(resourceType string) ([]map[string]any, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.list(resourceType)
+}
+
+func (s *Store) Search(q search.Query) ([]map[string]any, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.search(q)
+}
+
+func (s *Store) History(resourceType, id string) ([]storage.Version, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.historyOf(resourceType, id)
+}
+
+func (s *Store) VRead(resourceType, id string, versionID int) (storage.Version, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.vread(resourceType, id, versionID)
+}
+
+// Transaction holds the write lock for the whole of fn, so other readers and
+// writers see either none or all of its changes. On error (or panic) every
+// write made through tx is undone.
+func (s *Store) Transaction(fn func(tx storage.Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.transaction(fn)
+}
+
+// Restore appends a version read back from a durable log, keeping its
+// versionId and lastUpdated as recorded. Versions of a resource must be
+// restored in order.
+func (s *Store) Restore(v storage.Version) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := key{v.ResourceType, v.ID}
+	if v.VersionID != s.versions[k]+1 {
+		return fmt.Errorf("restore %s/%s: version %d follows version %d", v.ResourceType, v.ID, v.VersionID, s.versions[k])
+	}
+
+	if v.Deleted {
+		delete(s.data[v.ResourceType], v.ID)
+		s.reindex(v.ResourceType, v.ID, nil)
+		v.Resource = nil
+	} else {
+		v.Resource = deepCopy(v.Resource)
+		byID, ok := s.data[v.ResourceType]
+		if !ok {
+			byID = make(map[string]map[string]any)
+			s.data[v.ResourceType] = byID
+		}
+		byID[v.ID] = v.Resource
+		s.reindex(v.ResourceType, v.ID, v.Resource)
+	}
+	s.versions[k] = v.VersionID
+	s.record(k, v)
+	return nil
+}
+
+// The unexported methods below do the actual work; callers must hold s.mu.
+
+func (s *Store) transaction(fn func(tx storage.Tx) error) error {
+	t := &tx{s: s, historyLen: len(s.history), saved: make(map[key]savedState)}
+	defer func() {
+		if p := recover(); p != nil {
+			t.rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(t); err != nil {
+		t.rollback()
+		return err
+	}
+	if s.commitLog != nil && len(s.history) > t.historyLen {
+		if err := s.commitLog(s.history[t.historyLen:]); err != nil {
+			t.rollback()
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) put(resourceType, id string, resource map[string]any, expectedVersion int) (map[string]any, error) {
+	k := key{resourceType, id}
+	current := 0
+	if _, live := s.data[resourceType][id]; live {
+		current = s.versions[k]
+	}
+	if expectedVersion != storage.AnyVersion && expectedVersion != current {
+		return nil, &storage.ConflictError{ResourceType: resourceType, ID: id, Expected: expectedVersion, Current: current}
+	}
+
+	// Versions keep counting across deletes so history never reuses an id.
+	version := s.versions[k] + 1
+	now := time.Now().UTC().Truncate(time.Second)
+
+	stored := deepCopy(resource)
+	fhir.EnsureMeta(stored, version, now)
+
+	byID, ok := s.data[resourceType]
+	if !ok {
+		byID = make(map[string]map[string]any)
+		s.data[resourceType] = byID
+	}
+	byID[id] = stored
+	s.reindex(resourceType, id, stored)
+	s.versions[k] = version
+	s.record(k, storage.Version{
+		ResourceType: resourceType,
+		ID:           id,
+		VersionID:    version,
+		LastUpdated:  now,
+		Resource:     stored,
+	})
+
+	return deepCopy(stored), nil
+}
+
+func (s *Store) get(resourceType, id string) (map[string]any, bool, error) {
+	v, ok := s.data[resourceType][id]
+	if !ok {
+		return nil, false, nil
+	}
+	return deepCopy(v), true, nil
+}
+
+func (s *Store) delete(resourceType, id string, expectedVersion int) (bool, error) {
+	if _, ok := s.data[resourceType][id]; !ok {
+		return false, nil
+	}
+
+	k := key{resourceType, id}
+	if expectedVersion != storage.AnyVersion && expectedVersion != s.versions[k] {
+		return false, &storage.ConflictError{ResourceType: resourceType, ID: id, Expected: expectedVersion, Current: s.versions[k]}
+	}
+
+	delete(s.data[resourceType], id)
+	s.reindex(resourceType, id, nil)
+	s.versions[k]++
+	s.record(k, storage.Version{
+		ResourceType: resourceType,
+		ID:           id,
+		VersionID:    s.versions[k],
+		LastUpdated:  time.Now().UTC().Truncate(time.Second),
+		Deleted:      true,
+	})
+	return true, nil
+}
+
+func (s *Store) list(resourceType string) ([]map[string]any, error) {
+	byID := s.data[resourceType]
+	ids := make([]string, 0, len(byID))
+	for id := range byID {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	out := make([]map[string]any, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, deepCopy(byID[id]))
+	}
+	return out, nil
+}
+
+// search narrows the candidates with the indexes of every criterion that has
+// one, then confirms each candidate against the full query. Only matches are
+// copied.
+func (s *Store) search(q search.Query) ([]map[string]any, error) {
+	byID := s.data[q.ResourceType]
+
+	var candidates map[string]struct{}
+	if ti := s.indexes[q.ResourceType]; ti != nil {
+		for _, c := range q.Criteria {
+			ids, ok := ti.candidates(c)
+			if !ok {
+				continue
+			}
+			if candidates == nil {
+				candidates = ids
+				continue
+			}
+			for id := range candidates {
+				if _, keep := ids[id]; !keep {
+					delete(candidates, id)
+				}
+			}
+		}
+	}
+
+	var ids []string
+	if candidates != nil {
+		ids = make([]string, 0, len(candidates))
+		for id := range candidates {
+			ids = append(ids, id)
+		}
+	} else {
+		ids = make([]string, 0, len(byID))
+		for id := range byID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	out := make([]map[string]any, 0, len(ids))
+	for _, id := range ids {
+		if res, ok := byID[id]; ok && q.Matches(res) {
+			out = append(out, deepCopy(res))
+		}
+	}
+	return out, nil
+}
+
+// reindex replaces the index entries of one resource; a nil resource just
+// removes them. Callers must hold s.mu for writing.
+func (s *Store) reindex(resourceType, id string, resource map[string]any) {
+	if s.registry == nil {
+		return
+	}
+	ti, ok := s.indexes[resourceType]
+	if !ok {
+		rt, known := s.registry.Lookup(resourceType)
+		if !known {
+			return
+		}
+		ti = newTypeIndex(rt)
+		s.indexes[resourceType] = ti
+	}
+	ti.remove(id)
+	if resource != nil {
+		ti.add(id, resource)
+	}
+}
+
+func (s *Store) historyOf(resourceType, id string) ([]storage.Version, error) {
+	var out []storage.Version
+	if resourceType != "" && id != "" {
+		idx := s.byKey[key{resourceType, id}]
+		out = make([]storage.Version, 0, len(idx))
+		for i := len(idx) - 1; i >= 0; i-- {
+			out = append(out, copyVersion(s.history[idx[i]]))
+		}
+		return out, nil
+	}
+
+	for i := len(s.history) - 1; i >= 0; i-- {
+		v := s.history[i]
+		if resourceType != "" && v.ResourceType != resourceType {
+			continue
+		}
+		out = append(out, copyVersion(v))
+	}
+	return out, nil
+}
+
+func (s *Store) vread(resourceType, id string, versionID int) (storage.Version, bool, error) {
+	idx := s.byKey[key{resourceType, id}]
+	for i := len(idx) - 1; i >= 0; i-- {
+		if v := s.history[idx[i]]; v.VersionID == versionID {
+			return copyVersion(v), true, nil
+		}
+	}
+	return storage.Version{}, false, nil
+}
+
+// record appends v to the history log. Callers must hold s.mu for writing.
+func (s *Store) record(k key, v storage.Version) {
+	s.byKey[k] = append(s.byKey[k], len(s.history))
+	s.history = append(s.history, v)
+}
+
+func copyVersion(v storage.Version) storage.Version {
+	if v.Resource != nil {
+		v.Resource = deepCopy(v.Resource)
+	}
+	return v
+}
+
+func deepCopy(m map[string]any) map[string]any {
+	b, _ := json.Marshal(m)
+	var out map[string]any
+	_ = json.Unmarshal(b, &out)
+	return out
+}
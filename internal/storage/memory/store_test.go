@@ -0,0 +1,84 @@
+package memory
+
+import (
+	"errors"
+	"testing"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/storage"
+	"go-fhir-server/internal/storage/storagetest"
+)
+
+func TestStore_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.ResourceStore { return NewStore() })
+}
+
+func TestStore_ConformanceWithIndexes(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.ResourceStore {
+		return NewStore(WithIndexes(fhir.DefaultRegistry()))
+	})
+}
+
+func TestStore_CommitLog(t *testing.T) {
+	var logged [][]storage.Version
+	fail := false
+	s := NewStore(WithCommitLog(func(vs []storage.Version) error {
+		if fail {
+			return errors.New("disk full")
+		}
+		logged = append(logged, append([]storage.Version(nil), vs...))
+		return nil
+	}))
+
+	if _, err := s.Put("Patient", "a", map[string]any{"resourceType": "Patient"}, 0); err != nil {
+		t.Fatalf("put err: %v", err)
+	}
+	err := s.Transaction(func(tx storage.Tx) error {
+		if _, err := tx.Delete("Patient", "a", storage.AnyVersion); err != nil {
+			return err
+		}
+		_, err := tx.Put("Patient", "b", map[string]any{"resourceType": "Patient"}, 0)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("transaction err: %v", err)
+	}
+	if len(logged) != 2 || len(logged[0]) != 1 || len(logged[1]) != 2 || !logged[1][0].Deleted {
+		t.Fatalf("expected one record per write, got %+v", logged)
+	}
+
+	// A failing log undoes the write it was asked to record.
+	fail = true
+	if _, err := s.Put("Patient", "b", map[string]any{"resourceType": "Patient", "active": true}, storage.AnyVersion); err == nil {
+		t.Fatalf("expected put to fail with the log")
+	}
+	got, _, _ := s.Get("Patient", "b")
+	if _, ok := got["active"]; ok || got["meta"].(map[string]any)["versionId"] != "1" {
+		t.Fatalf("expected failed write to be undone, got %v", got)
+	}
+}
+
+func TestStore_Restore(t *testing.T) {
+	src := NewStore()
+	src.Put("Patient", "a", map[string]any{"resourceType": "Patient", "id": "a"}, 0)
+	src.Put("Patient", "a", map[string]any{"resourceType": "Patient", "id": "a", "active": true}, 1)
+	src.Delete("Patient", "a", storage.AnyVersion)
+	src.Put("Patient", "b", map[string]any{"resourceType": "Patient", "id": "b"}, 0)
+
+	dst := NewStore()
+	hist, _ := src.History("", "")
+	for i := len(hist) - 1; i >= 0; i-- {
+		if err := dst.Restore(hist[i]); err != nil {
+			t.Fatalf("restore err: %v", err)
+		}
+	}
+	if _, ok, _ := dst.Get("Patient", "a"); ok {
+		t.Fatalf("expected a to stay deleted")
+	}
+	if v, ok, _ := dst.VRead("Patient", "a", 2); !ok || v.Resource["active"] != true {
+		t.Fatalf("expected version 2 of a, got %+v", v)
+	}
+	if err := dst.Restore(hist[0]); err == nil {
+		t.Fatalf("expected restoring an out of order version to fail")
+	}
+}
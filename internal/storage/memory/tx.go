@@ -0,0 +1,100 @@
+package memory
+
+import (
+	"go-fhir-server/internal/search"
+	"go-fhir-server/internal/storage"
+)
+
+// tx is the storage.Tx handed to Transaction callbacks. It runs with the
+// store's write lock already held and remembers the pre-transaction state of
+// every resource it touches so a failed transaction can be undone.
+type tx struct {
+	s          *Store
+	historyLen int
+	saved      map[key]savedState
+}
+
+type savedState struct {
+	resource   map[string]any
+	live       bool
+	version    int
+	hasVersion bool
+	historyLen int
+}
+
+func (t *tx) Put(resourceType, id string, resource map[string]any, expectedVersion int) (map[string]any, error) {
+	t.save(key{resourceType, id})
+	return t.s.put(resourceType, id, resource, expectedVersion)
+}
+
+func (t *tx) Get(resourceType, id string) (map[string]any, bool, error) {
+	return t.s.get(resourceType, id)
+}
+
+func (t *tx) Delete(resourceType, id string, expectedVersion int) (bool, error) {
+	t.save(key{resourceType, id})
+	return t.s.delete(resourceType, id, expectedVersion)
+}
+
+func (t *tx) List(resourceType string) ([]map[string]any, error) {
+	return t.s.list(resourceType)
+}
+
+func (t *tx) Search(q search.Query) ([]map[string]any, error) {
+	return t.s.search(q)
+}
+
+func (t *tx) History(resourceType, id string) ([]storage.Version, error) {
+	return t.s.historyOf(resourceType, id)
+}
+
+func (t *tx) VRead(resourceType, id string, versionID int) (storage.Version, bool, error) {
+	return t.s.vread(resourceType, id, versionID)
+}
+
+// save snapshots k the first time the transaction touches it.
+func (t *tx) save(k key) {
+	if _, done := t.saved[k]; done {
+		return
+	}
+	resource, live := t.s.data[k.resourceType][k.id]
+	version, hasVersion := t.s.versions[k]
+	t.saved[k] = savedState{
+		resource:   resource,
+		live:       live,
+		version:    version,
+		hasVersion: hasVersion,
+		historyLen: len(t.s.byKey[k]),
+	}
+}
+
+// rollback restores every touched resource and drops history written since
+// the transaction began. Stored maps are never mutated in place, so the saved
+// pointers are still the original content.
+func (t *tx) rollback() {
+	s := t.s
+	for k, st := range t.saved {
+		if st.live {
+			s.data[k.resourceType][k.id] = st.resource
+			s.reindex(k.resourceType, k.id, st.resource)
+		} else {
+			delete(s.data[k.resourceType], k.id)
+			s.reindex(k.resourceType, k.id, nil)
+		}
+
+		if st.hasVersion {
+			s.versions[k] = st.version
+		} else {
+			delete(s.versions, k)
+		}
+
+		if st.historyLen == 0 {
+			delete(s.byKey, k)
+		} else {
+			s.byKey[k] = s.byKey[k][:st.historyLen]
+		}
+	}
+
+	clear(s.history[t.historyLen:])
+	s.history = s.history[:t.historyLen]
+}
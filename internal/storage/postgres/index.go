@@ -0,0 +1,327 @@
+package postgres
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/search"
+)
+
+// indexFormat is part of every index fingerprint. Bump it when search.Terms
+// or search.DateRanges change what they produce, so existing rows get rebuilt.
+const indexFormat = 1
+
+// rebuildBatch is how many resources a rebuild reads at a time.
+const rebuildBatch = 500
+
+// indexes holds the indexed search parameters of each resource type, by
+// name. A nil indexes indexes nothing and Search scans the whole type.
+type indexes map[string]map[string]fhir.SearchParam
+
+func newIndexes(registry *fhir.Registry) indexes {
+	out := make(indexes)
+	for _, name := range registry.Names() {
+		rt, _ := registry.Lookup(name)
+		params := make(map[string]fhir.SearchParam)
+		for _, p := range append(search.CommonParams(), rt.SearchParams...) {
+			params[p.Name] = p
+		}
+		out[name] = params
+	}
+	return out
+}
+
+// param returns the indexed definition c searches, if c's definition is the
+// one that was indexed.
+func (ix indexes) param(resourceType string, c search.Criterion) (fhir.SearchParam, bool) {
+	p, ok := ix[resourceType][c.Param.Name]
+	if !ok || p.Type != c.Param.Type || !slices.Equal(p.Paths, c.Param.Paths) {
+		return fhir.SearchParam{}, false
+	}
+	return p, true
+}
+
+// write replaces the index rows of one resource; a nil resource just
+// deletes them.
+func (ix indexes) write(ctx context.Context, q querier, resourceType, id string, resource map[string]any) error {
+	params, ok := ix[resourceType]
+	if !ok {
+		return nil
+	}
+	if _, err := q.ExecContext(ctx, `DELETE FROM search_terms WHERE resource_type = $1 AND id = $2`, resourceType, id); err != nil {
+		return err
+	}
+	if _, err := q.ExecContext(ctx, `DELETE FROM search_dates WHERE resource_type = $1 AND id = $2`, resourceType, id); err != nil {
+		return err
+	}
+	if resource == nil {
+		return nil
+	}
+
+	var termParams, terms, dateParams, starts, ends []string
+	for _, p := range params {
+		if p.Type == fhir.SearchDate {
+			for _, r := range search.DateRanges(p, resource) {
+				dateParams = append(dateParams, p.Name)
+				starts = append(starts, formatBound(r.Start))
+				ends = append(ends, formatBound(r.End))
+			}
+			continue
+		}
+		ts := search.Terms(p, resource)
+		slices.Sort(ts)
+		for _, t := range slices.Compact(ts) {
+			termParams = append(termParams, p.Name)
+			terms = append(terms, t)
+		}
+	}
+
+	if len(terms) > 0 {
+		if _, err := q.ExecContext(ctx, `
+			INSERT INTO search_terms (resource_type, param, term, id)
+			SELECT $1::text, p, t, $2::text FROM unnest($3::text[], $4::text[]) AS x(p, t)`,
+			resourceType, id, pq.Array(termParams), pq.Array(terms)); err != nil {
+			return err
+		}
+	}
+	if len(dateParams) > 0 {
+		if _, err := q.ExecContext(ctx, `
+			INSERT INTO search_dates (resource_type, param, id, start_at, end_at)
+			SELECT $1::text, p, $2::text, NULLIF(s, '')::timestamptz, NULLIF(e, '')::timestamptz
+			FROM unnest($3::text[], $4::text[], $5::text[]) AS x(p, s, e)`,
+			resourceType, id, pq.Array(dateParams), pq.Array(starts), pq.Array(ends)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filter turns the indexed criteria of query into extra WHERE conditions on
+// resources, whose first argument ($1) is the resource type. empty reports
+// that the indexes already rule out every resource.
+func (ix indexes) filter(ctx context.Context, q querier, query search.Query) (where string, args []any, empty bool, err error) {
+	args = []any{query.ResourceType}
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	var dateIDs map[string]bool
+	for _, c := range query.Criteria {
+		p, ok := ix.param(query.ResourceType, c)
+		if !ok {
+			continue
+		}
+
+		if p.Type == fhir.SearchDate {
+			ids, err := dateCandidates(ctx, q, query.ResourceType, c)
+			if err != nil {
+				return "", nil, false, err
+			}
+			if dateIDs != nil {
+				for id := range dateIDs {
+					if !ids[id] {
+						delete(dateIDs, id)
+					}
+				}
+			} else {
+				dateIDs = ids
+			}
+			continue
+		}
+
+		terms, ok := c.Terms()
+		if !ok {
+			continue
+		}
+		where += ` AND id IN (SELECT id FROM search_terms WHERE resource_type = $1 AND param = ` +
+			arg(p.Name) + ` AND term = ANY(` + arg(pq.Array(terms)) + `))`
+	}
+
+	if dateIDs != nil {
+		if len(dateIDs) == 0 {
+			return "", nil, true, nil
+		}
+		ids := make([]string, 0, len(dateIDs))
+		for id := range dateIDs {
+			ids = append(ids, id)
+		}
+		where += ` AND id = ANY(` + arg(pq.Array(ids)) + `)`
+	}
+	return where, args, false, nil
+}
+
+// dateCandidates checks every indexed range of a date parameter with the
+// search package's own comparison, so prefixes such as ap and sa behave as
+// they do everywhere else.
+func dateCandidates(ctx context.Context, q querier, resourceType string, c search.Criterion) (map[string]bool, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT id, start_at, end_at FROM search_dates
+		WHERE resource_type = $1 AND param = $2`, resourceType, c.Param.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[string]bool)
+	for rows.Next() {
+		var (
+			id         string
+			start, end sql.NullTime
+		)
+		if err := rows.Scan(&id, &start, &end); err != nil {
+			return nil, err
+		}
+		r := search.DateRange{}
+		if start.Valid {
+			r.Start = start.Time.UTC()
+		}
+		if end.Valid {
+			r.End = end.Time.UTC()
+		}
+		if c.MatchesRange(r) {
+			ids[id] = true
+		}
+	}
+	return ids, rows.Err()
+}
+
+// rebuildStale rebuilds the index rows of every type whose parameters
+// changed since they were written, e.g. after an upgrade added a parameter.
+func (ix indexes) rebuildStale(ctx context.Context, db *sql.DB) error {
+	for resourceType, params := range ix {
+		fp, err := fingerprint(params)
+		if err != nil {
+			return err
+		}
+		if err := ix.rebuild(ctx, db, resourceType, fp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ix indexes) rebuild(ctx context.Context, db *sql.DB, resourceType, fp string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtextextended('search_index/' || $1::text, 0))`, resourceType); err != nil {
+		return err
+	}
+	var current string
+	err = tx.QueryRowContext(ctx, `SELECT fingerprint FROM search_index_state WHERE resource_type = $1`, resourceType).Scan(&current)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if current == fp {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM search_terms WHERE resource_type = $1`, resourceType); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM search_dates WHERE resource_type = $1`, resourceType); err != nil {
+		return err
+	}
+
+	after := ""
+	for {
+		batch, err := resourceBatch(ctx, tx, resourceType, after)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		for _, res := range batch {
+			id, _ := res["id"].(string)
+			if err := ix.write(ctx, tx, resourceType, id, res); err != nil {
+				return err
+			}
+		}
+		after, _ = batch[len(batch)-1]["id"].(string)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO search_index_state (resource_type, fingerprint) VALUES ($1, $2)
+		ON CONFLICT (resource_type) DO UPDATE SET fingerprint = excluded.fingerprint`,
+		resourceType, fp); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// resourceBatch reads the next rebuildBatch resources with an id after after.
+// The id column, not the resource body, is authoritative.
+func resourceBatch(ctx context.Context, q querier, resourceType, after string) ([]map[string]any, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT id, resource FROM resources
+		WHERE resource_type = $1 AND id > $2
+		ORDER BY id LIMIT $3`, resourceType, after, rebuildBatch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []map[string]any
+	for rows.Next() {
+		var (
+			id  string
+			doc []byte
+		)
+		if err := rows.Scan(&id, &doc); err != nil {
+			return nil, err
+		}
+		res, err := decodeResource(doc)
+		if err != nil {
+			return nil, err
+		}
+		res["id"] = id
+		out = append(out, res)
+	}
+	return out, rows.Err()
+}
+
+// fingerprint identifies the index rows a set of parameter definitions
+// produces.
+func fingerprint(params map[string]fhir.SearchParam) (string, error) {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	ordered := make([]fhir.SearchParam, len(names))
+	for i, name := range names {
+		ordered[i] = params[name]
+	}
+
+	b, err := json.Marshal(struct {
+		Format int
+		Params []fhir.SearchParam
+	}{indexFormat, ordered})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func formatBound(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
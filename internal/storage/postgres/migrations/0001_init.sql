@@ -0,0 +1,52 @@
+-- Current version of every live resource. Ids use the C collation so
+-- ORDER BY id matches Go's byte-wise string order.
+CREATE TABLE resources (
+    resource_type text COLLATE "C" NOT NULL,
+    id            text COLLATE "C" NOT NULL,
+    version_id    integer NOT NULL,
+    last_updated  timestamptz NOT NULL,
+    resource      jsonb NOT NULL,
+    PRIMARY KEY (resource_type, id)
+);
+
+-- Every version ever written, including deletion tombstones (resource IS
+-- NULL). seq orders the history across resources.
+CREATE TABLE resource_history (
+    seq           bigserial PRIMARY KEY,
+    resource_type text COLLATE "C" NOT NULL,
+    id            text COLLATE "C" NOT NULL,
+    version_id    integer NOT NULL,
+    last_updated  timestamptz NOT NULL,
+    deleted       boolean NOT NULL DEFAULT false,
+    resource      jsonb,
+    UNIQUE (resource_type, id, version_id)
+);
+CREATE INDEX resource_history_by_type ON resource_history (resource_type, seq);
+
+-- Search index for token, string and reference parameters; see search.Terms.
+CREATE TABLE search_terms (
+    resource_type text COLLATE "C" NOT NULL,
+    param         text NOT NULL,
+    term          text NOT NULL,
+    id            text COLLATE "C" NOT NULL,
+    PRIMARY KEY (resource_type, param, term, id)
+);
+CREATE INDEX search_terms_by_resource ON search_terms (resource_type, id);
+
+-- Search index for date parameters. A NULL bound is open-ended.
+CREATE TABLE search_dates (
+    resource_type text COLLATE "C" NOT NULL,
+    param         text NOT NULL,
+    id            text COLLATE "C" NOT NULL,
+    start_at      timestamptz,
+    end_at        timestamptz
+);
+CREATE INDEX search_dates_by_param ON search_dates (resource_type, param);
+CREATE INDEX search_dates_by_resource ON search_dates (resource_type, id);
+
+-- Which search parameter definitions each type's index rows were built
+-- for, so a changed registry triggers a rebuild.
+CREATE TABLE search_index_state (
+    resource_type text COLLATE "C" PRIMARY KEY,
+    fingerprint   text NOT NULL
+);
@@ -0,0 +1,444 @@
+// Package postgres is a storage.ResourceStore backed by PostgreSQL. Current
+// resources and their full history are stored as JSONB; search parameters
+// are indexed in side tables kept up to date in the same transaction as the
+// write. The schema is created and upgraded by embedded migrations on Open.
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	_ "github.com/lib/pq" // registers the "postgres" database/sql driver
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/search"
+	"go-fhir-server/internal/storage"
+)
+
+type Store struct {
+	db      *sql.DB
+	indexes indexes
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithIndexes maintains search index rows for every type in registry, so
+// Search doesn't have to load every resource of the type.
+func WithIndexes(registry *fhir.Registry) Option {
+	return func(s *Store) { s.indexes = newIndexes(registry) }
+}
+
+// Open connects to the database at dsn (a lib/pq connection string or
+// postgres:// URL), applies pending migrations and brings the search indexes
+// up to date with the configured parameters.
+func Open(dsn string, opts ...Option) (*Store, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	s, err := New(db, opts...)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// New is Open for an existing connection pool.
+func New(db *sql.DB, opts ...Option) (*Store, error) {
+	s := &Store{db: db}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	ctx := context.Background()
+	if err := db.PingContext(ctx); err != nil {
+		return nil, err
+	}
+	if err := Migrate(ctx, db); err != nil {
+		return nil, err
+	}
+	if err := s.indexes.rebuildStale(ctx, db); err != nil {
+		return nil, fmt.Errorf("rebuild search indexes: %w", err)
+	}
+	return s, nil
+}
+
+// Close closes the connection pool.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Put and Delete run in a transaction of their own so the version check,
+// the history row and the index rows commit together. They only share the
+// type lock, so single writes of one type don't wait for each other.
+
+func (s *Store) Put(resourceType, id string, resource map[string]any, expectedVersion int) (map[string]any, error) {
+	var stored map[string]any
+	err := s.transaction(sharedTypeLock, func(tx storage.Tx) (err error) {
+		stored, err = tx.Put(resourceType, id, resource, expectedVersion)
+		return err
+	})
+	return stored, err
+}
+
+func (s *Store) Get(resourceType, id string) (map[string]any, bool, error) {
+	return s.conn().Get(resourceType, id)
+}
+
+func (s *Store) Delete(resourceType, id string, expectedVersion int) (bool, error) {
+	var deleted bool
+	err := s.transaction(sharedTypeLock, func(tx storage.Tx) (err error) {
+		deleted, err = tx.Delete(resourceType, id, expectedVersion)
+		return err
+	})
+	return deleted, err
+}
+
+func (s *Store) List(resourceType string) ([]map[string]any, error) {
+	return s.conn().List(resourceType)
+}
+
+func (s *Store) Search(q search.Query) ([]map[string]any, error) {
+	return s.conn().Search(q)
+}
+
+func (s *Store) History(resourceType, id string) ([]storage.Version, error) {
+	return s.conn().History(resourceType, id)
+}
+
+func (s *Store) VRead(resourceType, id string, versionID int) (storage.Version, bool, error) {
+	return s.conn().VRead(resourceType, id, versionID)
+}
+
+// Transaction runs fn in a database transaction. The first search, list or
+// write of each resource type takes an exclusive lock on the type until
+// commit, so whatever fn's searches found stays true until it returns: other
+// transactions and single writes of those types wait. Transactions locking
+// the same types in a different order may fail with a deadlock error.
+func (s *Store) Transaction(fn func(tx storage.Tx) error) error {
+	return s.transaction(exclusiveTypeLock, fn)
+}
+
+func (s *Store) transaction(lock typeLock, fn func(tx storage.Tx) error) error {
+	ctx := context.Background()
+	sqlTx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Anything but a clean return from fn rolls back, including a panic and
+	// runtime.Goexit.
+	committed := false
+	defer func() {
+		if !committed {
+			sqlTx.Rollback()
+		}
+	}()
+
+	if err := fn(&conn{ctx: ctx, q: sqlTx, indexes: s.indexes, typeLock: lock, locked: map[string]bool{}}); err != nil {
+		return err
+	}
+	committed = true
+	return sqlTx.Commit()
+}
+
+func (s *Store) conn() *conn {
+	return &conn{ctx: context.Background(), q: s.db, indexes: s.indexes}
+}
+
+// querier is what *sql.DB and *sql.Tx have in common.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// conn implements storage.Tx on top of the pool or an open transaction.
+// Writes must go through a transaction.
+type conn struct {
+	ctx     context.Context
+	q       querier
+	indexes indexes
+
+	// typeLock is taken on each resource type before it is first searched,
+	// listed or written; locked records the types already held.
+	typeLock typeLock
+	locked   map[string]bool
+}
+
+// typeLock is the advisory lock a transaction holds on the resource types it
+// touches. A conditional create writes a fresh id, so the per-resource lock
+// in lockCurrent can't keep two of them from both finding no match.
+type typeLock int
+
+const (
+	noTypeLock typeLock = iota
+	sharedTypeLock
+	exclusiveTypeLock
+)
+
+func (c *conn) Put(resourceType, id string, resource map[string]any, expectedVersion int) (map[string]any, error) {
+	if err := c.lockType(resourceType); err != nil {
+		return nil, err
+	}
+	current, err := c.lockCurrent(resourceType, id)
+	if err != nil {
+		return nil, err
+	}
+	if expectedVersion != storage.AnyVersion && expectedVersion != current {
+		return nil, &storage.ConflictError{ResourceType: resourceType, ID: id, Expected: expectedVersion, Current: current}
+	}
+
+	// Versions keep counting across deletes so history never reuses an id.
+	latest, err := c.latestVersion(resourceType, id)
+	if err != nil {
+		return nil, err
+	}
+	version := latest + 1
+	now := time.Now().UTC().Truncate(time.Second)
+
+	stored, err := copyResource(resource)
+	if err != nil {
+		return nil, err
+	}
+	fhir.EnsureMeta(stored, version, now)
+	doc, err := json.Marshal(stored)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := c.q.ExecContext(c.ctx, `
+		INSERT INTO resources (resource_type, id, version_id, last_updated, resource)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (resource_type, id) DO UPDATE
+		SET version_id = excluded.version_id, last_updated = excluded.last_updated, resource = excluded.resource`,
+		resourceType, id, version, now, string(doc)); err != nil {
+		return nil, err
+	}
+	if _, err := c.q.ExecContext(c.ctx, `
+		INSERT INTO resource_history (resource_type, id, version_id, last_updated, resource)
+		VALUES ($1, $2, $3, $4, $5)`,
+		resourceType, id, version, now, string(doc)); err != nil {
+		return nil, err
+	}
+	if err := c.indexes.write(c.ctx, c.q, resourceType, id, stored); err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+func (c *conn) Get(resourceType, id string) (map[string]any, bool, error) {
+	var doc []byte
+	err := c.q.QueryRowContext(c.ctx, `SELECT resource FROM resources WHERE resource_type = $1 AND id = $2`, resourceType, id).Scan(&doc)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	res, err := decodeResource(doc)
+	return res, err == nil, err
+}
+
+func (c *conn) Delete(resourceType, id string, expectedVersion int) (bool, error) {
+	if err := c.lockType(resourceType); err != nil {
+		return false, err
+	}
+	current, err := c.lockCurrent(resourceType, id)
+	if err != nil {
+		return false, err
+	}
+	if current == 0 {
+		return false, nil
+	}
+	if expectedVersion != storage.AnyVersion && expectedVersion != current {
+		return false, &storage.ConflictError{ResourceType: resourceType, ID: id, Expected: expectedVersion, Current: current}
+	}
+
+	if _, err := c.q.ExecContext(c.ctx, `DELETE FROM resources WHERE resource_type = $1 AND id = $2`, resourceType, id); err != nil {
+		return false, err
+	}
+	if _, err := c.q.ExecContext(c.ctx, `
+		INSERT INTO resource_history (resource_type, id, version_id, last_updated, deleted)
+		VALUES ($1, $2, $3, $4, true)`,
+		resourceType, id, current+1, time.Now().UTC().Truncate(time.Second)); err != nil {
+		return false, err
+	}
+	if err := c.indexes.write(c.ctx, c.q, resourceType, id, nil); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (c *conn) List(resourceType string) ([]map[string]any, error) {
+	if err := c.lockType(resourceType); err != nil {
+		return nil, err
+	}
+	rows, err := c.q.QueryContext(c.ctx, `SELECT resource FROM resources WHERE resource_type = $1 ORDER BY id`, resourceType)
+	if err != nil {
+		return nil, err
+	}
+	return scanResources(rows, nil)
+}
+
+// Search narrows the candidates in SQL with the index of every criterion
+// that has one, then confirms each against the full query.
+func (c *conn) Search(q search.Query) ([]map[string]any, error) {
+	if err := c.lockType(q.ResourceType); err != nil {
+		return nil, err
+	}
+	where, args, empty, err := c.indexes.filter(c.ctx, c.q, q)
+	if err != nil || empty {
+		return []map[string]any{}, err
+	}
+	rows, err := c.q.QueryContext(c.ctx, `SELECT resource FROM resources WHERE resource_type = $1`+where+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanResources(rows, q.Matches)
+}
+
+func (c *conn) History(resourceType, id string) ([]storage.Version, error) {
+	query := `SELECT resource_type, id, version_id, last_updated, deleted, resource FROM resource_history`
+	var args []any
+	switch {
+	case resourceType != "" && id != "":
+		query += ` WHERE resource_type = $1 AND id = $2`
+		args = append(args, resourceType, id)
+	case resourceType != "":
+		query += ` WHERE resource_type = $1`
+		args = append(args, resourceType)
+	}
+	rows, err := c.q.QueryContext(c.ctx, query+` ORDER BY seq DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []storage.Version{}
+	for rows.Next() {
+		v, err := scanVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+func (c *conn) VRead(resourceType, id string, versionID int) (storage.Version, bool, error) {
+	row := c.q.QueryRowContext(c.ctx, `
+		SELECT resource_type, id, version_id, last_updated, deleted, resource
+		FROM resource_history WHERE resource_type = $1 AND id = $2 AND version_id = $3`,
+		resourceType, id, versionID)
+	v, err := scanVersion(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Version{}, false, nil
+	}
+	if err != nil {
+		return storage.Version{}, false, err
+	}
+	return v, true, nil
+}
+
+// lockType takes c's type lock on resourceType until the transaction ends.
+// Type locks are keyed with seed 1 so they never collide with lockCurrent's.
+func (c *conn) lockType(resourceType string) error {
+	if c.typeLock == noTypeLock || c.locked[resourceType] {
+		return nil
+	}
+	lock := `SELECT pg_advisory_xact_lock(hashtextextended($1::text, 1))`
+	if c.typeLock == sharedTypeLock {
+		lock = `SELECT pg_advisory_xact_lock_shared(hashtextextended($1::text, 1))`
+	}
+	if _, err := c.q.ExecContext(c.ctx, lock, resourceType); err != nil {
+		return err
+	}
+	c.locked[resourceType] = true
+	return nil
+}
+
+// lockCurrent serializes writers of one resource until the transaction ends
+// and returns its live version, 0 when it doesn't exist. An advisory lock is
+// used because a row lock can't cover a resource that doesn't exist yet.
+func (c *conn) lockCurrent(resourceType, id string) (int, error) {
+	if _, err := c.q.ExecContext(c.ctx, `SELECT pg_advisory_xact_lock(hashtextextended($1::text || '/' || $2::text, 0))`, resourceType, id); err != nil {
+		return 0, err
+	}
+	var current int
+	err := c.q.QueryRowContext(c.ctx, `SELECT version_id FROM resources WHERE resource_type = $1 AND id = $2`, resourceType, id).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return current, err
+}
+
+// latestVersion is the newest versionId ever written, including tombstones.
+func (c *conn) latestVersion(resourceType, id string) (int, error) {
+	var latest int
+	err := c.q.QueryRowContext(c.ctx, `
+		SELECT COALESCE(max(version_id), 0) FROM resource_history
+		WHERE resource_type = $1 AND id = $2`, resourceType, id).Scan(&latest)
+	return latest, err
+}
+
+// scanResources decodes a column of JSONB resources, keeping those keep
+// accepts (all when keep is nil).
+func scanResources(rows *sql.Rows, keep func(map[string]any) bool) ([]map[string]any, error) {
+	defer rows.Close()
+	out := []map[string]any{}
+	for rows.Next() {
+		var doc []byte
+		if err := rows.Scan(&doc); err != nil {
+			return nil, err
+		}
+		res, err := decodeResource(doc)
+		if err != nil {
+			return nil, err
+		}
+		if keep == nil || keep(res) {
+			out = append(out, res)
+		}
+	}
+	return out, rows.Err()
+}
+
+func scanVersion(row interface{ Scan(...any) error }) (storage.Version, error) {
+	var (
+		v   storage.Version
+		doc []byte
+	)
+	if err := row.Scan(&v.ResourceType, &v.ID, &v.VersionID, &v.LastUpdated, &v.Deleted, &doc); err != nil {
+		return storage.Version{}, err
+	}
+	v.LastUpdated = v.LastUpdated.UTC()
+	if doc != nil {
+		res, err := decodeResource(doc)
+		if err != nil {
+			return storage.Version{}, err
+		}
+		v.Resource = res
+	}
+	return v, nil
+}
+
+func decodeResource(doc []byte) (map[string]any, error) {
+	var res map[string]any
+	if err := json.Unmarshal(doc, &res); err != nil {
+		return nil, fmt.Errorf("decode stored resource: %w", err)
+	}
+	return res, nil
+}
+
+func copyResource(m map[string]any) (map[string]any, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return decodeResource(b)
+}
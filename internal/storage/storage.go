@@ -1,13 +1,83 @@
 package storage
 
-// PatientStore is intentionally MVP-level.
-// Later you can generalize to ResourceStore with resourceType + id.
-type PatientStore interface {
-	Put(id string, patient map[string]any) error
-	Get(id string) (patient map[string]any, ok bool, err error)
-	Delete(id string) (ok bool, err error)
-	List() ([]map[string]any, error)
-
-	// NextVersion bumps and returns the next versionId for this resource id.
-	NextVersion(id string) (int, error)
+import (
+	"fmt"
+	"time"
+
+	"go-fhir-server/internal/search"
+)
+
+// AnyVersion disables the expected-version check in ResourceStore.Put.
+const AnyVersion = -1
+
+// ResourceStore persists FHIR resources of any type, keyed by resourceType + id.
+// Resources are plain decoded JSON objects.
+type ResourceStore interface {
+	Tx
+
+	// Transaction runs fn against a transactional view of the store. Writes
+	// made through tx become visible together when fn returns nil and are all
+	// discarded when it returns an error.
+	//
+	// Transactions must also be isolated: once fn has searched, listed or
+	// written a resource type, nobody else writes resources of that type until
+	// it returns. A search made through tx therefore still holds when fn acts
+	// on its result, which conditional interactions rely on.
+	Transaction(fn func(tx Tx) error) error
+}
+
+// Tx is the read/write surface shared by a ResourceStore and an open
+// transaction. Code that takes a Tx works the same inside and outside one.
+type Tx interface {
+	// Put atomically checks expectedVersion against the current version,
+	// assigns the next versionId, stamps meta and writes the resource. It
+	// returns the stored copy. expectedVersion 0 means the resource must not
+	// currently exist; AnyVersion skips the check. On mismatch nothing is
+	// written and a *ConflictError is returned.
+	Put(resourceType, id string, resource map[string]any, expectedVersion int) (map[string]any, error)
+
+	Get(resourceType, id string) (resource map[string]any, ok bool, err error)
+
+	// Delete removes the resource and records a tombstone version. ok is false
+	// when there was nothing to delete; expectedVersion works as in Put.
+	Delete(resourceType, id string, expectedVersion int) (ok bool, err error)
+
+	// List returns every stored resource of the given type, ordered by id.
+	List(resourceType string) ([]map[string]any, error)
+
+	// Search returns the resources of q.ResourceType that match q.Criteria,
+	// ordered by id. Result parameters (_count, _sort, ...) are left to the
+	// caller.
+	Search(q search.Query) ([]map[string]any, error)
+
+	// History returns every recorded version, newest first. An empty id widens
+	// the scope to the whole type; an empty resourceType to the whole system.
+	History(resourceType, id string) ([]Version, error)
+
+	// VRead returns one specific version, including deletion tombstones.
+	VRead(resourceType, id string, versionID int) (v Version, ok bool, err error)
+}
+
+// Version is a single entry in a resource's history.
+// Deleted versions record when the resource was removed and carry no Resource.
+type Version struct {
+	ResourceType string
+	ID           string
+	VersionID    int
+	LastUpdated  time.Time
+	Deleted      bool
+	Resource     map[string]any
+}
+
+// ConflictError reports a write whose expected version no longer matches
+// the stored one. Current is 0 when the resource does not exist.
+type ConflictError struct {
+	ResourceType string
+	ID           string
+	Expected     int
+	Current      int
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("version conflict on %s/%s: expected %d, current %d", e.ResourceType, e.ID, e.Expected, e.Current)
 }
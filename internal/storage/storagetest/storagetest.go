@@ -0,0 +1,668 @@
+// Package storagetest is a conformance suite for storage.ResourceStore
+// implementations. Every backend runs it from its own tests so they all
+// behave the way the handlers expect:
+//
+//	func TestStore_Conformance(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) storage.ResourceStore { return NewStore() })
+//	}
+package storagetest
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go-fhir-server/internal/fhir"
+	"go-fhir-server/internal/search"
+	"go-fhir-server/internal/storage"
+)
+
+// Factory returns a new, empty store. It is called once per subtest and
+// should register any cleanup with t.
+type Factory func(t *testing.T) storage.ResourceStore
+
+// Run runs the whole suite against stores made by newStore.
+func Run(t *testing.T, newStore Factory) {
+	tests := []struct {
+		name string
+		fn   func(*testing.T, storage.ResourceStore)
+	}{
+		{"PutGetDeleteList", testPutGetDeleteList},
+		{"TypesAreIsolated", testTypesAreIsolated},
+		{"CallersCantMutateStoredResources", testIsolation},
+		{"HistoryKeepsEveryVersion", testHistory},
+		{"ExpectedVersionConflict", testExpectedVersion},
+		{"ConcurrentUpdates", testConcurrentUpdates},
+		{"ConcurrentConditionalCreates", testConcurrentConditionalCreates},
+		{"TransactionCommits", testTransactionCommits},
+		{"TransactionRollsBackOnError", testTransactionRollback},
+		{"TransactionRollsBackOnPanic", testTransactionPanic},
+		{"Search", testSearch},
+		{"SearchFollowsWrites", testSearchFollowsWrites},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) { tc.fn(t, newStore(t)) })
+	}
+}
+
+func put(t *testing.T, s storage.Tx, resource map[string]any, expectedVersion int) map[string]any {
+	t.Helper()
+	rt, _ := resource["resourceType"].(string)
+	id, _ := resource["id"].(string)
+	stored, err := s.Put(rt, id, resource, expectedVersion)
+	if err != nil {
+		t.Fatalf("put %s/%s err: %v", rt, id, err)
+	}
+	return stored
+}
+
+func versionID(t *testing.T, resource map[string]any) string {
+	t.Helper()
+	meta, ok := resource["meta"].(map[string]any)
+	if !ok {
+		t.Fatalf("expected meta on %v", resource)
+	}
+	v, _ := meta["versionId"].(string)
+	return v
+}
+
+func patient(id, family string) map[string]any {
+	return map[string]any{
+		"resourceType": "Patient",
+		"id":           id,
+		"name":         []any{map[string]any{"family": family}},
+	}
+}
+
+func ids(resources []map[string]any) string {
+	out := make([]string, len(resources))
+	for i, r := range resources {
+		out[i], _ = r["id"].(string)
+	}
+	return strings.Join(out, ",")
+}
+
+func testPutGetDeleteList(t *testing.T, s storage.ResourceStore) {
+	stored := put(t, s, patient("b", "Smith"), storage.AnyVersion)
+	if versionID(t, stored) != "1" {
+		t.Fatalf("expected versionId 1, got %v", versionID(t, stored))
+	}
+	lastUpdated, _ := stored["meta"].(map[string]any)["lastUpdated"].(string)
+	if _, err := time.Parse(time.RFC3339, lastUpdated); err != nil {
+		t.Fatalf("expected meta.lastUpdated to be an instant, got %q", lastUpdated)
+	}
+
+	got, ok, err := s.Get("Patient", "b")
+	if err != nil || !ok {
+		t.Fatalf("get ok=%v err=%v", ok, err)
+	}
+	if got["id"] != "b" || versionID(t, got) != "1" {
+		t.Fatalf("unexpected resource %v", got)
+	}
+	if _, ok, err := s.Get("Patient", "missing"); ok || err != nil {
+		t.Fatalf("expected missing resource to miss, ok=%v err=%v", ok, err)
+	}
+
+	put(t, s, patient("c", "Jones"), 0)
+	put(t, s, patient("a", "Brown"), 0)
+	all, err := s.List("Patient")
+	if err != nil {
+		t.Fatalf("list err: %v", err)
+	}
+	if ids(all) != "a,b,c" {
+		t.Fatalf("expected patients ordered by id, got %s", ids(all))
+	}
+
+	ok, err = s.Delete("Patient", "b", storage.AnyVersion)
+	if err != nil || !ok {
+		t.Fatalf("delete ok=%v err=%v", ok, err)
+	}
+	if _, ok, _ := s.Get("Patient", "b"); ok {
+		t.Fatalf("expected deleted resource to miss")
+	}
+	if ok, err := s.Delete("Patient", "b", storage.AnyVersion); ok || err != nil {
+		t.Fatalf("expected second delete to find nothing, ok=%v err=%v", ok, err)
+	}
+	if all, _ := s.List("Patient"); ids(all) != "a,c" {
+		t.Fatalf("expected a,c after delete, got %s", ids(all))
+	}
+	if empty, err := s.List("Observation"); err != nil || len(empty) != 0 {
+		t.Fatalf("expected no observations, got %v (err %v)", empty, err)
+	}
+}
+
+func testTypesAreIsolated(t *testing.T, s storage.ResourceStore) {
+	put(t, s, map[string]any{"resourceType": "Patient", "id": "x"}, storage.AnyVersion)
+	put(t, s, map[string]any{"resourceType": "Observation", "id": "x"}, storage.AnyVersion)
+
+	got, ok, err := s.Get("Patient", "x")
+	if err != nil || !ok {
+		t.Fatalf("get patient ok=%v err=%v", ok, err)
+	}
+	if got["resourceType"] != "Patient" {
+		t.Fatalf("expected Patient, got %v", got["resourceType"])
+	}
+	if _, ok, _ := s.Get("Practitioner", "x"); ok {
+		t.Fatalf("expected unknown type to miss")
+	}
+
+	if ok, _ := s.Delete("Observation", "x", storage.AnyVersion); !ok {
+		t.Fatalf("expected observation delete ok")
+	}
+	if all, _ := s.List("Patient"); len(all) != 1 {
+		t.Fatalf("expected patient to survive observation delete, got %d", len(all))
+	}
+
+	stored := put(t, s, map[string]any{"resourceType": "Patient", "id": "x"}, 1)
+	if v := versionID(t, stored); v != "2" {
+		t.Fatalf("expected patient version 2, got %v", v)
+	}
+}
+
+func testIsolation(t *testing.T, s storage.ResourceStore) {
+	in := patient("p", "Smith")
+	stored := put(t, s, in, 0)
+
+	// Neither the input nor any returned copy is shared with the store.
+	in["name"].([]any)[0].(map[string]any)["family"] = "changed"
+	stored["active"] = true
+	got, _, _ := s.Get("Patient", "p")
+	got["gender"] = "other"
+	list, _ := s.List("Patient")
+	list[0]["birthDate"] = "2000"
+
+	again, _, _ := s.Get("Patient", "p")
+	family := again["name"].([]any)[0].(map[string]any)["family"]
+	if family != "Smith" || again["active"] != nil || again["gender"] != nil || again["birthDate"] != nil {
+		t.Fatalf("expected stored resource to be isolated from callers, got %v", again)
+	}
+}
+
+func testHistory(t *testing.T, s storage.ResourceStore) {
+	put(t, s, patient("p1", "Old"), storage.AnyVersion)
+	put(t, s, patient("p1", "New"), storage.AnyVersion)
+	put(t, s, patient("p2", "Other"), storage.AnyVersion)
+	put(t, s, map[string]any{"resourceType": "Observation", "id": "o1"}, storage.AnyVersion)
+	if ok, err := s.Delete("Patient", "p1", storage.AnyVersion); err != nil || !ok {
+		t.Fatalf("delete ok=%v err=%v", ok, err)
+	}
+
+	v1, ok, err := s.VRead("Patient", "p1", 1)
+	if err != nil || !ok {
+		t.Fatalf("vread ok=%v err=%v", ok, err)
+	}
+	name := v1.Resource["name"].([]any)[0].(map[string]any)
+	if name["family"] != "Old" {
+		t.Fatalf("expected version 1 to keep family Old, got %v", name["family"])
+	}
+	if v1.ResourceType != "Patient" || v1.ID != "p1" || v1.VersionID != 1 || v1.Deleted || v1.LastUpdated.IsZero() {
+		t.Fatalf("unexpected version %+v", v1)
+	}
+	if v, ok, _ := s.VRead("Patient", "p1", 3); !ok || !v.Deleted || v.Resource != nil {
+		t.Fatalf("expected vread of the tombstone, got %+v ok=%v", v, ok)
+	}
+	if _, ok, _ := s.VRead("Patient", "p1", 4); ok {
+		t.Fatalf("expected vread of a future version to miss")
+	}
+
+	hist, err := s.History("Patient", "p1")
+	if err != nil {
+		t.Fatalf("history err: %v", err)
+	}
+	if len(hist) != 3 {
+		t.Fatalf("expected 3 versions, got %d", len(hist))
+	}
+	if !hist[0].Deleted || hist[0].VersionID != 3 || hist[0].Resource != nil {
+		t.Fatalf("expected newest entry to be the version 3 tombstone, got %+v", hist[0])
+	}
+	if hist[2].VersionID != 1 {
+		t.Fatalf("expected oldest entry last, got version %d", hist[2].VersionID)
+	}
+	for _, v := range hist[1:] {
+		if got := versionID(t, v.Resource); got != strconv.Itoa(v.VersionID) {
+			t.Fatalf("version %d carries meta.versionId %s", v.VersionID, got)
+		}
+	}
+
+	if typeHist, _ := s.History("Patient", ""); len(typeHist) != 4 {
+		t.Fatalf("expected 4 Patient versions, got %d", len(typeHist))
+	}
+	sysHist, _ := s.History("", "")
+	if len(sysHist) != 5 {
+		t.Fatalf("expected 5 versions system-wide, got %d", len(sysHist))
+	}
+	if sysHist[0].ID != "p1" || !sysHist[0].Deleted || sysHist[4].ID != "p1" || sysHist[4].VersionID != 1 {
+		t.Fatalf("expected system history newest first, got %+v", sysHist)
+	}
+	if none, err := s.History("Patient", "missing"); err != nil || len(none) != 0 {
+		t.Fatalf("expected no history for a missing resource, got %v (err %v)", none, err)
+	}
+
+	// Recreating after a delete keeps counting instead of reusing version 1.
+	put(t, s, patient("p1", "Again"), storage.AnyVersion)
+	cur, _, _ := s.Get("Patient", "p1")
+	if v := versionID(t, cur); v != "4" {
+		t.Fatalf("expected recreated version 4, got %v", v)
+	}
+
+	// Mutating a returned version must not leak into the store.
+	v1.Resource["id"] = "mutated"
+	hist[1].Resource["id"] = "mutated"
+	again, _, _ := s.VRead("Patient", "p1", 1)
+	if again.Resource["id"] != "p1" {
+		t.Fatalf("expected stored history to be isolated from callers")
+	}
+	if v2, _, _ := s.VRead("Patient", "p1", 2); v2.Resource["id"] != "p1" {
+		t.Fatalf("expected stored history to be isolated from callers")
+	}
+}
+
+func testExpectedVersion(t *testing.T, s storage.ResourceStore) {
+	put(t, s, map[string]any{"resourceType": "Patient", "id": "p"}, 0)
+
+	_, err := s.Put("Patient", "p", map[string]any{"resourceType": "Patient", "id": "p"}, 0)
+	var conflict *storage.ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("expected ConflictError creating over an existing resource, got %v", err)
+	}
+	if conflict.ResourceType != "Patient" || conflict.ID != "p" || conflict.Expected != 0 || conflict.Current != 1 {
+		t.Fatalf("unexpected conflict %+v", conflict)
+	}
+
+	put(t, s, map[string]any{"resourceType": "Patient", "id": "p"}, 1)
+	if _, err := s.Put("Patient", "p", map[string]any{"resourceType": "Patient", "id": "p"}, 1); !errors.As(err, &conflict) {
+		t.Fatalf("expected ConflictError for stale version, got %v", err)
+	}
+	if _, err := s.Delete("Patient", "p", 1); !errors.As(err, &conflict) {
+		t.Fatalf("expected ConflictError deleting a stale version, got %v", err)
+	}
+	if hist, _ := s.History("Patient", "p"); len(hist) != 2 {
+		t.Fatalf("expected rejected writes to leave history untouched, got %d versions", len(hist))
+	}
+	if ok, err := s.Delete("Patient", "p", 2); err != nil || !ok {
+		t.Fatalf("delete at current version ok=%v err=%v", ok, err)
+	}
+
+	// A deleted or never created resource is at version 0.
+	_, err = s.Put("Patient", "p", map[string]any{"resourceType": "Patient", "id": "p"}, 3)
+	if !errors.As(err, &conflict) || conflict.Current != 0 {
+		t.Fatalf("expected ConflictError with current 0 after delete, got %v", err)
+	}
+	if _, err := s.Put("Patient", "new", map[string]any{"resourceType": "Patient", "id": "new"}, 1); !errors.As(err, &conflict) || conflict.Current != 0 {
+		t.Fatalf("expected ConflictError with current 0 for a new resource, got %v", err)
+	}
+	if stored := put(t, s, map[string]any{"resourceType": "Patient", "id": "p"}, 0); versionID(t, stored) != "4" {
+		t.Fatalf("expected recreate at version 4, got %v", versionID(t, stored))
+	}
+}
+
+// testConcurrentUpdates is meant to run under -race (see Makefile).
+func testConcurrentUpdates(t *testing.T, s storage.ResourceStore) {
+	put(t, s, map[string]any{"resourceType": "Patient", "id": "p"}, 0)
+
+	const workers, perWorker = 16, 50
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				// Blind writes and optimistic read-modify-write loops interleave.
+				if i%2 == 0 {
+					if _, err := s.Put("Patient", "p", map[string]any{"resourceType": "Patient", "id": "p", "worker": w}, storage.AnyVersion); err != nil {
+						t.Errorf("blind put err: %v", err)
+					}
+					continue
+				}
+				for {
+					cur, _, err := s.Get("Patient", "p")
+					if err != nil {
+						t.Errorf("get err: %v", err)
+						return
+					}
+					v, _ := strconv.Atoi(cur["meta"].(map[string]any)["versionId"].(string))
+					_, err = s.Put("Patient", "p", map[string]any{"resourceType": "Patient", "id": "p", "worker": w}, v)
+					var conflict *storage.ConflictError
+					if errors.As(err, &conflict) {
+						continue
+					}
+					if err != nil {
+						t.Errorf("put err: %v", err)
+					}
+					break
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	hist, err := s.History("Patient", "p")
+	if err != nil {
+		t.Fatalf("history err: %v", err)
+	}
+	want := 1 + workers*perWorker
+	if len(hist) != want {
+		t.Fatalf("expected %d versions, got %d", want, len(hist))
+	}
+	// Newest first, strictly decreasing, no gaps: versions never go backwards.
+	for i, v := range hist {
+		if v.VersionID != want-i {
+			t.Fatalf("history[%d] has version %d, want %d", i, v.VersionID, want-i)
+		}
+		if got := v.Resource["meta"].(map[string]any)["versionId"]; got != strconv.Itoa(v.VersionID) {
+			t.Fatalf("history[%d] meta.versionId=%v does not match %d", i, got, v.VersionID)
+		}
+	}
+
+	// Of several writers expecting the same version, exactly one wins.
+	var mu sync.Mutex
+	wins := 0
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			_, err := s.Put("Patient", "p", map[string]any{"resourceType": "Patient", "id": "p", "worker": w}, want)
+			var conflict *storage.ConflictError
+			switch {
+			case err == nil:
+				mu.Lock()
+				wins++
+				mu.Unlock()
+			case !errors.As(err, &conflict):
+				t.Errorf("put err: %v", err)
+			}
+		}(w)
+	}
+	wg.Wait()
+	if wins != 1 {
+		t.Fatalf("expected exactly one conditional update to win, got %d", wins)
+	}
+}
+
+// testConcurrentConditionalCreates runs the search-then-create of an
+// If-None-Exist retry from several goroutines at once. Transactions must be
+// isolated from each other, so only the first one finds no match and creates.
+func testConcurrentConditionalCreates(t *testing.T, s storage.ResourceStore) {
+	q, err := search.ParseString(fhir.Patient(), "identifier=http://mrn|retry")
+	if err != nil {
+		t.Fatalf("parse err: %v", err)
+	}
+
+	const workers = 16
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			err := s.Transaction(func(tx storage.Tx) error {
+				matches, err := tx.Search(q)
+				if err != nil || len(matches) > 0 {
+					return err
+				}
+				// Give the other workers time to search before this one writes.
+				time.Sleep(time.Millisecond)
+				id := fmt.Sprintf("w%d", w)
+				_, err = tx.Put("Patient", id, map[string]any{
+					"resourceType": "Patient",
+					"id":           id,
+					"identifier":   []any{map[string]any{"system": "http://mrn", "value": "retry"}},
+				}, 0)
+				return err
+			})
+			if err != nil {
+				t.Errorf("worker %d transaction err: %v", w, err)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	got, err := s.Search(q)
+	if err != nil {
+		t.Fatalf("search err: %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected exactly one patient created, got %s", ids(got))
+	}
+}
+
+func testTransactionCommits(t *testing.T, s storage.ResourceStore) {
+	put(t, s, map[string]any{"resourceType": "Patient", "id": "gone"}, 0)
+	err := s.Transaction(func(tx storage.Tx) error {
+		put(t, tx, map[string]any{"resourceType": "Patient", "id": "a"}, 0)
+		put(t, tx, map[string]any{"resourceType": "Patient", "id": "b"}, 0)
+		_, err := tx.Delete("Patient", "gone", 1)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("transaction err: %v", err)
+	}
+	if all, _ := s.List("Patient"); ids(all) != "a,b" {
+		t.Fatalf("expected a,b committed, got %s", ids(all))
+	}
+	if hist, _ := s.History("", ""); len(hist) != 4 {
+		t.Fatalf("expected 4 versions, got %d", len(hist))
+	}
+}
+
+func testTransactionRollback(t *testing.T, s storage.ResourceStore) {
+	put(t, s, map[string]any{"resourceType": "Patient", "id": "keep", "active": true}, 0)
+	put(t, s, map[string]any{"resourceType": "Patient", "id": "other"}, 0)
+
+	boom := errors.New("boom")
+	err := s.Transaction(func(tx storage.Tx) error {
+		put(t, tx, map[string]any{"resourceType": "Patient", "id": "keep", "active": false}, 1)
+		put(t, tx, map[string]any{"resourceType": "Patient", "id": "new"}, 0)
+		if ok, err := tx.Delete("Patient", "other", storage.AnyVersion); !ok || err != nil {
+			t.Errorf("delete in transaction ok=%v err=%v", ok, err)
+		}
+		// Reads inside the transaction see its own writes.
+		if _, ok, _ := tx.Get("Patient", "new"); !ok {
+			t.Errorf("expected transaction to see its own create")
+		}
+		if all, _ := tx.List("Patient"); ids(all) != "keep,new" {
+			t.Errorf("expected transaction to list keep,new, got %s", ids(all))
+		}
+		if hist, _ := tx.History("Patient", "keep"); len(hist) != 2 {
+			t.Errorf("expected transaction to see its own history, got %d versions", len(hist))
+		}
+		return boom
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("expected callback error, got %v", err)
+	}
+
+	got, _, _ := s.Get("Patient", "keep")
+	if got["active"] != true || versionID(t, got) != "1" {
+		t.Fatalf("expected update to be rolled back, got %v", got)
+	}
+	if _, ok, _ := s.Get("Patient", "new"); ok {
+		t.Fatalf("expected create to be rolled back")
+	}
+	if _, ok, _ := s.Get("Patient", "other"); !ok {
+		t.Fatalf("expected delete to be rolled back")
+	}
+	if hist, _ := s.History("", ""); len(hist) != 2 {
+		t.Fatalf("expected rolled back writes to leave no history, got %d versions", len(hist))
+	}
+
+	// Version numbering continues as if the transaction never happened.
+	if stored := put(t, s, map[string]any{"resourceType": "Patient", "id": "keep"}, 1); versionID(t, stored) != "2" {
+		t.Fatalf("expected version 2 after rollback, got %v", versionID(t, stored))
+	}
+	if stored := put(t, s, map[string]any{"resourceType": "Patient", "id": "new"}, 0); versionID(t, stored) != "1" {
+		t.Fatalf("expected rolled back create to leave version 1 free, got %v", versionID(t, stored))
+	}
+}
+
+func testTransactionPanic(t *testing.T, s storage.ResourceStore) {
+	func() {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Fatalf("expected the panic to propagate, got %v", p)
+			}
+		}()
+		s.Transaction(func(tx storage.Tx) error {
+			put(t, tx, map[string]any{"resourceType": "Patient", "id": "a"}, 0)
+			panic("boom")
+		})
+	}()
+
+	if _, ok, _ := s.Get("Patient", "a"); ok {
+		t.Fatalf("expected create to be rolled back")
+	}
+	// The store is still usable.
+	put(t, s, map[string]any{"resourceType": "Patient", "id": "a"}, 0)
+}
+
+func searchIDs(t *testing.T, s storage.Tx, rt fhir.ResourceType, query string) string {
+	t.Helper()
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	q, err := search.Parse(rt, values)
+	if err != nil {
+		t.Fatalf("%s: parse err: %v", query, err)
+	}
+	got, err := s.Search(q)
+	if err != nil {
+		t.Fatalf("%s: search err: %v", query, err)
+	}
+	return ids(got)
+}
+
+func seedSearch(t *testing.T, s storage.ResourceStore) {
+	t.Helper()
+	for _, r := range []map[string]any{
+		{
+			"resourceType": "Patient", "id": "p1", "gender": "female", "birthDate": "1970-05-01",
+			"identifier":          []any{map[string]any{"system": "http://mrn", "value": "001"}},
+			"name":                []any{map[string]any{"family": "Smith", "given": []any{"Anna"}}},
+			"generalPractitioner": []any{map[string]any{"reference": "Practitioner/dr1"}},
+		},
+		{
+			"resourceType": "Patient", "id": "p2", "gender": "male", "birthDate": "1990",
+			"identifier": []any{map[string]any{"system": "http://other", "value": "001"}},
+			"name":       []any{map[string]any{"family": "Smythe", "given": []any{"José"}}},
+		},
+		{
+			"resourceType": "Patient", "id": "p3", "gender": "female", "birthDate": "1985-11-30",
+			"name":                 []any{map[string]any{"family": "Ångström"}},
+			"managingOrganization": map[string]any{"reference": "Organization/org1"},
+		},
+		{
+			"resourceType": "Observation", "id": "o1", "status": "final",
+			"code":    map[string]any{"coding": []any{map[string]any{"system": "http://loinc.org", "code": "1234-5"}}},
+			"subject": map[string]any{"reference": "Patient/p1"},
+		},
+		{
+			"resourceType": "Observation", "id": "o2", "status": "preliminary",
+			"code":    map[string]any{"coding": []any{map[string]any{"system": "http://loinc.org", "code": "9999-9"}}},
+			"subject": map[string]any{"reference": "Patient/p2"},
+		},
+	} {
+		put(t, s, r, 0)
+	}
+}
+
+func testSearch(t *testing.T, s storage.ResourceStore) {
+	seedSearch(t, s)
+
+	patients := map[string]string{
+		"":                                        "p1,p2,p3",
+		"_id=p2,p3":                               "p2,p3",
+		"gender=female":                           "p1,p3",
+		"gender=female,male":                      "p1,p2,p3",
+		"identifier=001":                          "p1,p2",
+		"identifier=http://mrn|001":               "p1",
+		"identifier=http://mrn|":                  "p1",
+		"identifier=|001":                         "",
+		"family=sm":                               "p1,p2",
+		"family=SMI":                              "p1",
+		"family=angstrom":                         "p3",
+		"family:exact=Smith":                      "p1",
+		"family:exact=smith":                      "",
+		"family:contains=th":                      "p1,p2",
+		"given=jose":                              "p2",
+		"name=anna":                               "p1",
+		"birthdate=1970":                          "p1",
+		"birthdate=lt1980":                        "p1",
+		"birthdate=ge1985-11":                     "p2,p3",
+		"birthdate=ne1990":                        "p1,p3",
+		"birthdate=1985&gender=female":            "p3",
+		"birthdate=ge1980&birthdate=lt1989":       "p3",
+		"general-practitioner=Practitioner/dr1":   "p1",
+		"general-practitioner=dr1":                "p1",
+		"organization=org1":                       "p3",
+		"organization:Organization=org1":          "p3",
+		"family=sm&gender=male":                   "p2",
+		"_lastUpdated=gt2000-01-01&gender=female": "p1,p3",
+		"_lastUpdated=lt2000-01-01":               "",
+	}
+	for query, want := range patients {
+		if got := searchIDs(t, s, fhir.Patient(), query); got != want {
+			t.Errorf("Patient?%s: expected %q, got %q", query, want, got)
+		}
+	}
+
+	observations := map[string]string{
+		"code=1234-5":                  "o1",
+		"code=http://loinc.org|9999-9": "o2",
+		"status=final,preliminary":     "o1,o2",
+		"subject=Patient/p2":           "o2",
+		"patient=p1":                   "o1",
+		"subject:Patient=p1":           "o1",
+	}
+	for query, want := range observations {
+		if got := searchIDs(t, s, fhir.Observation(), query); got != want {
+			t.Errorf("Observation?%s: expected %q, got %q", query, want, got)
+		}
+	}
+}
+
+func testSearchFollowsWrites(t *testing.T, s storage.ResourceStore) {
+	seedSearch(t, s)
+
+	put(t, s, map[string]any{"resourceType": "Patient", "id": "p1", "gender": "other", "name": []any{map[string]any{"family": "Taylor"}}}, 1)
+	if ok, err := s.Delete("Patient", "p3", storage.AnyVersion); !ok || err != nil {
+		t.Fatalf("delete ok=%v err=%v", ok, err)
+	}
+	for query, want := range map[string]string{
+		"gender=female":      "",
+		"gender=other":       "p1",
+		"family=smith":       "",
+		"family=tay":         "p1",
+		"birthdate=1970":     "",
+		"organization=org1":  "",
+		"identifier=001":     "p2",
+		"family:contains=ay": "p1",
+	} {
+		if got := searchIDs(t, s, fhir.Patient(), query); got != want {
+			t.Errorf("Patient?%s: expected %q, got %q", query, want, got)
+		}
+	}
+
+	// A transaction searches its own writes; a rollback undoes them.
+	err := s.Transaction(func(tx storage.Tx) error {
+		put(t, tx, map[string]any{"resourceType": "Patient", "id": "p4", "gender": "female"}, 0)
+		put(t, tx, map[string]any{"resourceType": "Patient", "id": "p2", "gender": "female"}, 1)
+		if got := searchIDs(t, tx, fhir.Patient(), "gender=female"); got != "p2,p4" {
+			t.Errorf("expected the transaction to find p2,p4, got %q", got)
+		}
+		return errors.New("roll back")
+	})
+	if err == nil {
+		t.Fatalf("expected transaction error")
+	}
+	if got := searchIDs(t, s, fhir.Patient(), "gender=female"); got != "" {
+		t.Fatalf("expected rolled back writes to leave search results alone, got %q", got)
+	}
+	if got := searchIDs(t, s, fhir.Patient(), "gender=male"); got != "p2" {
+		t.Fatalf("expected p2 to be male again after rollback, got %q", got)
+	}
+}